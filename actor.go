@@ -0,0 +1,58 @@
+package basecoin
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/crypto/ripemd160"
+
+	crypto "github.com/tepleton/go-crypto"
+	wire "github.com/tepleton/go-wire"
+	"github.com/tepleton/go-wire/data"
+)
+
+// NameSigs is the app of the actors for keys that signed the tx
+const NameSigs = "sigs"
+
+// Actor is anyone who can authorize a tx: a key that signed it,
+// a module acting on its own behalf, or an account on another chain.
+// The app is the module that vouches for the actor, and the address
+// only has a meaning inside that app.
+type Actor struct {
+	ChainID string     `json:"chain"` // "" for this chain
+	App     string     `json:"app"`
+	Address data.Bytes `json:"addr"`
+}
+
+// NewActor is an actor of the app on this chain
+func NewActor(app string, addr []byte) Actor {
+	return Actor{App: app, Address: addr}
+}
+
+// SigActor is the actor for a key that signed the tx
+func SigActor(pk crypto.PubKey) Actor {
+	return NewActor(NameSigs, pk.Address())
+}
+
+func (a Actor) Equals(b Actor) bool {
+	return a.ChainID == b.ChainID &&
+		a.App == b.App &&
+		bytes.Equal(a.Address, b.Address)
+}
+
+// AccountAddress is the address of the account owned by the actor.
+// Keys own the account of their address, like always. Every other
+// actor owns the hash of the actor, so no app can claim the account
+// of a key, or of another app.
+func (a Actor) AccountAddress() []byte {
+	if a.ChainID == "" && a.App == NameSigs {
+		return a.Address
+	}
+	hasher := ripemd160.New()
+	hasher.Write(wire.BinaryBytes(a)) // does not error
+	return hasher.Sum(nil)
+}
+
+func (a Actor) String() string {
+	return fmt.Sprintf("Actor{%s/%s/%X}", a.ChainID, a.App, a.Address)
+}
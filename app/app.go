@@ -7,10 +7,14 @@ import (
 
 	wrsp "github.com/tepleton/wrsp/types"
 	wire "github.com/tepleton/go-wire"
+	"github.com/tepleton/go-wire/data"
 	eyes "github.com/tepleton/merkleeyes/client"
 	cmn "github.com/tepleton/tmlibs/common"
 	"github.com/tepleton/tmlibs/log"
 
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/errors"
+	"github.com/tepleton/basecoin/handlers"
 	sm "github.com/tepleton/basecoin/state"
 	"github.com/tepleton/basecoin/types"
 	"github.com/tepleton/basecoin/version"
@@ -22,37 +26,55 @@ const (
 )
 
 type Basecoin struct {
-	eyesCli    *eyes.Client
-	state      *sm.State
-	cacheState *sm.State
-	plugins    *types.Plugins
-	logger     log.Logger
+	eyesCli   *eyes.Client
+	state     *sm.State
+	mempool   *Mempool
+	delivered [][]byte // txs in the current block
+	plugins   *types.Plugins
+	handler   basecoin.Handler // optional, runs basecoin.Tx instead of types.Tx
+	height    uint64           // height of the current block
+	time      uint64           // time of the current block
+	logger    log.Logger
 }
 
 func NewBasecoin(eyesCli *eyes.Client) *Basecoin {
-	state := sm.NewState(eyesCli)
+	state := sm.NewState(types.NewEyesStore(eyesCli))
 	plugins := types.NewPlugins()
-	return &Basecoin{
-		eyesCli:    eyesCli,
-		state:      state,
-		cacheState: nil,
-		plugins:    plugins,
-		logger:     log.NewNopLogger(),
+	app := &Basecoin{
+		eyesCli: eyesCli,
+		state:   state,
+		plugins: plugins,
+		logger:  log.NewNopLogger(),
 	}
+	app.mempool = NewMempool(state, app.checkTx)
+	return app
 }
 
 func (app *Basecoin) SetLogger(l log.Logger) {
 	app.logger = l
 	app.state.SetLogger(l.With("module", "state"))
+	app.mempool.SetLogger(l.With("module", "mempool"))
 }
 
-// XXX For testing, not thread safe!
+// GetState returns a cache over the current (uncommitted) state.
+// The lock is only held while wrapping it, so only use this
+// when no blocks are being processed (eg. in tests or at startup).
+// Use Mempool().Query to safely read the CheckTx state.
 func (app *Basecoin) GetState() *sm.State {
+	app.mempool.Lock()
+	defer app.mempool.Unlock()
 	return app.state.CacheWrap()
 }
 
+// Mempool manages the state for CheckTx
+func (app *Basecoin) Mempool() *Mempool {
+	return app.mempool
+}
+
 // WRSP::Info
 func (app *Basecoin) Info() wrsp.ResponseInfo {
+	app.mempool.Lock()
+	defer app.mempool.Unlock()
 	resp, err := app.eyesCli.InfoSync()
 	if err != nil {
 		cmn.PanicCrisis(err)
@@ -68,8 +90,24 @@ func (app *Basecoin) RegisterPlugin(plugin types.Plugin) {
 	app.plugins.RegisterPlugin(plugin)
 }
 
+// SetHandler switches the app to decode all txs as basecoin.Tx and
+// run them through the given handler stack, rather than decoding
+// types.Tx for sm.ExecTx. Plugins still get all other WRSP calls.
+func (app *Basecoin) SetHandler(handler basecoin.Handler) {
+	app.handler = handler
+}
+
+// SetDefaultHandler sets handlers.DefaultStack as the handler, with
+// the registered plugins behind its router, so they are called by
+// a txs.PluginTx rather than a types.AppTx
+func (app *Basecoin) SetDefaultHandler(minFee types.Coins) {
+	app.SetHandler(handlers.DefaultStack(minFee, app.plugins))
+}
+
 // WRSP::SetOption
 func (app *Basecoin) SetOption(key string, value string) string {
+	app.mempool.Lock()
+	defer app.mempool.Unlock()
 	pluginName, key := splitKey(key)
 	if pluginName != PluginNameBase {
 		// Set option on plugin
@@ -111,6 +149,14 @@ func (app *Basecoin) DeliverTx(txBytes []byte) (res wrsp.Result) {
 		return wrsp.ErrBaseEncodingError.AppendLog("Tx size exceeds maximum")
 	}
 
+	app.mempool.Lock()
+	defer app.mempool.Unlock()
+	app.delivered = append(app.delivered, txBytes)
+
+	if app.handler != nil {
+		return app.runHandler(app.state, txBytes, false)
+	}
+
 	// Decode tx
 	var tx types.Tx
 	err := wire.ReadBinaryBytes(txBytes, &tx)
@@ -132,6 +178,16 @@ func (app *Basecoin) CheckTx(txBytes []byte) (res wrsp.Result) {
 		return wrsp.ErrBaseEncodingError.AppendLog("Tx size exceeds maximum")
 	}
 
+	return app.mempool.CheckTx(txBytes)
+}
+
+// checkTx runs a tx against the mempool state, it is the CheckFunc
+// for app.mempool, which holds the lock
+func (app *Basecoin) checkTx(state *sm.State, txBytes []byte) wrsp.Result {
+	if app.handler != nil {
+		return app.runHandler(state, txBytes, true)
+	}
+
 	// Decode tx
 	var tx types.Tx
 	err := wire.ReadBinaryBytes(txBytes, &tx)
@@ -140,7 +196,7 @@ func (app *Basecoin) CheckTx(txBytes []byte) (res wrsp.Result) {
 	}
 
 	// Validate tx
-	res = sm.ExecTx(app.cacheState, app.plugins, tx, true, nil)
+	res := sm.ExecTx(state, app.plugins, tx, true, nil)
 	if res.IsErr() {
 		return res.PrependLog("Error in CheckTx")
 	}
@@ -161,7 +217,10 @@ func (app *Basecoin) Query(reqQuery wrsp.RequestQuery) (resQuery wrsp.ResponseQu
 		reqQuery.Data = types.AccountKey(reqQuery.Data)
 	}
 
+	// don't read the store while a block is committed
+	app.mempool.Lock()
 	resQuery, err := app.eyesCli.QuerySync(reqQuery)
+	app.mempool.Unlock()
 	if err != nil {
 		resQuery.Log = "Failed to query MerkleEyes: " + err.Error()
 		resQuery.Code = wrsp.CodeType_InternalError
@@ -172,21 +231,29 @@ func (app *Basecoin) Query(reqQuery wrsp.RequestQuery) (resQuery wrsp.ResponseQu
 
 // WRSP::Commit
 func (app *Basecoin) Commit() (res wrsp.Result) {
+	app.mempool.Lock()
+	defer app.mempool.Unlock()
 
 	// Commit state
 	res = app.state.Commit()
-
-	// Wrap the committed state in cache for CheckTx
-	app.cacheState = app.state.CacheWrap()
-
 	if res.IsErr() {
 		cmn.PanicSanity("Error getting hash: " + res.Error())
 	}
+
+	// Rebuild the CheckTx state on the committed state,
+	// and recheck all pending txs not in this block
+	evicted := app.mempool.Update(app.delivered)
+	if len(evicted) > 0 {
+		app.logger.Info("Rechecked mempool", "evicted", len(evicted))
+	}
+	app.delivered = nil
 	return res
 }
 
 // WRSP::InitChain
 func (app *Basecoin) InitChain(validators []*wrsp.Validator) {
+	app.mempool.Lock()
+	defer app.mempool.Unlock()
 	for _, plugin := range app.plugins.GetList() {
 		plugin.InitChain(app.state, validators)
 	}
@@ -194,6 +261,12 @@ func (app *Basecoin) InitChain(validators []*wrsp.Validator) {
 
 // WRSP::BeginBlock
 func (app *Basecoin) BeginBlock(hash []byte, header *wrsp.Header) {
+	app.mempool.Lock()
+	defer app.mempool.Unlock()
+	if header != nil {
+		app.height = header.Height
+		app.time = header.Time
+	}
 	for _, plugin := range app.plugins.GetList() {
 		plugin.BeginBlock(app.state, hash, header)
 	}
@@ -201,6 +274,8 @@ func (app *Basecoin) BeginBlock(hash []byte, header *wrsp.Header) {
 
 // WRSP::EndBlock
 func (app *Basecoin) EndBlock(height uint64) (res wrsp.ResponseEndBlock) {
+	app.mempool.Lock()
+	defer app.mempool.Unlock()
 	for _, plugin := range app.plugins.GetList() {
 		pluginRes := plugin.EndBlock(app.state, height)
 		res.Diffs = append(res.Diffs, pluginRes.Diffs...)
@@ -208,6 +283,44 @@ func (app *Basecoin) EndBlock(height uint64) (res wrsp.ResponseEndBlock) {
 	return
 }
 
+// runHandler decodes a basecoin.Tx and runs it through the handler.
+// All changes are made in a cache, and only written on success,
+// so a failing tx never leaves partial changes (like a paid fee).
+func (app *Basecoin) runHandler(state *sm.State, txBytes []byte, isCheckTx bool) wrsp.Result {
+	var tx basecoin.Tx
+	err := data.FromWire(txBytes, &tx)
+	if err != nil {
+		return errors.Result(errors.DecodingError())
+	}
+	err = tx.ValidateBasic()
+	if err != nil {
+		return errors.Result(err)
+	}
+
+	// CheckTx looks ahead to the next block, which includes the tx
+	height := app.height
+	if isCheckTx {
+		height++
+	}
+	ctx := basecoin.NewContext(state.GetChainID(), height, app.time, app.logger)
+	cache := state.CacheWrap()
+	var res basecoin.Result
+	if isCheckTx {
+		res, err = app.handler.CheckTx(ctx, cache, tx)
+	} else {
+		res, err = app.handler.DeliverTx(ctx, cache, tx)
+	}
+	if err != nil {
+		// a tx that paid its fee keeps the payment
+		if errors.IsCharged(err) {
+			cache.Write()
+		}
+		return errors.Result(err)
+	}
+	cache.Write()
+	return res.ToWRSP()
+}
+
 //----------------------------------------
 
 // Splits the string at the first '/'.
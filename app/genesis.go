@@ -2,6 +2,7 @@ package app
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 
 	"github.com/pkg/errors"
@@ -12,35 +13,151 @@ import (
 	cmn "github.com/tepleton/tmlibs/common"
 )
 
+// LoadGenesis validates the genesis file and sets up the initial state.
+// Nothing is written unless all of it is valid, including the
+// plugin options and sections.
 func (app *Basecoin) LoadGenesis(path string) error {
 	genDoc, err := loadGenesis(path)
 	if err != nil {
 		return err
 	}
+	err = app.checkPluginOptions(genDoc.AppOptions.pluginOptions)
+	if err != nil {
+		return err
+	}
+	err = app.checkPluginSections(genDoc.AppOptions.Plugins)
+	if err != nil {
+		return err
+	}
+
+	app.mempool.Lock()
+	defer app.mempool.Unlock()
+	cache := app.state.CacheWrap()
 
 	// set chain_id
-	app.SetOption("base/chain_id", genDoc.ChainID)
+	cache.SetChainID(genDoc.ChainID)
 
 	// set accounts
 	for _, acc := range genDoc.AppOptions.Accounts {
-		accBytes, err := json.Marshal(acc)
-		if err != nil {
-			return err
-		}
-		r := app.SetOption("base/account", string(accBytes))
-		// TODO: SetOption returns an error
-		app.logger.Info("Done setting Account via SetOption", "result", r)
+		addr, _ := acc.GetAddr() // already validated
+		cache.SetAccount(addr, acc.ToAccount())
+		app.logger.Info("SetAccount", "addr", hex.EncodeToString(addr), "acc", acc)
 	}
 
 	// set plugin options
 	for _, kv := range genDoc.AppOptions.pluginOptions {
-		r := app.SetOption(kv.Key, kv.Value)
+		name, key := splitKey(kv.Key)
+		r := app.plugins.GetByName(name).SetOption(cache, key, kv.Value)
+		if r != "Success" {
+			return errors.Errorf("plugin %s option %s: %s", name, key, r)
+		}
 		app.logger.Info("Done setting Plugin key-value pair via SetOption", "result", r, "k", kv.Key, "v", kv.Value)
 	}
 
+	// let the plugins parse their own sections
+	for _, plugin := range app.plugins.GetList() {
+		section, ok := genDoc.AppOptions.Plugins[plugin.Name()]
+		if !ok {
+			continue
+		}
+		err = plugin.(types.GenesisPlugin).InitGenesis(cache, section)
+		if err != nil {
+			return errors.Wrapf(err, "plugin %s genesis", plugin.Name())
+		}
+		app.logger.Info("Done InitGenesis", "plugin", plugin.Name())
+	}
+
+	cache.Write()
+	return nil
+}
+
+// checkPluginOptions makes sure all options go to a registered plugin
+func (app *Basecoin) checkPluginOptions(kvz []keyValue) error {
+	for _, kv := range kvz {
+		name, _ := splitKey(kv.Key)
+		if name == PluginNameBase {
+			// accounts and chain_id have their own sections
+			return errors.Errorf("plugin option %s: base options are set by chain_id and accounts", kv.Key)
+		}
+		if app.plugins.GetByName(name) == nil {
+			return errors.Errorf("plugin option %s: unknown plugin %s", kv.Key, name)
+		}
+	}
+	return nil
+}
+
+// checkPluginSections makes sure all sections go to a registered
+// plugin, which can handle them
+func (app *Basecoin) checkPluginSections(sections map[string]json.RawMessage) error {
+	for name := range sections {
+		plugin := app.plugins.GetByName(name)
+		if plugin == nil {
+			return errors.Errorf("plugin section %s: unknown plugin", name)
+		}
+		if _, ok := plugin.(types.GenesisPlugin); !ok {
+			return errors.Errorf("plugin section %s: plugin has no InitGenesis", name)
+		}
+	}
 	return nil
 }
 
+// ExportGenesis dumps the current chain_id, accounts and plugin state,
+// so a new chain can be started from them.
+// Plugin state is only included for GenesisPlugins and OptionExporters.
+func (app *Basecoin) ExportGenesis() (*FullGenesisDoc, error) {
+	state := app.GetState()
+	genDoc := &FullGenesisDoc{
+		ChainID:    state.GetChainID(),
+		AppOptions: new(GenesisDoc),
+	}
+
+	prefix := types.AccountKey(nil)
+	for it := types.PrefixIterator(state, prefix); it.Valid(); it.Next() {
+		addr := it.Key()[len(prefix):]
+		acc := state.GetAccount(addr)
+		genDoc.AppOptions.Accounts = append(genDoc.AppOptions.Accounts, GenesisAccount{
+			Address:  addr,
+			PubKey:   acc.PubKey,
+			Sequence: acc.Sequence,
+			Balance:  acc.Balance,
+		})
+	}
+
+	for _, plugin := range app.plugins.GetList() {
+		gp, ok := plugin.(types.GenesisPlugin)
+		if !ok {
+			continue
+		}
+		section, err := gp.ExportGenesis(state)
+		if err != nil {
+			return nil, errors.Wrapf(err, "plugin %s genesis", plugin.Name())
+		}
+		if genDoc.AppOptions.Plugins == nil {
+			genDoc.AppOptions.Plugins = make(map[string]json.RawMessage)
+		}
+		genDoc.AppOptions.Plugins[plugin.Name()] = section
+	}
+
+	for _, plugin := range app.plugins.GetList() {
+		exporter, ok := plugin.(types.OptionExporter)
+		if !ok {
+			continue
+		}
+		for _, opt := range exporter.ExportOptions(state) {
+			kv := keyValue{plugin.Name() + "/" + opt.Key, opt.Value}
+			genDoc.AppOptions.pluginOptions = append(genDoc.AppOptions.pluginOptions, kv)
+			for _, str := range []string{kv.Key, kv.Value} {
+				raw, err := json.Marshal(str)
+				if err != nil {
+					return nil, err
+				}
+				genDoc.AppOptions.PluginOptions = append(genDoc.AppOptions.PluginOptions, raw)
+			}
+		}
+	}
+	return genDoc, nil
+}
+
 type keyValue struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
@@ -55,6 +172,8 @@ type FullGenesisDoc struct {
 type GenesisDoc struct {
 	Accounts      []GenesisAccount  `json:"accounts"`
 	PluginOptions []json.RawMessage `json:"plugin_options"`
+	// Plugins holds a section for every GenesisPlugin, by plugin name
+	Plugins map[string]json.RawMessage `json:"plugins,omitempty"`
 
 	pluginOptions []keyValue // unmarshaled rawmessages
 }
@@ -81,9 +200,35 @@ func loadGenesis(filePath string) (*FullGenesisDoc, error) {
 		return nil, err
 	}
 	genDoc.AppOptions.pluginOptions = pluginOpts
+
+	err = genDoc.ValidateBasic()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid genesis file")
+	}
 	return genDoc, nil
 }
 
+// ValidateBasic checks the chain_id is set, and all accounts are valid
+// and unique. The coins are sorted on the way.
+func (g *FullGenesisDoc) ValidateBasic() error {
+	if g.ChainID == "" {
+		return errors.New("chain_id is required")
+	}
+	seen := make(map[string]int)
+	for i := range g.AppOptions.Accounts {
+		acc := &g.AppOptions.Accounts[i]
+		addr, err := acc.ValidateBasic()
+		if err != nil {
+			return errors.Wrapf(err, "account %d%s", i, acc.label())
+		}
+		if j, ok := seen[string(addr)]; ok {
+			return errors.Errorf("account %d%s: duplicate address %X (also account %d)", i, acc.label(), addr, j)
+		}
+		seen[string(addr)] = i
+	}
+	return nil
+}
+
 func parseGenesisList(kvz_ []json.RawMessage) (kvz []keyValue, err error) {
 	if len(kvz_)%2 != 0 {
 		return nil, errors.New("genesis cannot have an odd number of items.  Format = [key1, value1, key2, value2, ...]")
@@ -110,6 +255,7 @@ func parseGenesisList(kvz_ []json.RawMessage) (kvz []keyValue, err error) {
 /**** code to parse accounts from genesis docs ***/
 
 type GenesisAccount struct {
+	Name    string     `json:"name,omitempty"` // only used for error messages
 	Address data.Bytes `json:"address"`
 	// this from types.Account (don't know how to embed this properly)
 	PubKey   crypto.PubKey `json:"pub_key"` // May be nil, if not known.
@@ -125,6 +271,30 @@ func (g GenesisAccount) ToAccount() *types.Account {
 	}
 }
 
+// ValidateBasic sorts the coins, and makes sure they are valid and
+// positive, and that the address and pubkey match
+func (g *GenesisAccount) ValidateBasic() ([]byte, error) {
+	addr, err := g.GetAddr()
+	if err != nil {
+		return nil, err
+	}
+	g.Balance.Sort()
+	if !g.Balance.IsValid() {
+		return nil, errors.Errorf("invalid coins %v", g.Balance)
+	}
+	if len(g.Balance) > 0 && !g.Balance.IsPositive() {
+		return nil, errors.Errorf("coins must be positive %v", g.Balance)
+	}
+	return addr, nil
+}
+
+func (g GenesisAccount) label() string {
+	if g.Name == "" {
+		return ""
+	}
+	return " (" + g.Name + ")"
+}
+
 func (g GenesisAccount) GetAddr() ([]byte, error) {
 	noAddr, noPk := len(g.Address) == 0, g.PubKey.Empty()
 
@@ -3,10 +3,14 @@ package app
 import (
 	"encoding/hex"
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	wrsp "github.com/tepleton/wrsp/types"
 	"github.com/tepleton/basecoin/types"
 	crypto "github.com/tepleton/go-crypto"
 	eyescli "github.com/tepleton/merkleeyes/client"
@@ -16,6 +20,48 @@ import (
 const genesisFilepath = "./testdata/genesis.json"
 const genesisAcctFilepath = "./testdata/genesis2.json"
 
+// optPlugin remembers all options, and exports them again
+type optPlugin struct {
+	name string
+	opts []types.PluginOption
+}
+
+var _ types.OptionExporter = (*optPlugin)(nil)
+
+func (p *optPlugin) Name() string {
+	return p.name
+}
+func (p *optPlugin) RunTx(store types.KVStore, ctx types.CallContext, txBytes []byte) (res wrsp.Result) {
+	return
+}
+func (p *optPlugin) SetOption(store types.KVStore, key, value string) (log string) {
+	if key == "invalid" {
+		return "Unrecognized option key " + key
+	}
+	p.opts = append(p.opts, types.PluginOption{key, value})
+	return "Success"
+}
+func (p *optPlugin) InitChain(store types.KVStore, vals []*wrsp.Validator) {
+}
+func (p *optPlugin) BeginBlock(store types.KVStore, hash []byte, header *wrsp.Header) {
+}
+func (p *optPlugin) EndBlock(store types.KVStore, height uint64) (res wrsp.ResponseEndBlock) {
+	return
+}
+func (p *optPlugin) ExportOptions(store types.KVStore) []types.PluginOption {
+	return p.opts
+}
+
+// writeGenesis writes the json to a temporary file, and returns the path
+func writeGenesis(t *testing.T, genesis string) string {
+	f, err := ioutil.TempFile("", "genesis")
+	require.Nil(t, err)
+	_, err = f.WriteString(genesis)
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+	return f.Name()
+}
+
 func TestLoadGenesisDoNotFailIfAppOptionsAreMissing(t *testing.T) {
 	eyesCli := eyescli.NewLocalClient("", 0)
 	app := NewBasecoin(eyesCli)
@@ -28,11 +74,14 @@ func TestLoadGenesis(t *testing.T) {
 
 	eyesCli := eyescli.NewLocalClient("", 0)
 	app := NewBasecoin(eyesCli)
+	plugin := &optPlugin{name: "plugin1"}
+	app.RegisterPlugin(plugin)
 	err := app.LoadGenesis(genesisFilepath)
 	require.Nil(err, "%+v", err)
 
 	// check the chain id
 	assert.Equal("foo_bar_chain", app.GetState().GetChainID())
+	assert.Equal([]types.PluginOption{{"key1", "value1"}, {"key2", "value2"}}, plugin.opts)
 
 	// and check the account info - previously calculated values
 	addr, _ := hex.DecodeString("eb98e0688217cfdeb70eddf4b33cdcc37fc53197")
@@ -82,9 +131,6 @@ func TestLoadGenesisAccountAddress(t *testing.T) {
 		{"62035D628DE7543332544AA60D90D3693B6AD51B", true, true, types.Coins{{"one", 111}}},
 		// this comes from an address, should be stored proper (bob)
 		{"C471FB670E44D219EE6DF2FC284BE38793ACBCE1", true, false, types.Coins{{"two", 222}}},
-		// this one had a mismatched address and pubkey, should not store under either (carl)
-		{"1234ABCDD18E8EFE3FFC4B0506BF9BF8E5B0D9E9", false, false, nil}, // this is given addr
-		{"700BEC5ED18E8EFE3FFC4B0506BF9BF8E5B0D9E9", false, false, nil}, // this is addr of the given pubkey
 		// this comes from a secp256k1 public key, should be stored proper (sam)
 		{"979F080B1DD046C452C2A8A250D18646C6B669D4", true, true, types.Coins{{"four", 444}}},
 	}
@@ -124,3 +170,106 @@ func TestParseGenesisList(t *testing.T) {
 	assert.Equal(genDoc.AppOptions.pluginOptions[0].Value, "value1")
 	assert.Equal(genDoc.AppOptions.pluginOptions[1].Value, "value2")
 }
+
+func TestLoadGenesisErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	account := func(addr, coins string) string {
+		return `{"address": "` + addr + `", "coins": ` + coins + `}`
+	}
+	genesis := func(chainID, accounts, options string) string {
+		return `{"chain_id": "` + chainID + `", "app_options": {
+      "accounts": [` + accounts + `],
+      "plugin_options": [` + options + `]
+    }}`
+	}
+	addr1 := "C471FB670E44D219EE6DF2FC284BE38793ACBCE1"
+	addr2 := "62035D628DE7543332544AA60D90D3693B6AD51B"
+	coins := `[{"denom": "one", "amount": 111}]`
+
+	cases := []struct {
+		genesis string
+		valid   bool
+	}{
+		{genesis("foo", account(addr1, coins)+","+account(addr2, coins), `"plugin1/key", "value"`), true},
+		// chain id is required
+		{genesis("", account(addr1, coins), ""), false},
+		// duplicate address
+		{genesis("foo", account(addr1, coins)+","+account(addr1, coins), ""), false},
+		// missing address
+		{genesis("foo", `{"coins": `+coins+`}`, ""), false},
+		// mismatched address and pubkey (carl)
+		{genesis("foo", `{
+      "address": "1234ABCDD18E8EFE3FFC4B0506BF9BF8E5B0D9E9",
+      "pub_key": {
+        "type": "ed25519",
+        "data": "177C0AC45E86257F0708DC085D592AB22AAEECD1D26381B757F7C96135921858"
+      }}`, ""), false},
+		// invalid coins
+		{genesis("foo", account(addr1, `[{"denom": "one", "amount": 0}]`), ""), false},
+		{genesis("foo", account(addr1, `[{"denom": "one", "amount": -5}]`), ""), false},
+		{genesis("foo", account(addr1, `[{"denom": "one", "amount": 1}, {"denom": "one", "amount": 2}]`), ""), false},
+		// unknown plugins and options
+		{genesis("foo", "", `"plugin2/key", "value"`), false},
+		{genesis("foo", "", `"base/chain_id", "bar"`), false},
+		{genesis("foo", "", `"base/account", "{}"`), false},
+		// the plugin must accept all its options
+		{genesis("foo", account(addr1, coins), `"plugin1/key", "value", "plugin1/invalid", "value"`), false},
+		// plugin sections need a GenesisPlugin
+		{`{"chain_id": "foo", "app_options": {"plugins": {"plugin2": {}}}}`, false},
+		{`{"chain_id": "foo", "app_options": {"plugins": {"plugin1": {}}}}`, false},
+	}
+
+	for idx, tc := range cases {
+		i := strconv.Itoa(idx)
+		path := writeGenesis(t, tc.genesis)
+		defer os.Remove(path)
+
+		app := NewBasecoin(eyescli.NewLocalClient("", 0))
+		app.RegisterPlugin(&optPlugin{name: "plugin1"})
+		err := app.LoadGenesis(path)
+		if tc.valid {
+			assert.Nil(err, "%d: %+v", idx, err)
+			assert.Equal("foo", app.GetState().GetChainID(), i)
+		} else {
+			assert.NotNil(err, i)
+			// nothing was set
+			assert.Equal("", app.GetState().GetChainID(), i)
+		}
+	}
+}
+
+func TestExportGenesis(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+
+	app := NewBasecoin(eyescli.NewLocalClient("", 0))
+	app.RegisterPlugin(&optPlugin{name: "plugin1"})
+	err := app.LoadGenesis(genesisFilepath)
+	require.Nil(err, "%+v", err)
+	err = app.LoadGenesis(genesisAcctFilepath)
+	require.Nil(err, "%+v", err)
+
+	genDoc, err := app.ExportGenesis()
+	require.Nil(err, "%+v", err)
+	assert.Equal("addr_accounts_chain", genDoc.ChainID)
+	assert.Equal(4, len(genDoc.AppOptions.Accounts))
+
+	// load the exported genesis into a new app, and compare state
+	bz, err := json.Marshal(genDoc)
+	require.Nil(err)
+	path := writeGenesis(t, string(bz))
+	defer os.Remove(path)
+
+	plugin := &optPlugin{name: "plugin1"}
+	app2 := NewBasecoin(eyescli.NewLocalClient("", 0))
+	app2.RegisterPlugin(plugin)
+	err = app2.LoadGenesis(path)
+	require.Nil(err, "%+v", err)
+
+	assert.Equal("addr_accounts_chain", app2.GetState().GetChainID())
+	assert.Equal([]types.PluginOption{{"key1", "value1"}, {"key2", "value2"}}, plugin.opts)
+	for _, acc := range genDoc.AppOptions.Accounts {
+		addr := acc.Address
+		assert.Equal(app.GetState().GetAccount(addr), app2.GetState().GetAccount(addr), "%X", addr)
+	}
+}
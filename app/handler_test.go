@@ -0,0 +1,173 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	wire "github.com/tepleton/go-wire"
+	"github.com/tepleton/go-wire/data"
+	wrsp "github.com/tepleton/wrsp/types"
+
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/errors"
+	"github.com/tepleton/basecoin/handlers"
+	"github.com/tepleton/basecoin/plugins/counter"
+	"github.com/tepleton/basecoin/txs"
+	"github.com/tepleton/basecoin/types"
+)
+
+// rawHandler returns the bytes of a txs.Raw as the result data
+type rawHandler struct{}
+
+var _ basecoin.Handler = rawHandler{}
+
+func (h rawHandler) CheckTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (basecoin.Result, error) {
+	return h.DeliverTx(ctx, store, tx)
+}
+
+func (rawHandler) DeliverTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+	raw, ok := tx.Unwrap().(txs.Raw)
+	if !ok {
+		return res, errors.InvalidFormat()
+	}
+	return basecoin.Result{Data: raw.Bytes}, nil
+}
+
+func TestHandlerTx(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	at := newAppTest(t)
+	at.app.SetHandler(handlers.SignedHandler{
+		Inner: handlers.SimpleFeeHandler{
+			AccountChecker: handlers.SimpleAccountChecker{},
+			MinFee:         types.Coins{{"mycoin", 1}},
+			Inner:          rawHandler{},
+		},
+	})
+	payer := at.accIn.Account.PubKey.Address()
+	msg := []byte("hello")
+
+	// sign a fee-paying raw tx with the given account
+	makeTx := func(fee int64, signer types.PrivAccount) []byte {
+		feeTx := txs.NewFee(txs.NewRaw(msg).Wrap(), types.Coin{"mycoin", fee}, payer)
+		tx := txs.NewSig(feeTx.Wrap())
+		err := tx.Sign(signer.Account.PubKey, signer.PrivKey.Sign(tx.SignBytes()))
+		require.Nil(err, "%+v", err)
+		txBytes, err := data.ToWire(tx.Wrap())
+		require.Nil(err, "%+v", err)
+		return txBytes
+	}
+
+	cases := []struct {
+		tx       []byte
+		code     wrsp.CodeType
+		feePayed int64
+	}{
+		{[]byte{0xde, 0xad, 0xbe, 0xef}, wrsp.CodeType_EncodingError, 0},
+		{makeTx(0, at.accIn), wrsp.CodeType_BaseInvalidInput, 0},
+		{makeTx(2, at.accOut), wrsp.CodeType_Unauthorized, 0},
+		{makeTx(500, at.accIn), wrsp.CodeType_BaseInsufficientFunds, 0},
+		{makeTx(2, at.accIn), wrsp.CodeType_OK, 2},
+	}
+
+	for i, tc := range cases {
+		initBal := at.app.GetState().GetAccount(payer).Balance
+		res := at.app.DeliverTx(tc.tx)
+		assert.Equal(tc.code, res.Code, "%d: %s", i, res.Log)
+		if res.IsOK() {
+			assert.EqualValues(msg, res.Data, "%d", i)
+		}
+		endBal := at.app.GetState().GetAccount(payer).Balance
+		assert.Equal(initBal.Minus(types.Coins{{"mycoin", tc.feePayed}}), endBal, "%d", i)
+	}
+}
+
+func TestDefaultHandler(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	at := newAppTest(t)
+	plugin := counter.New()
+	at.app.RegisterPlugin(plugin)
+	at.app.SetDefaultHandler(types.Coins{{"mycoin", 1}})
+	signer := at.accIn
+	addr := signer.Account.PubKey.Address()
+	to := at.accOut.Account.PubKey.Address()
+
+	// sign a tx paying 1mycoin, for the chain with the nonce
+	makeTx := func(inner basecoin.Tx, chainID string, seq uint64) []byte {
+		tx := txs.NewFee(inner, types.Coin{"mycoin", 1}, addr).Wrap()
+		tx = txs.NewNonce(tx, txs.NewNonceInput(addr, seq)).Wrap()
+		tx = txs.NewChain(tx, chainID).Wrap()
+		stx := txs.NewSig(tx)
+		err := stx.Sign(signer.Account.PubKey, signer.PrivKey.Sign(stx.SignBytes()))
+		require.Nil(err, "%+v", err)
+		txBytes, err := data.ToWire(stx.Wrap())
+		require.Nil(err, "%+v", err)
+		return txBytes
+	}
+	count := txs.NewPluginTx("counter", wire.BinaryBytes(counter.CounterTx{Valid: true})).Wrap()
+	unknown := txs.NewPluginTx("unknown", nil).Wrap()
+	send := txs.NewSendTx(txs.NewTxInput(addr, types.Coins{{"mycoin", 2}}, 1),
+		txs.NewTxOutput(to, types.Coins{{"mycoin", 2}})).Wrap()
+
+	cases := []struct {
+		tx   []byte
+		code wrsp.CodeType
+	}{
+		{makeTx(count, "other_chain", 1), errors.CodeTypeWrongChain},
+		{makeTx(count, at.chainID, 1), wrsp.CodeType_OK},
+		// no replay, and no unknown plugins
+		{makeTx(count, at.chainID, 1), wrsp.CodeType_BaseInvalidInput},
+		{makeTx(unknown, at.chainID, 2), errors.CodeTypeUnknownTx},
+		{makeTx(send, at.chainID, 2), wrsp.CodeType_OK},
+	}
+	// the mempool runs the whole stack as well
+	for i, tc := range cases {
+		res := at.app.CheckTx(tc.tx)
+		assert.Equal(tc.code, res.Code, "check %d: %s", i, res.Log)
+	}
+	for i, tc := range cases {
+		res := at.app.DeliverTx(tc.tx)
+		assert.Equal(tc.code, res.Code, "%d: %s", i, res.Log)
+	}
+
+	// the counter ran once, and both good txs paid the fee
+	var state counter.CounterPluginState
+	err := wire.ReadBinaryBytes(at.app.GetState().Get(plugin.StateKey()), &state)
+	require.Nil(err)
+	assert.Equal(1, state.Counter)
+	assert.Equal(types.Coins{{"mycoin", 3}}, at.app.GetState().GetAccount(addr).Balance)
+	assert.Equal(types.Coins{{"mycoin", 9}}, at.app.GetState().GetAccount(to).Balance)
+}
+
+func TestOutOfGasReplay(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	at := newAppTest(t)
+	at.app.SetDefaultHandler(types.Coins{{"mycoin", 1}})
+	signer := at.accIn
+	addr := signer.Account.PubKey.Address()
+	to := at.accOut.Account.PubKey.Address()
+
+	// a send with too little gas to even check the signature
+	send := txs.NewSendTx(txs.NewTxInput(addr, types.Coins{{"mycoin", 2}}, 1),
+		txs.NewTxOutput(to, types.Coins{{"mycoin", 2}})).Wrap()
+	tx := txs.NewGasFee(send, types.Coin{"mycoin", 1}, 1, addr).Wrap()
+	tx = txs.NewNonce(tx, txs.NewNonceInput(addr, 1)).Wrap()
+	tx = txs.NewChain(tx, at.chainID).Wrap()
+	stx := txs.NewSig(tx)
+	err := stx.Sign(signer.Account.PubKey, signer.PrivKey.Sign(stx.SignBytes()))
+	require.Nil(err, "%+v", err)
+	txBytes, err := data.ToWire(stx.Wrap())
+	require.Nil(err, "%+v", err)
+
+	initBal := at.app.GetState().GetAccount(addr).Balance
+	res := at.app.DeliverTx(txBytes)
+	assert.Equal(errors.CodeTypeOutOfGas, res.Code, res.Log)
+	bal := at.app.GetState().GetAccount(addr).Balance
+	assert.Equal(initBal.Minus(types.Coins{{"mycoin", 1}}), bal)
+
+	// the nonce was used up with the fee, so a replay is not charged
+	res = at.app.DeliverTx(txBytes)
+	assert.Equal(wrsp.CodeType_BaseInvalidInput, res.Code, res.Log)
+	assert.Equal(bal, at.app.GetState().GetAccount(addr).Balance)
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"sync"
+
+	wrsp "github.com/tepleton/wrsp/types"
+	"github.com/tepleton/tmlibs/log"
+
+	sm "github.com/tepleton/basecoin/state"
+)
+
+// CheckFunc validates a tx against the state, applying its changes
+// on success (like taking the fee or increasing the sequence)
+type CheckFunc func(state *sm.State, txBytes []byte) wrsp.Result
+
+// Mempool manages the state CheckTx runs against.
+//
+// The check state is a cache over the committed state, holding the
+// changes of all txs accepted since the last block. After every
+// Commit it is rebuilt from the committed state, and the pending
+// txs that didn't make it into the block are checked again, so
+// the ones that are no longer valid are evicted.
+//
+// All access goes through one lock, as CheckTx may be called
+// concurrently with the consensus connection.
+type Mempool struct {
+	mtx     sync.Mutex
+	parent  *sm.State
+	state   *sm.State
+	check   CheckFunc
+	pending [][]byte
+	results map[string]wrsp.Result
+	logger  log.Logger
+}
+
+func NewMempool(parent *sm.State, check CheckFunc) *Mempool {
+	return &Mempool{
+		parent:  parent,
+		state:   parent.CacheWrap(),
+		check:   check,
+		pending: nil,
+		results: make(map[string]wrsp.Result),
+		logger:  log.NewNopLogger(),
+	}
+}
+
+func (m *Mempool) SetLogger(l log.Logger) {
+	m.logger = l
+}
+
+// CheckTx runs the tx against the check state.
+// A tx that is already pending is accepted again without running it,
+// as its changes are already in the check state.
+func (m *Mempool) CheckTx(txBytes []byte) wrsp.Result {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if res, ok := m.results[string(txBytes)]; ok {
+		return res
+	}
+	res := m.check(m.state, txBytes)
+	if res.IsOK() {
+		m.pending = append(m.pending, txBytes)
+		m.results[string(txBytes)] = res
+	}
+	return res
+}
+
+// Query gives fn safe access to the check state, while no txs are run.
+// fn must not keep a reference to the state.
+func (m *Mempool) Query(fn func(state *sm.State)) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	fn(m.state)
+}
+
+// Pending returns all txs that passed CheckTx since the last block
+func (m *Mempool) Pending() [][]byte {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return append([][]byte(nil), m.pending...)
+}
+
+// Lock blocks CheckTx and Query until Unlock.
+// Hold it while the parent state is modified.
+func (m *Mempool) Lock() {
+	m.mtx.Lock()
+}
+
+func (m *Mempool) Unlock() {
+	m.mtx.Unlock()
+}
+
+// Update must be called with the lock held, after the parent state
+// was committed. It drops all txs that were in the block, rebuilds
+// the check state and rechecks the rest, returning the evicted txs.
+func (m *Mempool) Update(included [][]byte) (evicted [][]byte) {
+	inBlock := make(map[string]bool, len(included))
+	for _, tx := range included {
+		inBlock[string(tx)] = true
+	}
+	txs := m.pending
+	m.pending = nil
+	m.results = make(map[string]wrsp.Result)
+	m.state = m.parent.CacheWrap()
+
+	for _, tx := range txs {
+		if inBlock[string(tx)] {
+			continue
+		}
+		res := m.check(m.state, tx)
+		if res.IsErr() {
+			m.logger.Info("Evicted tx from mempool", "log", res.Log)
+			evicted = append(evicted, tx)
+			continue
+		}
+		m.pending = append(m.pending, tx)
+		m.results[string(tx)] = res
+	}
+	return evicted
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	wire "github.com/tepleton/go-wire"
+
+	sm "github.com/tepleton/basecoin/state"
+	"github.com/tepleton/basecoin/types"
+)
+
+func TestMempoolRecheck(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	at := newAppTest(t)
+	at.accIn.Balance = types.Coins{{"mycoin", 100}}
+	at.acc2app(at.accIn.Account)
+	require.True(at.app.Commit().IsOK())
+
+	toBytes := func(tx *types.SendTx) []byte {
+		return wire.BinaryBytes(struct{ types.Tx }{tx})
+	}
+	tx1, tx2 := toBytes(at.getTx(1)), toBytes(at.getTx(2))
+
+	// the check state keeps track of the sequence
+	assert.True(at.app.CheckTx(tx1).IsOK())
+	assert.True(at.app.CheckTx(tx2).IsOK())
+	assert.True(at.app.CheckTx(tx2).IsOK(), "recheck of a pending tx")
+	assert.True(at.app.CheckTx(toBytes(at.getTx(4))).IsErr())
+	assert.Equal([][]byte{tx1, tx2}, at.app.Mempool().Pending())
+
+	var seq int
+	at.app.Mempool().Query(func(state *sm.State) {
+		seq = state.GetAccount(at.accIn.Account.PubKey.Address()).Sequence
+	})
+	assert.Equal(2, seq)
+
+	// only tx1 makes it into the block, tx2 is still valid
+	require.True(at.app.DeliverTx(tx1).IsOK())
+	require.True(at.app.Commit().IsOK())
+	assert.Equal([][]byte{tx2}, at.app.Mempool().Pending())
+
+	// another tx with the same sequence evicts tx2
+	other := types.MakeSendTx(2, types.MakeAcc("other"), at.accIn)
+	types.SignTx(at.chainID, other, at.accIn)
+	require.True(at.app.DeliverTx(toBytes(other)).IsOK())
+	require.True(at.app.Commit().IsOK())
+	assert.Empty(at.app.Mempool().Pending())
+	assert.True(at.app.CheckTx(tx2).IsErr())
+}
@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	lc "github.com/tepleton/light-client"
+	lcmd "github.com/tepleton/light-client/commands"
+	proofcmd "github.com/tepleton/light-client/commands/proofs"
+
+	"github.com/tepleton/basecoin/plugins/ibc"
+)
+
+// IBCQueryCmd groups the queries of the IBC state
+var IBCQueryCmd = &cobra.Command{
+	Use:   "ibc",
+	Short: "Query the IBC state, with proof",
+}
+
+var ibcChainsQueryCmd = &cobra.Command{
+	Use:   "chains",
+	Short: "List the latest state of all registered chains, with proof",
+	RunE:  lcmd.RequireInit(doIBCChainsQuery),
+}
+
+var ibcChainQueryCmd = &cobra.Command{
+	Use:   "chain [chain-id]",
+	Short: "Get the latest state of a registered chain, with proof",
+	RunE:  lcmd.RequireInit(doIBCChainQuery),
+}
+
+var ibcPacketsQueryCmd = &cobra.Command{
+	Use:   "packets [src-chain] [dst-chain]",
+	Short: "List all packets sent from src to dst on the src chain, with proof",
+	RunE:  lcmd.RequireInit(doIBCPacketsQuery),
+}
+
+var ibcPacketQueryCmd = &cobra.Command{
+	Use:   "packet [src-chain] [dst-chain] [sequence]",
+	Short: "Get a packet sent from src to dst on the src chain, with proof",
+	RunE:  lcmd.RequireInit(doIBCPacketQuery),
+}
+
+func init() {
+	IBCQueryCmd.AddCommand(
+		ibcChainsQueryCmd,
+		ibcChainQueryCmd,
+		ibcPacketsQueryCmd,
+		ibcPacketQueryCmd,
+	)
+}
+
+// IBCPacket is a sent packet, with the ack once it was relayed back
+type IBCPacket struct {
+	Packet  ibc.Packet           `json:"packet"`
+	Receipt *ibc.Acknowledgement `json:"receipt,omitempty"`
+}
+
+func doIBCChainsQuery(cmd *cobra.Command, args []string) error {
+	var chainIDs []string
+	proof, err := proofcmd.GetAndParseAppProof(ibc.ChainListKey(), &chainIDs)
+	if lc.IsNoDataErr(err) {
+		return proofcmd.OutputProof([]ibc.BlockchainState{}, 0)
+	} else if err != nil {
+		return err
+	}
+
+	chains := []ibc.BlockchainState{}
+	for _, chainID := range chainIDs {
+		var state ibc.BlockchainState
+		proof, err = proofcmd.GetAndParseAppProof(ibc.ChainStateKey(chainID), &state)
+		if err != nil {
+			return err
+		}
+		chains = append(chains, state)
+	}
+	return proofcmd.OutputProof(chains, proof.BlockHeight())
+}
+
+func doIBCChainQuery(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("chain takes one argument, the chain id")
+	}
+
+	var state ibc.BlockchainState
+	proof, err := proofcmd.GetAndParseAppProof(ibc.ChainStateKey(args[0]), &state)
+	if lc.IsNoDataErr(err) {
+		return errors.Errorf("Chain %s is not registered", args[0])
+	} else if err != nil {
+		return err
+	}
+	return proofcmd.OutputProof(state, proof.BlockHeight())
+}
+
+func doIBCPacketsQuery(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return errors.New("packets takes two arguments, the src and dst chain")
+	}
+
+	// the acknowledged packets may be pruned, so we start after them
+	var pruned ibc.PrunedPackets
+	var height uint64
+	proof, err := proofcmd.GetAndParseAppProof(ibc.PrunedPacketsKey(args[1]), &pruned)
+	if err == nil {
+		height = proof.BlockHeight()
+	} else if !lc.IsNoDataErr(err) {
+		return err
+	}
+
+	// packets are sent without gaps, so we stop at the first missing one
+	packets := []IBCPacket{}
+	for seq := pruned.Egress; ; seq++ {
+		packet, h, err := getIBCPacket(args[0], args[1], seq)
+		if lc.IsNoDataErr(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+		packets = append(packets, packet)
+		height = h
+	}
+	return proofcmd.OutputProof(packets, height)
+}
+
+func doIBCPacketQuery(cmd *cobra.Command, args []string) error {
+	if len(args) != 3 {
+		return errors.New("packet takes three arguments, the src and dst chain and the sequence")
+	}
+	seq, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return errors.Errorf("Sequence must be a number: %v", err)
+	}
+
+	packet, height, err := getIBCPacket(args[0], args[1], seq)
+	if lc.IsNoDataErr(err) {
+		return errors.Errorf("No packet %d from %s to %s", seq, args[0], args[1])
+	} else if err != nil {
+		return err
+	}
+	return proofcmd.OutputProof(packet, height)
+}
+
+// getIBCPacket returns the packet with its receipt, and the height of the proof
+func getIBCPacket(src, dst string, seq uint64) (out IBCPacket, height uint64, err error) {
+	proof, err := proofcmd.GetAndParseAppProof(ibc.PacketKey(src, dst, seq), &out.Packet)
+	if err != nil {
+		return out, 0, err
+	}
+
+	receipt := new(ibc.Acknowledgement)
+	_, err = proofcmd.GetAndParseAppProof(ibc.ReceiptKey(src, dst, seq), receipt)
+	if lc.IsNoDataErr(err) {
+		return out, proof.BlockHeight(), nil
+	} else if err != nil {
+		return out, 0, err
+	}
+	out.Receipt = receipt
+	return out, proof.BlockHeight(), nil
+}
@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	crypto "github.com/tepleton/go-crypto"
+	keycmd "github.com/tepleton/go-crypto/cmd"
+	wire "github.com/tepleton/go-wire"
+	"github.com/tepleton/go-wire/data"
+
+	"github.com/tepleton/basecoin/txs"
+)
+
+// MultisigKeyCmd builds a threshold key, to add under keys
+var MultisigKeyCmd = &cobra.Command{
+	Use:   "multisig [threshold] [name or hex pubkey]...",
+	Short: "Build a k-of-n multisig key, and show its address",
+	Long: `Build a multisig key, which requires threshold of the given keys to sign.
+Keys are given by the name of a local key, or the hex of a public key.
+The address only depends on the threshold and the set of keys, not their order,
+so every owner can build it on their own.`,
+	RunE: doMultisigKey,
+}
+
+// MultisigKey is the output of MultisigKeyCmd
+type MultisigKey struct {
+	Address data.Bytes    `json:"address"`
+	PubKey  crypto.PubKey `json:"pub_key"`
+}
+
+func doMultisigKey(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return errors.New("multisig takes the threshold and at least one key")
+	}
+	threshold, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errors.Errorf("Threshold must be a number: %v", err)
+	}
+
+	pubKeys := make([]crypto.PubKey, len(args)-1)
+	for i, arg := range args[1:] {
+		pubKeys[i], err = loadPubKey(arg)
+		if err != nil {
+			return err
+		}
+	}
+	key := txs.NewThresholdKey(threshold, pubKeys...)
+	err = key.ValidateBasic()
+	if err != nil {
+		return errors.Errorf("Invalid multisig key, %d of %d keys with no duplicates: %v",
+			threshold, len(pubKeys), err)
+	}
+
+	out, err := json.MarshalIndent(MultisigKey{key.Address(), key.Wrap()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// loadPubKey takes the name of a local key, or the hex of a public key
+func loadPubKey(arg string) (pk crypto.PubKey, err error) {
+	info, err := keycmd.GetKeyManager().Get(arg)
+	if err == nil {
+		return info.PubKey, nil
+	}
+	bz, hexErr := hex.DecodeString(arg)
+	if hexErr != nil {
+		return pk, errors.Errorf("%s is neither a local key nor a hex pubkey: %v", arg, err)
+	}
+	err = wire.ReadBinaryBytes(bz, &pk)
+	if err != nil {
+		return pk, errors.Errorf("Invalid pubkey %s: %v", arg, err)
+	}
+	return pk, nil
+}
@@ -39,12 +39,16 @@ func main() {
 	pr.AddCommand(proofs.TxCmd)
 	pr.AddCommand(proofs.KeyCmd)
 	pr.AddCommand(bcmd.AccountQueryCmd)
+	pr.AddCommand(bcmd.IBCQueryCmd)
 
 	// you will always want this for the base send command
 	proofs.TxPresenters.Register("base", bcmd.BaseTxPresenter{})
 	tr := txs.RootCmd
 	tr.AddCommand(bcmd.SendTxCmd)
 
+	// multisig keys are built from other keys
+	keycmd.RootCmd.AddCommand(bcmd.MultisigKeyCmd)
+
 	// Set up the various commands to use
 	BaseCli.AddCommand(
 		commands.InitCmd,
@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/tepleton/tmlibs/cli"
+)
+
+var ExportCmd = &cobra.Command{
+	Use:   "export [genesis file]",
+	Short: "Export the current state to a new genesis file",
+	Long: `Export the chain_id, all accounts, and the options of plugins that support it,
+so a new chain can be started from the current state.
+All other fields (like the validators) are copied from the current genesis.json.
+Prints to stdout, if no file is given.`,
+	RunE: exportCmd,
+}
+
+//nolint
+const (
+	FlagNewChainID = "new-chain-id"
+)
+
+func init() {
+	flags := ExportCmd.Flags()
+	flags.String(FlagEyes, "local", "MerkleEyes address, or 'local' for embedded")
+	flags.String(FlagNewChainID, "", "Chain ID for the new genesis (default: the current one)")
+}
+
+func exportCmd(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 {
+		return errors.New("`export` takes at most one argument, the genesis file to write")
+	}
+	rootDir := viper.GetString(cli.HomeFlag)
+	basecoinApp, err := newBasecoinApp(rootDir, viper.GetString(FlagEyes))
+	if err != nil {
+		return err
+	}
+
+	genDoc, err := basecoinApp.ExportGenesis()
+	if err != nil {
+		return errors.Wrap(err, "exporting state")
+	}
+	if chainID := viper.GetString(FlagNewChainID); chainID != "" {
+		genDoc.ChainID = chainID
+	}
+
+	// keep all the tepleton fields of the current genesis
+	full := map[string]interface{}{}
+	genesisFile := path.Join(rootDir, "genesis.json")
+	if bz, err := ioutil.ReadFile(genesisFile); err == nil {
+		err = json.Unmarshal(bz, &full)
+		if err != nil {
+			return errors.Wrap(err, "unmarshaling genesis file")
+		}
+	}
+	full["chain_id"] = genDoc.ChainID
+	full["app_options"] = genDoc.AppOptions
+
+	out, err := json.MarshalIndent(full, "", "  ")
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fmt.Println(string(out))
+		return nil
+	}
+	return ioutil.WriteFile(args[0], out, 0644)
+}
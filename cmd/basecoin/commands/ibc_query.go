@@ -0,0 +1,297 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/tepleton/go-wire"
+	"github.com/tepleton/merkleeyes/iavl"
+
+	"github.com/tepleton/basecoin/plugins/ibc"
+	"github.com/tepleton/basecoin/types"
+	"github.com/tepleton/tepleton/rpc/client"
+	tmtypes "github.com/tepleton/tepleton/types"
+)
+
+var NodeQueryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Query the state of a running node",
+}
+
+var IBCQueryCmd = &cobra.Command{
+	Use:   "ibc",
+	Short: "Query the IBC state of a running node",
+	Long: `Query the IBC state of a running node.
+Every value is proven against the app hash in the header of the node
+at the height of the query. Use basecli to check the header against
+trusted validators too.`,
+}
+
+var IBCQueryChainsCmd = &cobra.Command{
+	Use:   "chains",
+	Short: "List the latest state of all registered chains",
+	RunE:  ibcQueryChainsCmd,
+}
+
+var IBCQueryChainCmd = &cobra.Command{
+	Use:   "chain [chain-id]",
+	Short: "Get the latest state of a registered chain, and our connection to it",
+	RunE:  ibcQueryChainCmd,
+}
+
+var IBCQueryPacketsCmd = &cobra.Command{
+	Use:   "packets [src-chain] [dst-chain]",
+	Short: "List all packets sent from src to dst, on the src chain",
+	RunE:  ibcQueryPacketsCmd,
+}
+
+var IBCQueryPacketCmd = &cobra.Command{
+	Use:   "packet [src-chain] [dst-chain] [sequence]",
+	Short: "Get a packet sent from src to dst, on the src chain",
+	RunE:  ibcQueryPacketCmd,
+}
+
+//flags
+var (
+	queryNodeFlag string
+)
+
+func init() {
+	flags := []Flag2Register{
+		{&queryNodeFlag, "node", "tcp://localhost:46657", "Tendermint RPC address of the node to query"},
+	}
+	RegisterPersistentFlags(NodeQueryCmd, flags)
+
+	IBCQueryCmd.AddCommand(
+		IBCQueryChainsCmd,
+		IBCQueryChainCmd,
+		IBCQueryPacketsCmd,
+		IBCQueryPacketCmd,
+	)
+	NodeQueryCmd.AddCommand(IBCQueryCmd)
+}
+
+// IBCChainOutput is the state of a registered chain. The state has the
+// height below which its headers were pruned, and Pruned tells which
+// packets to and from it were pruned.
+type IBCChainOutput struct {
+	State      ibc.BlockchainState `json:"state"`
+	Connection *ibc.Connection     `json:"connection,omitempty"`
+	Pruned     *ibc.PrunedPackets  `json:"pruned,omitempty"`
+}
+
+// IBCPacketOutput is a sent packet, with the ack once it was relayed back
+type IBCPacketOutput struct {
+	Packet  ibc.Packet           `json:"packet"`
+	Receipt *ibc.Acknowledgement `json:"receipt,omitempty"`
+}
+
+func ibcQueryChainsCmd(cmd *cobra.Command, args []string) error {
+	httpClient := client.NewHTTP(queryNodeFlag, "/websocket")
+
+	var chainIDs []string
+	_, err := readProven(httpClient, ibc.ChainListKey(), &chainIDs)
+	if err != nil {
+		return err
+	}
+
+	chains := []ibc.BlockchainState{}
+	for _, chainID := range chainIDs {
+		var state ibc.BlockchainState
+		_, err := readProven(httpClient, ibc.ChainStateKey(chainID), &state)
+		if err != nil {
+			return err
+		}
+		chains = append(chains, state)
+	}
+	return printJSON(chains)
+}
+
+func ibcQueryChainCmd(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("chain takes one argument, the chain id")
+	}
+	chainID := args[0]
+	httpClient := client.NewHTTP(queryNodeFlag, "/websocket")
+
+	var out IBCChainOutput
+	exists, err := readProven(httpClient, ibc.ChainStateKey(chainID), &out.State)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.Errorf("Chain %s is not registered", chainID)
+	}
+
+	ourID, err := queryProven(httpClient, types.ChainIDKey())
+	if err != nil {
+		return err
+	}
+	conn := new(ibc.Connection)
+	exists, err = readProven(httpClient, ibc.ConnectionKey(string(ourID), chainID), conn)
+	if err != nil {
+		return err
+	}
+	if exists {
+		out.Connection = conn
+	}
+	pruned := new(ibc.PrunedPackets)
+	exists, err = readProven(httpClient, ibc.PrunedPacketsKey(chainID), pruned)
+	if err != nil {
+		return err
+	}
+	if exists {
+		out.Pruned = pruned
+	}
+	return printJSON(out)
+}
+
+func ibcQueryPacketsCmd(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return errors.New("packets takes two arguments, the src and dst chain")
+	}
+	src, dst := args[0], args[1]
+	httpClient := client.NewHTTP(queryNodeFlag, "/websocket")
+
+	value, err := queryProven(httpClient, ibc.SequenceKey(src, dst))
+	if err != nil {
+		return err
+	}
+	var count uint64
+	if len(value) > 0 {
+		count, err = strconv.ParseUint(string(value), 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "parsing sequence number")
+		}
+	}
+
+	// the acknowledged packets may be pruned
+	var pruned ibc.PrunedPackets
+	_, err = readProven(httpClient, ibc.PrunedPacketsKey(dst), &pruned)
+	if err != nil {
+		return err
+	}
+
+	packets := []IBCPacketOutput{}
+	for seq := pruned.Egress; seq < count; seq++ {
+		packet, err := queryPacket(httpClient, src, dst, seq)
+		if err != nil {
+			return err
+		}
+		packets = append(packets, packet)
+	}
+	return printJSON(packets)
+}
+
+func ibcQueryPacketCmd(cmd *cobra.Command, args []string) error {
+	if len(args) != 3 {
+		return errors.New("packet takes three arguments, the src and dst chain and the sequence")
+	}
+	seq, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return errors.Errorf("Sequence must be a number: %v", err)
+	}
+	httpClient := client.NewHTTP(queryNodeFlag, "/websocket")
+
+	packet, err := queryPacket(httpClient, args[0], args[1], seq)
+	if err != nil {
+		return err
+	}
+	return printJSON(packet)
+}
+
+func queryPacket(httpClient *client.HTTP, src, dst string, seq uint64) (out IBCPacketOutput, err error) {
+	exists, err := readProven(httpClient, ibc.PacketKey(src, dst, seq), &out.Packet)
+	if err != nil {
+		return out, err
+	}
+	if !exists {
+		var pruned ibc.PrunedPackets
+		_, err = readProven(httpClient, ibc.PrunedPacketsKey(dst), &pruned)
+		if err == nil && seq < pruned.Egress {
+			err = errors.Errorf("Packet %d from %s to %s was acknowledged and pruned", seq, src, dst)
+		} else if err == nil {
+			err = errors.Errorf("No packet %d from %s to %s", seq, src, dst)
+		}
+		return out, err
+	}
+
+	receipt := new(ibc.Acknowledgement)
+	exists, err = readProven(httpClient, ibc.ReceiptKey(src, dst, seq), receipt)
+	if err != nil {
+		return out, err
+	}
+	if exists {
+		out.Receipt = receipt
+	}
+	return out, nil
+}
+
+// readProven is queryProven, and decodes the value into ptr.
+// It returns false if there is no value.
+func readProven(httpClient *client.HTTP, key []byte, ptr interface{}) (bool, error) {
+	value, err := queryProven(httpClient, key)
+	if err != nil || len(value) == 0 {
+		return false, err
+	}
+	err = wire.ReadBinaryBytes(value, ptr)
+	if err != nil {
+		return true, errors.Wrapf(err, "unmarshalling %s", key)
+	}
+	return true, nil
+}
+
+// queryProven queries the key, and checks the proof against the app hash
+// in the header at the height of the query
+func queryProven(httpClient *client.HTTP, key []byte) ([]byte, error) {
+	query, err := queryWithClient(httpClient, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(query.Value) == 0 {
+		return nil, nil
+	}
+
+	proof := new(iavl.IAVLProof)
+	err = wire.ReadBinaryBytes(query.Proof, &proof)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshalling proof")
+	}
+	header, err := headerAt(httpClient, query.Height)
+	if err != nil {
+		return nil, err
+	}
+	if !proof.Verify(key, query.Value, header.AppHash) {
+		return nil, errors.Errorf("Invalid proof for %s at height %d", key, query.Height)
+	}
+	return query.Value, nil
+}
+
+// headerAt returns the header at height. The query height is for the
+// next block, so we wait for it if it is not committed yet.
+func headerAt(httpClient *client.HTTP, height uint64) (*tmtypes.Header, error) {
+	res, err := httpClient.Commit(int(height))
+	if err != nil {
+		if err := waitForBlock(httpClient); err != nil {
+			return nil, err
+		}
+		res, err = httpClient.Commit(int(height))
+	}
+	if err != nil {
+		return nil, errors.Errorf("Error on commit: %v", err)
+	}
+	return res.Header, nil
+}
+
+func printJSON(obj interface{}) error {
+	out, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
@@ -22,12 +22,14 @@ var (
 
 //flags
 var (
-	chainIDFlag string
+	chainIDFlag    string
+	relayAdminFlag bool
 )
 
 func init() {
 	flags := []Flag2Register{
 		{&chainIDFlag, "chain-id", "test_chain_id", "Chain ID"},
+		{&relayAdminFlag, "relay-admin", false, "Make the demo key in key.json the IBC admin, for a local relay (its private key is public)"},
 	}
 	RegisterFlags(InitCmd, flags)
 }
@@ -56,13 +58,17 @@ func initCmd(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("`init` takes one argument, a basecoin account address. Generate one using `basecli keys new mykey`")
 	}
 	userAddr := args[0]
+	admin := userAddr
+	if relayAdminFlag {
+		admin = KeyAddress
+	}
 
 	// initalize basecoin
 	genesisFile := cfg.GenesisFile()
 	privValFile := cfg.PrivValidatorFile()
 	keyFile := path.Join(cfg.RootDir, "key.json")
 
-	mod1, err := setupFile(genesisFile, GetGenesisJSON(chainIDFlag, userAddr), 0644)
+	mod1, err := setupFile(genesisFile, GetGenesisJSON(chainIDFlag, userAddr, admin), 0644)
 	if err != nil {
 		return err
 	}
@@ -103,9 +109,10 @@ var PrivValJSON = `{
 }`
 
 // GetGenesisJSON returns a new tepleton genesis with Basecoin app_options
-// that grant a large amount of "mycoin" to a single address
+// that grant a large amount of "mycoin" to a single address, and make
+// admin the IBC admin, who opens and closes connections
 // TODO: A better UX for generating genesis files
-func GetGenesisJSON(chainID, addr string) string {
+func GetGenesisJSON(chainID, addr, admin string) string {
 	return fmt.Sprintf(`{
   "app_hash": "",
   "chain_id": "%s",
@@ -129,11 +136,15 @@ func GetGenesisJSON(chainID, addr string) string {
           "amount": 9007199254740992
         }
       ]
-    }]
+    }],
+    "plugin_options": ["IBC/admin", "%s"]
   }
-}`, chainID, addr)
+}`, chainID, addr, admin)
 }
 
+// KeyAddress is the address of KeyJSON
+const KeyAddress = "1B1BE55F969F54064628A63B9559E7C21C925165"
+
 // TODO: remove this once not needed for relay
 var KeyJSON = `{
   "address": "1B1BE55F969F54064628A63B9559E7C21C925165",
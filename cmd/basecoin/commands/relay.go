@@ -1,23 +1,25 @@
 package commands
 
 import (
-	"fmt"
+	"encoding/json"
 	"io/ioutil"
-	"strconv"
-	"time"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-
-	// 	"github.com/spf13/viper"
-	// 	"github.com/tepleton/tmlibs/cli"
-	// 	"github.com/tepleton/tmlibs/log"
+	"github.com/spf13/viper"
 
 	"github.com/tepleton/go-wire"
 	"github.com/tepleton/merkleeyes/iavl"
-	cmn "github.com/tepleton/tmlibs/common"
+	"github.com/tepleton/tmlibs/cli"
+	"github.com/tepleton/tmlibs/events"
 
 	"github.com/tepleton/basecoin/plugins/ibc"
+	"github.com/tepleton/basecoin/plugins/ibc/relay"
 	"github.com/tepleton/basecoin/types"
 	"github.com/tepleton/tepleton/rpc/client"
 	tmtypes "github.com/tepleton/tepleton/types"
@@ -31,12 +33,15 @@ var RelayCmd = &cobra.Command{
 var RelayStartCmd = &cobra.Command{
 	Use:   "start",
 	Short: "Start basecoin relayer to relay IBC packets between chains",
-	RunE:  relayStartCmd,
+	Long: `Start basecoin relayer to relay IBC packets between chains.
+It relays between chain1 and chain2, or between all chains in the --config file.
+Progress is saved to the --progress file, so a restarted relayer continues where it stopped.`,
+	RunE: relayStartCmd,
 }
 
 var RelayInitCmd = &cobra.Command{
 	Use:   "init",
-	Short: "Register both chains with each other, to prepare the relayer to run",
+	Short: "Register both chains with each other, and open a connection between them",
 	RunE:  relayInitCmd,
 }
 
@@ -52,6 +57,9 @@ var (
 
 	genesisFile1Flag string
 	genesisFile2Flag string
+
+	relayConfigFlag   string
+	relayProgressFlag string
 )
 
 func init() {
@@ -70,30 +78,76 @@ func init() {
 	}
 	RegisterFlags(RelayInitCmd, initFlags)
 
+	startFlags := []Flag2Register{
+		{&relayConfigFlag, "config", "", "Path to a json file with all chains to relay between, instead of chain1 and chain2"},
+		{&relayProgressFlag, "progress", "relay-progress.json", "Path to the file where the relayer saves its progress"},
+	}
+	RegisterFlags(RelayStartCmd, startFlags)
+
 	RelayCmd.AddCommand(RelayStartCmd)
 	RelayCmd.AddCommand(RelayInitCmd)
 }
 
 func relayStartCmd(cmd *cobra.Command, args []string) error {
-	go loop(chain1AddrFlag, chain2AddrFlag, chain1IDFlag, chain2IDFlag)
-	go loop(chain2AddrFlag, chain1AddrFlag, chain2IDFlag, chain1IDFlag)
+	config, err := loadRelayConfig(relayConfigFlag)
+	if err != nil {
+		return err
+	}
+	privKey, err := LoadKey(fromFileFlag)
+	if err != nil {
+		return err
+	}
+	progress, err := relay.LoadProgress(rootPath(relayProgressFlag))
+	if err != nil {
+		return err
+	}
 
-	cmn.TrapSignal(func() {
-		// TODO: Cleanup
-	})
-	return nil
+	chains := make([]relay.Chain, len(config.Chains))
+	for i, chain := range config.Chains {
+		chains[i] = newHTTPChain(privKey, chain.ChainID, chain.Node)
+	}
+	r := relay.NewRelayer(chains, progress, logger)
+
+	quit := make(chan struct{})
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- r.Run(quit)
+	}()
+
+	// run until a signal, or until the relayer fails
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err = <-stopped:
+		return errors.Wrap(err, "relayer stopped")
+	case <-signals:
+		close(quit)
+		return <-stopped
+	}
 }
 
 func relayInitCmd(cmd *cobra.Command, args []string) error {
-	err := registerChain(chain1IDFlag, chain1AddrFlag, chain2IDFlag, genesisFile2Flag, fromFileFlag)
+	privKey, err := LoadKey(fromFileFlag)
 	if err != nil {
 		return err
 	}
-	err = registerChain(chain2IDFlag, chain2AddrFlag, chain1IDFlag, genesisFile1Flag, fromFileFlag)
-	return err
+	chain1 := newHTTPChain(privKey, chain1IDFlag, chain1AddrFlag)
+	chain2 := newHTTPChain(privKey, chain2IDFlag, chain2AddrFlag)
+
+	err = registerChain(chain1, chain2IDFlag, genesisFile2Flag)
+	if err != nil {
+		return err
+	}
+	err = registerChain(chain2, chain1IDFlag, genesisFile1Flag)
+	if err != nil {
+		return err
+	}
+
+	// the relayer takes care of the rest of the handshake
+	return chain1.SendTx(ibc.IBCConnectionInitTx{Counterparty: chain2IDFlag})
 }
 
-func registerChain(chainID, node, registerChainID, registerGenesis, keyFile string) error {
+func registerChain(chain relay.Chain, registerChainID, registerGenesis string) error {
 	genesisBytes, err := ioutil.ReadFile(registerGenesis)
 	if err != nil {
 		return errors.Errorf("Error reading genesis file %v: %v\n", registerGenesis, err)
@@ -105,145 +159,115 @@ func registerChain(chainID, node, registerChainID, registerGenesis, keyFile stri
 			Genesis: string(genesisBytes),
 		},
 	}
-
-	privKey, err := LoadKey(keyFile)
-	if err != nil {
-		return err
-	}
-	relay := newRelayer(privKey, chainID, node)
-	return relay.appTx(ibcTx)
+	return chain.SendTx(ibcTx)
 }
 
-func loop(addr1, addr2, id1, id2 string) {
-	nextSeq := 0
-
-	// load the priv key
-	privKey, err := LoadKey(fromFileFlag)
-	if err != nil {
-		logger.Error(err.Error())
-		cmn.PanicCrisis(err.Error())
+// rootPath resolves relative paths against the home dir, like LoadKey
+func rootPath(file string) string {
+	if file == "" || strings.HasPrefix(file, "/") || strings.HasPrefix(file, ".") {
+		return file
 	}
+	return path.Join(viper.GetString(cli.HomeFlag), file)
+}
 
-	// relay from chain1 to chain2
-	thisRelayer := newRelayer(privKey, id2, addr2)
+//--------------------------------------------------------------------------------
 
-	logger.Info(fmt.Sprintf("Relaying from chain %v on %v to chain %v on %v", id1, addr1, id2, addr2))
+// relayConfig lists the chains to relay between, every pair of them
+// is relayed in both directions
+type relayConfig struct {
+	Chains []relayChainConfig `json:"chains"`
+}
 
-	httpClient := client.NewHTTP(addr1, "/websocket")
+type relayChainConfig struct {
+	ChainID string `json:"chain_id"`
+	Node    string `json:"node"`
+}
 
-OUTER:
-	for {
+// loadRelayConfig reads the config file, or uses chain1 and chain2
+// from the flags if there is none
+func loadRelayConfig(file string) (*relayConfig, error) {
+	if file == "" {
+		return &relayConfig{Chains: []relayChainConfig{
+			{chain1IDFlag, chain1AddrFlag},
+			{chain2IDFlag, chain2AddrFlag},
+		}}, nil
+	}
+	bz, err := ioutil.ReadFile(rootPath(file))
+	if err != nil {
+		return nil, err
+	}
+	config := new(relayConfig)
+	err = json.Unmarshal(bz, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading relay config")
+	}
+	if len(config.Chains) < 2 {
+		return nil, errors.New("relay config needs at least two chains")
+	}
+	return config, nil
+}
 
-		time.Sleep(time.Second)
+// httpChain talks to a node over rpc
+type httpChain struct {
+	privKey *Key
+	chainID string
+	client  *client.HTTP
+}
 
-		// get the latest ibc packet sequence number
-		key := fmt.Sprintf("ibc,egress,%v,%v", id1, id2)
-		query, err := queryWithClient(httpClient, []byte(key))
-		if err != nil {
-			logger.Error("Error querying for latest sequence", "key", key, "error", err.Error())
-			continue OUTER
-		}
-		if len(query.Value) == 0 {
-			// nothing yet
-			continue OUTER
-		}
+var _ relay.Chain = (*httpChain)(nil)
 
-		seq, err := strconv.ParseUint(string(query.Value), 10, 64)
-		if err != nil {
-			logger.Error("Error parsing sequence number from query", "query.Value", query.Value, "error", err.Error())
-			continue OUTER
-		}
-		seq -= 1 // seq is the packet count. -1 because 0-indexed
+func newHTTPChain(privKey *Key, chainID, nodeAddr string) *httpChain {
+	return &httpChain{
+		privKey: privKey,
+		chainID: chainID,
+		client:  client.NewHTTP(nodeAddr, "/websocket"),
+	}
+}
 
-		if nextSeq <= int(seq) {
-			logger.Info("Got new packets", "last-sequence", nextSeq-1, "new-sequence", seq)
-		}
+func (c *httpChain) ChainID() string {
+	return c.chainID
+}
 
-		// get all packets since the last one we relayed
-		for ; nextSeq <= int(seq); nextSeq++ {
-			key := fmt.Sprintf("ibc,egress,%v,%v,%d", id1, id2, nextSeq)
-			query, err := queryWithClient(httpClient, []byte(key))
-			if err != nil {
-				logger.Error("Error querying for packet", "seqeuence", nextSeq, "key", key, "error", err.Error())
-				continue OUTER
-			}
-
-			var packet ibc.Packet
-			err = wire.ReadBinaryBytes(query.Value, &packet)
-			if err != nil {
-				logger.Error("Error unmarshalling packet", "key", key, "query.Value", query.Value, "error", err.Error())
-				continue OUTER
-			}
-
-			proof := new(iavl.IAVLProof)
-			err = wire.ReadBinaryBytes(query.Proof, &proof)
-			if err != nil {
-				logger.Error("Error unmarshalling proof", "query.Proof", query.Proof, "error", err.Error())
-				continue OUTER
-			}
-
-			// query.Height is actually for the next block,
-			// so wait a block before we fetch the header & commit
-			if err := waitForBlock(httpClient); err != nil {
-				logger.Error("Error waiting for a block", "addr", addr1, "error", err.Error())
-				continue OUTER
-			}
-
-			// get the header and commit from the height the query was done at
-			res, err := httpClient.Commit(int(query.Height))
-			if err != nil {
-				logger.Error("Error fetching header and commits", "height", query.Height, "error", err.Error())
-				continue OUTER
-			}
-
-			// update the chain state on the other chain
-			updateTx := ibc.IBCUpdateChainTx{
-				Header: *res.Header,
-				Commit: *res.Commit,
-			}
-			logger.Info("Updating chain", "src-chain", id1, "height", res.Header.Height, "appHash", res.Header.AppHash)
-			if err := thisRelayer.appTx(updateTx); err != nil {
-				logger.Error("Error creating/sending IBCUpdateChainTx", "error", err.Error())
-				continue OUTER
-			}
-
-			// relay the packet and proof
-			logger.Info("Relaying packet", "src-chain", id1, "height", query.Height, "sequence", nextSeq)
-			postTx := ibc.IBCPacketPostTx{
-				FromChainID:     id1,
-				FromChainHeight: query.Height,
-				Packet:          packet,
-				Proof:           proof,
-			}
-
-			if err := thisRelayer.appTx(postTx); err != nil {
-				logger.Error("Error creating/sending IBCPacketPostTx", "error", err.Error())
-				// dont `continue OUTER` here. the error might be eg. Already exists
-				// TODO: catch this programmatically ?
-			}
-		}
+func (c *httpChain) Query(key []byte) ([]byte, *iavl.IAVLProof, uint64, error) {
+	query, err := queryWithClient(c.client, key)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if len(query.Value) == 0 {
+		return nil, nil, query.Height, nil
+	}
+	proof := new(iavl.IAVLProof)
+	err = wire.ReadBinaryBytes(query.Proof, &proof)
+	if err != nil {
+		return nil, nil, 0, errors.Wrap(err, "unmarshalling proof")
 	}
+	return query.Value, proof, query.Height, nil
 }
 
-type relayer struct {
-	privKey  *Key
-	chainID  string
-	nodeAddr string
-	client   *client.HTTP
+// Commit fails until the block at height is committed.
+// The query height is for the next block, so this is retried
+// by the relayer on the next one.
+func (c *httpChain) Commit(height uint64) (tmtypes.Header, tmtypes.Commit, error) {
+	res, err := c.client.Commit(int(height))
+	if err != nil {
+		return tmtypes.Header{}, tmtypes.Commit{}, err
+	}
+	return *res.Header, *res.Commit, nil
 }
 
-func newRelayer(privKey *Key, chainID, nodeAddr string) *relayer {
-	httpClient := client.NewHTTP(nodeAddr, "/websocket")
-	return &relayer{
-		privKey:  privKey,
-		chainID:  chainID,
-		nodeAddr: nodeAddr,
-		client:   httpClient,
+// Validators returns the latest validators, as the node keeps no others.
+// If they changed since height, UpdateChainTx rejects them, and the
+// relayer retries on a later block.
+func (c *httpChain) Validators(height uint64) ([]*tmtypes.Validator, error) {
+	res, err := c.client.Validators()
+	if err != nil {
+		return nil, err
 	}
+	return res.Validators, nil
 }
 
-func (r *relayer) appTx(ibcTx ibc.IBCTx) error {
-	acc, err := getAccWithClient(r.client, r.privKey.Address[:])
+func (c *httpChain) SendTx(ibcTx ibc.IBCTx) error {
+	acc, err := getAccWithClient(c.client, c.privKey.Address[:])
 	if err != nil {
 		return err
 	}
@@ -255,7 +279,7 @@ func (r *relayer) appTx(ibcTx ibc.IBCTx) error {
 
 	smallCoins := types.Coin{"mycoin", 1}
 
-	input := types.NewTxInput(r.privKey.PubKey, types.Coins{smallCoins}, sequence)
+	input := types.NewTxInput(c.privKey.PubKey, types.Coins{smallCoins}, sequence)
 	tx := &types.AppTx{
 		Gas:   0,
 		Fee:   smallCoins,
@@ -264,16 +288,27 @@ func (r *relayer) appTx(ibcTx ibc.IBCTx) error {
 		Data:  data,
 	}
 
-	tx.Input.Signature = r.privKey.Sign(tx.SignBytes(r.chainID))
+	tx.Input.Signature = c.privKey.Sign(tx.SignBytes(c.chainID))
 	txBytes := []byte(wire.BinaryBytes(struct {
 		types.Tx `json:"unwrap"`
 	}{tx}))
 
-	data, log, err := broadcastTxWithClient(r.client, txBytes)
+	_, _, err = broadcastTxWithClient(c.client, txBytes)
+	return err
+}
+
+// Subscribe listens for new blocks on the websocket
+func (c *httpChain) Subscribe(blocks chan<- struct{}) error {
+	_, err := c.client.Start()
 	if err != nil {
 		return err
 	}
-	_, _ = data, log
+	c.client.AddListenerForEvent("relayer", tmtypes.EventStringNewBlock(), func(events.EventData) {
+		select {
+		case blocks <- struct{}{}:
+		default: // a relay is already pending
+		}
+	})
 	return nil
 }
 
@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/tepleton/tmlibs/cli"
+
+	"github.com/tepleton/basecoin/state"
+	"github.com/tepleton/basecoin/types"
+)
+
+var SnapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Create or restore a snapshot of the basecoin state",
+}
+
+var SnapshotCreateCmd = &cobra.Command{
+	Use:   "create [file]",
+	Short: "Write all key/value pairs of the last committed state to a file",
+	RunE:  snapshotCreateCmd,
+}
+
+var SnapshotRestoreCmd = &cobra.Command{
+	Use:   "restore [file]",
+	Short: "Rebuild the state from a snapshot, and verify the app hash",
+	Long: `Rebuild the state from a snapshot into an empty MerkleEyes, and verify the app hash.
+Stop the node before running this, and reset it with unsafe_reset_all first.`,
+	RunE: snapshotRestoreCmd,
+}
+
+func init() {
+	SnapshotCmd.PersistentFlags().String(FlagEyes, "local", "MerkleEyes address, or 'local' for embedded")
+	SnapshotCmd.AddCommand(SnapshotCreateCmd)
+	SnapshotCmd.AddCommand(SnapshotRestoreCmd)
+}
+
+func snapshotCreateCmd(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("`snapshot create` takes one argument, the file to write")
+	}
+	eyesCli, err := connectEyes(viper.GetString(cli.HomeFlag), viper.GetString(FlagEyes))
+	if err != nil {
+		return err
+	}
+	info, err := eyesCli.InfoSync()
+	if err != nil {
+		return errors.Wrap(err, "querying MerkleEyes")
+	}
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := state.SnapshotHeader{
+		Height:  info.LastBlockHeight,
+		AppHash: info.LastBlockAppHash,
+	}
+	n, err := state.WriteSnapshot(f, types.NewEyesStore(eyesCli), header)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d keys at height %d (app hash %X) to %s\n", n, header.Height, header.AppHash, args[0])
+	return f.Close()
+}
+
+func snapshotRestoreCmd(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("`snapshot restore` takes one argument, the file to read")
+	}
+	eyesCli, err := connectEyes(viper.GetString(cli.HomeFlag), viper.GetString(FlagEyes))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header, err := state.RestoreSnapshot(f, types.NewEyesStore(eyesCli))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored state at height %d (app hash %X)\n", header.Height, header.AppHash)
+	return nil
+}
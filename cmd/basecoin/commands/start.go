@@ -21,6 +21,7 @@ import (
 	"github.com/tepleton/tepleton/types"
 
 	"github.com/tepleton/basecoin/app"
+	btypes "github.com/tepleton/basecoin/types"
 )
 
 var StartCmd = &cobra.Command{
@@ -37,6 +38,7 @@ const (
 	FlagAddress           = "address"
 	FlagEyes              = "eyes"
 	FlagWithoutTendermint = "without-tepleton"
+	FlagHandlers          = "handlers"
 )
 
 func init() {
@@ -44,37 +46,19 @@ func init() {
 	flags.String(FlagAddress, "tcp://0.0.0.0:46658", "Listen address")
 	flags.String(FlagEyes, "local", "MerkleEyes address, or 'local' for embedded")
 	flags.Bool(FlagWithoutTendermint, false, "Only run basecoin wrsp app, assume external tepleton process")
+	flags.Bool(FlagHandlers, false, "Run txs through the default handler stack, with plugins called by PluginTx")
 	// add all standard 'tepleton node' flags
 	tcmd.AddNodeFlags(StartCmd)
 }
 
 func startCmd(cmd *cobra.Command, args []string) error {
 	rootDir := viper.GetString(cli.HomeFlag)
-	meyes := viper.GetString(FlagEyes)
-
-	// Connect to MerkleEyes
-	var eyesCli *eyes.Client
-	if meyes == "local" {
-		eyesApp.SetLogger(logger.With("module", "merkleeyes"))
-		eyesCli = eyes.NewLocalClient(path.Join(rootDir, "data", "merkleeyes.db"), EyesCacheSize)
-	} else {
-		var err error
-		eyesCli, err = eyes.NewClient(meyes)
-		if err != nil {
-			return errors.Errorf("Error connecting to MerkleEyes: %v\n", err)
-		}
+	basecoinApp, err := newBasecoinApp(rootDir, viper.GetString(FlagEyes))
+	if err != nil {
+		return err
 	}
-
-	// Create Basecoin app
-	basecoinApp := app.NewBasecoin(eyesCli)
-	basecoinApp.SetLogger(logger.With("module", "app"))
-
-	// register IBC plugn
-	basecoinApp.RegisterPlugin(NewIBCPlugin())
-
-	// register all other plugins
-	for _, p := range plugins {
-		basecoinApp.RegisterPlugin(p.newPlugin())
+	if viper.GetBool(FlagHandlers) {
+		basecoinApp.SetDefaultHandler(btypes.Coins{})
 	}
 
 	// if chain_id has not been set yet, load the genesis.
@@ -104,6 +88,46 @@ func startCmd(cmd *cobra.Command, args []string) error {
 	}
 }
 
+// connectEyes connects to MerkleEyes, or 'local' for embedded
+func connectEyes(rootDir, meyes string) (*eyes.Client, error) {
+	if meyes == "local" {
+		eyesApp.SetLogger(logger.With("module", "merkleeyes"))
+		return eyes.NewLocalClient(path.Join(rootDir, "data", "merkleeyes.db"), EyesCacheSize), nil
+	}
+	eyesCli, err := eyes.NewClient(meyes)
+	if err != nil {
+		return nil, errors.Errorf("Error connecting to MerkleEyes: %v\n", err)
+	}
+	return eyesCli, nil
+}
+
+// newBasecoinApp connects to MerkleEyes ('local' for embedded)
+// and creates the app with all registered plugins
+func newBasecoinApp(rootDir, meyes string) (*app.Basecoin, error) {
+	eyesCli, err := connectEyes(rootDir, meyes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create Basecoin app
+	basecoinApp := app.NewBasecoin(eyesCli)
+	basecoinApp.SetLogger(logger.With("module", "app"))
+
+	// register IBC plugn
+	ibcPlugin := NewIBCPlugin()
+	ibcPlugin.SetLogger(logger.With("module", "ibc"))
+	basecoinApp.RegisterPlugin(ibcPlugin)
+
+	// register all other plugins, other chains can call those routed
+	// with the IBC/route option over IBC
+	for _, p := range plugins {
+		plugin := p.newPlugin()
+		basecoinApp.RegisterPlugin(plugin)
+		ibcPlugin.AddRoute(plugin)
+	}
+	return basecoinApp, nil
+}
+
 func startBasecoinWRSP(basecoinApp *app.Basecoin) error {
 	// Start the WRSP listener
 	addr := viper.GetString(FlagAddress)
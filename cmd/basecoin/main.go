@@ -13,7 +13,10 @@ func main() {
 	rt.AddCommand(
 		commands.InitCmd,
 		commands.StartCmd,
+		commands.ExportCmd,
+		commands.SnapshotCmd,
 		commands.RelayCmd,
+		commands.NodeQueryCmd,
 		commands.UnsafeResetAllCmd,
 		commands.VersionCmd,
 	)
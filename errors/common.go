@@ -6,6 +6,14 @@ package errors
 
 import wrsp "github.com/tepleton/wrsp/types"
 
+// Error codes for the handler stack, above the range used by wrsp
+const (
+	CodeTypeWrongChain wrsp.CodeType = 2001
+	CodeTypeExpired    wrsp.CodeType = 2002
+	CodeTypeOutOfGas   wrsp.CodeType = 2003
+	CodeTypeUnknownTx  wrsp.CodeType = 2004
+)
+
 const (
 	msgDecoding          = "Error decoding input"
 	msgUnauthorized      = "Unauthorized"
@@ -15,11 +23,17 @@ const (
 	msgInvalidSequence   = "Invalid Sequence"
 	msgInvalidSignature  = "Invalid Signature"
 	msgInsufficientFees  = "Insufficient Fees"
+	msgInsufficientFunds = "Insufficient Funds"
 	msgNoInputs          = "No Input Coins"
 	msgNoOutputs         = "No Output Coins"
 	msgTooLarge          = "Input size too large"
 	msgMissingSignature  = "Signature missing"
 	msgTooManySignatures = "Too many signatures"
+	msgNoChain           = "No chain id provided"
+	msgWrongChain        = "Tx belongs to different chain"
+	msgExpired           = "Tx expired"
+	msgOutOfGas          = "Out of gas"
+	msgUnknownTx         = "Unknown tx type"
 )
 
 func DecodingError() TMError {
@@ -62,6 +76,10 @@ func InsufficientFees() TMError {
 	return New(msgInsufficientFees, wrsp.CodeType_BaseInvalidInput)
 }
 
+func InsufficientFunds() TMError {
+	return New(msgInsufficientFunds, wrsp.CodeType_BaseInsufficientFunds)
+}
+
 func NoInputs() TMError {
 	return New(msgNoInputs, wrsp.CodeType_BaseInvalidInput)
 }
@@ -73,3 +91,25 @@ func NoOutputs() TMError {
 func TooLarge() TMError {
 	return New(msgTooLarge, wrsp.CodeType_EncodingError)
 }
+
+func NoChain() TMError {
+	return New(msgNoChain, wrsp.CodeType_BaseInvalidInput)
+}
+
+func WrongChain() TMError {
+	return New(msgWrongChain, CodeTypeWrongChain)
+}
+
+func Expired() TMError {
+	return New(msgExpired, CodeTypeExpired)
+}
+
+func OutOfGas() TMError {
+	return New(msgOutOfGas, CodeTypeOutOfGas)
+}
+
+// UnknownTx is returned for a tx type with no handler,
+// name is the type name from the TxMapper
+func UnknownTx(name string) TMError {
+	return New(msgUnknownTx+": "+name, CodeTypeUnknownTx)
+}
@@ -89,3 +89,24 @@ func New(msg string, code wrsp.CodeType) TMError {
 		msg:         msg,
 	}
 }
+
+// charged is a TMError for a tx that failed after paying its fee
+type charged struct {
+	TMError
+}
+
+// Charged marks err as failing the tx after its fee was paid. The app
+// keeps the state changes of such a tx, so the handler that failed
+// must have rolled back everything but the fee itself.
+func Charged(err error) TMError {
+	if err == nil {
+		return nil
+	}
+	return charged{Wrap(err)}
+}
+
+// IsCharged is true if the tx paid its fee, though it failed with err
+func IsCharged(err error) bool {
+	_, ok := err.(charged)
+	return ok
+}
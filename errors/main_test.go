@@ -26,6 +26,8 @@ func TestCreateResult(t *testing.T) {
 		{WithCode(stderr.New("coded"), wrsp.CodeType_BaseInvalidInput), "coded", wrsp.CodeType_BaseInvalidInput},
 		{DecodingError(), msgDecoding, wrsp.CodeType_EncodingError},
 		{Unauthorized(), msgUnauthorized, wrsp.CodeType_Unauthorized},
+		{UnknownTx("foo"), msgUnknownTx + ": foo", CodeTypeUnknownTx},
+		{Charged(OutOfGas()), msgOutOfGas, CodeTypeOutOfGas},
 	}
 
 	for idx, tc := range cases {
@@ -37,3 +39,14 @@ func TestCreateResult(t *testing.T) {
 		assert.Equal(tc.code, res.Code, i)
 	}
 }
+
+func TestCharged(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(Charged(nil))
+	assert.False(IsCharged(nil))
+	assert.False(IsCharged(OutOfGas()))
+	assert.False(IsCharged(stderr.New("base")))
+	assert.True(IsCharged(Charged(OutOfGas())))
+	assert.True(IsCharged(Charged(stderr.New("base"))))
+}
@@ -2,11 +2,14 @@ package basecoin
 
 import (
 	"bytes"
+	"fmt"
 
 	wrsp "github.com/tepleton/wrsp/types"
 	crypto "github.com/tepleton/go-crypto"
 	"github.com/tepleton/go-wire/data"
+	"github.com/tepleton/tmlibs/log"
 
+	"github.com/tepleton/basecoin/errors"
 	"github.com/tepleton/basecoin/types"
 )
 
@@ -22,27 +25,160 @@ type Handler interface {
 	// EndBlock(store types.KVStore, height uint64) wrsp.ResponseEndBlock
 }
 
-// TODO: Context is a place-holder, soon we add some request data here from the
-// higher-levels (like tell an app who signed).
-// Trust me, we will need it like CallContext now...
+// Context carries the block the tx runs in, and the permissions it
+// was given, down the handler stack. It is immutable: every With method
+// returns a modified copy, so a handler can only change what the
+// handlers it calls see, never what its caller sees.
+//
+// Only a context from NewContext can grant permissions, so a handler
+// can't forge them on a Context{} of its own.
 type Context struct {
-	sigs []crypto.PubKey
+	chainID string
+	height  uint64
+	time    uint64
+	module  string
+	logger  log.Logger
+	perms   []Actor
+	sigs    []crypto.PubKey
+	gas     *types.GasMeter
+	granter bool // made by NewContext, so it may grant permissions
 }
 
-// TOTALLY insecure.  will redo later, but you get the point
-func (c Context) AddSigners(keys ...crypto.PubKey) Context {
+// NewContext is created by the app for every tx, with the chain id and
+// the height and time (unix seconds) of the block the tx runs in
+func NewContext(chainID string, height, time uint64, logger log.Logger) Context {
 	return Context{
-		sigs: append(c.sigs, keys...),
+		chainID: chainID,
+		height:  height,
+		time:    time,
+		logger:  logger,
+		granter: true,
 	}
 }
 
+// WithPermissions grants the tx the permissions of the actors.
+// Middleware may grant any actor, but once the context is scoped to
+// a module, the module can only grant its own actors on this chain.
+func (c Context) WithPermissions(actors ...Actor) (Context, error) {
+	if !c.granter {
+		return c, errors.New("Context cannot grant permissions", wrsp.CodeType_Unauthorized)
+	}
+	for _, a := range actors {
+		if c.module != "" && (a.ChainID != "" || a.App != c.module) {
+			return c, errors.New(fmt.Sprintf("Module %s cannot grant %s", c.module, a),
+				wrsp.CodeType_Unauthorized)
+		}
+	}
+	perms := make([]Actor, 0, len(c.perms)+len(actors))
+	c.perms = append(append(perms, c.perms...), actors...)
+	return c, nil
+}
+
+// AddSigners grants the permissions of the keys that signed the tx.
+// Only middleware which verified the signatures may call it.
+func (c Context) AddSigners(keys ...crypto.PubKey) (Context, error) {
+	actors := make([]Actor, len(keys))
+	for i, pk := range keys {
+		actors[i] = SigActor(pk)
+	}
+	c, err := c.WithPermissions(actors...)
+	if err != nil {
+		return c, err
+	}
+	sigs := make([]crypto.PubKey, 0, len(c.sigs)+len(keys))
+	c.sigs = append(append(sigs, c.sigs...), keys...)
+	return c, nil
+}
+
+// WithModule scopes the context to the module running the tx. All log
+// lines are tagged with the module, and it can only grant its own actors.
+// A context scoped to one module cannot be moved to another one, and
+// no module may take the name of the signers.
+func (c Context) WithModule(name string) (Context, error) {
+	if name == NameSigs {
+		return c, errors.New(fmt.Sprintf("No module may be named %s", name),
+			wrsp.CodeType_Unauthorized)
+	}
+	if c.module != "" && c.module != name {
+		return c, errors.New(fmt.Sprintf("Context of module %s cannot move to %s", c.module, name),
+			wrsp.CodeType_Unauthorized)
+	}
+	c.logger = c.Logger().With("module", name)
+	c.module = name
+	return c, nil
+}
+
+// Module is the module the context is scoped to, "" for middleware
+func (c Context) Module() string {
+	return c.module
+}
+
+// Logger returns the logger for the current module, never nil
+func (c Context) Logger() log.Logger {
+	if c.logger == nil {
+		return log.NewNopLogger()
+	}
+	return c.logger
+}
+
+// ChainID is the id of the chain the tx runs on
+func (c Context) ChainID() string {
+	return c.chainID
+}
+
+// WithHeight sets the height of the block the tx is executed in
+func (c Context) WithHeight(height uint64) Context {
+	c.height = height
+	return c
+}
+
+// BlockHeight is the height of the block the tx is executed in
+func (c Context) BlockHeight() uint64 {
+	return c.height
+}
+
+// BlockTime is the time of the block the tx is executed in, in unix
+// seconds from the block header. CheckTx gets the time of the last block.
+func (c Context) BlockTime() uint64 {
+	return c.time
+}
+
+// WithGasMeter sets the meter all gas for this tx is charged to
+func (c Context) WithGasMeter(meter *types.GasMeter) Context {
+	c.gas = meter
+	return c
+}
+
+// GasMeter returns the gas meter for the tx, nil if it is unmetered
+func (c Context) GasMeter() *types.GasMeter {
+	return c.gas
+}
+
+// GetSigners returns the keys that signed the tx
 func (c Context) GetSigners() []crypto.PubKey {
 	return c.sigs
 }
 
-func (c Context) IsSignerAddr(addr []byte) bool {
-	for _, pk := range c.sigs {
-		if bytes.Equal(addr, pk.Address()) {
+// GetPermissions returns all actors that authorized the tx
+func (c Context) GetPermissions() []Actor {
+	return c.perms
+}
+
+// HasPermission is true if the actor authorized the tx
+func (c Context) HasPermission(actor Actor) bool {
+	for _, a := range c.perms {
+		if actor.Equals(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAccountPermission is true if the owner of the account
+// at addr authorized the tx
+func (c Context) HasAccountPermission(addr []byte) bool {
+	for _, a := range c.perms {
+		if bytes.Equal(addr, a.AccountAddress()) {
 			return true
 		}
 	}
@@ -0,0 +1,98 @@
+package basecoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	crypto "github.com/tepleton/go-crypto"
+	"github.com/tepleton/tmlibs/log"
+)
+
+func TestContext(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := NewContext("my-chain", 10, 1500000000, log.NewNopLogger())
+	assert.Equal("my-chain", ctx.ChainID())
+	assert.EqualValues(10, ctx.BlockHeight())
+	assert.EqualValues(1500000000, ctx.BlockTime())
+	assert.Equal("", ctx.Module())
+	assert.NotNil(Context{}.Logger())
+
+	// changes are only seen by the new context
+	pk := crypto.GenPrivKeyEd25519().Wrap().PubKey()
+	signed, err := ctx.AddSigners(pk)
+	assert.Nil(err)
+	assert.True(signed.IsSignerKey(pk))
+	assert.True(signed.HasAccountPermission(pk.Address()))
+	assert.False(ctx.IsSignerKey(pk))
+	assert.Empty(ctx.GetSigners())
+	assert.EqualValues(11, ctx.WithHeight(11).BlockHeight())
+	assert.EqualValues(10, ctx.BlockHeight())
+
+	// a module keeps the permissions, but can't add any
+	scoped, err := signed.WithModule("coin")
+	assert.Nil(err)
+	assert.Equal("coin", scoped.Module())
+	assert.Equal("", signed.Module())
+	assert.True(scoped.IsSignerKey(pk))
+	other := crypto.GenPrivKeyEd25519().Wrap().PubKey()
+	_, err = scoped.AddSigners(other)
+	assert.NotNil(err)
+	_, err = signed.AddSigners(other)
+	assert.Nil(err)
+
+	// and can't escape its scope
+	_, err = scoped.WithModule("coin")
+	assert.Nil(err)
+	_, err = scoped.WithModule("other")
+	assert.NotNil(err)
+	_, err = signed.WithModule(NameSigs)
+	assert.NotNil(err)
+
+	// a module can only vouch for itself
+	mine := NewActor("coin", []byte("pool"))
+	granted, err := scoped.WithPermissions(mine)
+	assert.Nil(err)
+	assert.True(granted.HasPermission(mine))
+	assert.True(granted.HasAccountPermission(mine.AccountAddress()))
+	assert.False(scoped.HasPermission(mine))
+	_, err = scoped.WithPermissions(NewActor("other", []byte("pool")))
+	assert.NotNil(err)
+	foreign := Actor{ChainID: "other-chain", App: "coin", Address: []byte("pool")}
+	_, err = scoped.WithPermissions(foreign)
+	assert.NotNil(err)
+	granted, err = ctx.WithPermissions(foreign)
+	assert.Nil(err)
+	assert.True(granted.HasPermission(foreign))
+
+	// a context the app didn't create can't grant anything
+	_, err = Context{}.AddSigners(pk)
+	assert.NotNil(err)
+	_, err = Context{}.WithPermissions(mine)
+	assert.NotNil(err)
+}
+
+func TestActor(t *testing.T) {
+	assert := assert.New(t)
+
+	pk := crypto.GenPrivKeyEd25519().Wrap().PubKey()
+	sig := SigActor(pk)
+	assert.True(sig.Equals(NewActor(NameSigs, pk.Address())))
+
+	// keys own the account of their address, nobody else can claim it
+	actors := []Actor{
+		sig,
+		NewActor("coin", pk.Address()),
+		{ChainID: "other-chain", App: NameSigs, Address: pk.Address()},
+		{ChainID: "other-chain", App: "coin", Address: pk.Address()},
+	}
+	assert.Equal(pk.Address(), sig.AccountAddress())
+	for i, a := range actors {
+		assert.Equal(20, len(a.AccountAddress()), "%d", i)
+		for j, b := range actors[:i] {
+			assert.False(a.Equals(b), "%d %d", i, j)
+			assert.NotEqual(a.AccountAddress(), b.AccountAddress(), "%d %d", i, j)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"github.com/tepleton/basecoin/errors"
+	"github.com/tepleton/basecoin/types"
+)
+
+// SimpleAccountChecker implements AccountChecker on top of the
+// basecoin accounts stored under types.AccountKey
+type SimpleAccountChecker struct{}
+
+var _ CoinAccountChecker = SimpleAccountChecker{}
+
+func (SimpleAccountChecker) GetAmount(store types.KVStore, addr []byte) (types.Coins, error) {
+	acc := types.GetAccount(store, addr)
+	if acc == nil {
+		return nil, nil
+	}
+	return acc.Balance, nil
+}
+
+func (SimpleAccountChecker) ChangeAmount(store types.KVStore, addr []byte, coins types.Coins) (types.Coins, error) {
+	acc := types.GetAccount(store, addr)
+	if acc == nil {
+		// zero value is valid, empty account
+		acc = &types.Account{}
+	}
+	final := acc.Balance.Plus(coins)
+	if !final.IsNonnegative() {
+		return nil, errors.InsufficientFunds()
+	}
+	acc.Balance = final
+	types.SetAccount(store, addr, acc)
+	return final, nil
+}
+
+// GetSequence returns the sequence of the last input of the account, 0 if none
+func (SimpleAccountChecker) GetSequence(store types.KVStore, addr []byte) (int, error) {
+	acc := types.GetAccount(store, addr)
+	if acc == nil {
+		return 0, nil
+	}
+	return acc.Sequence, nil
+}
+
+func (SimpleAccountChecker) SetSequence(store types.KVStore, addr []byte, seq int) error {
+	acc := types.GetAccount(store, addr)
+	if acc == nil {
+		acc = &types.Account{}
+	}
+	acc.Sequence = seq
+	types.SetAccount(store, addr, acc)
+	return nil
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	crypto "github.com/tepleton/go-crypto"
+
 	"github.com/tepleton/basecoin"
 	"github.com/tepleton/basecoin/errors"
 	"github.com/tepleton/basecoin/txs"
@@ -16,6 +18,12 @@ type AccountChecker interface {
 	ChangeAmount(store types.KVStore, addr []byte, coins types.Coins) (types.Coins, error)
 }
 
+// SimpleFeeHandler takes the fee from the payer before running the
+// wrapped tx. If the fee has a gas limit, the tx is metered, and
+// the price of all unused gas is refunded in DeliverTx.
+// Running out of gas fails the tx, but the full fee is still charged.
+// Only the changes of the wrapped tx are rolled back, so it must run
+// inside the NonceHandler, or the failed tx could be replayed.
 type SimpleFeeHandler struct {
 	AccountChecker
 	MinFee types.Coins
@@ -28,50 +36,94 @@ func (h SimpleFeeHandler) Next() basecoin.Handler {
 
 var _ basecoin.Handler = SimpleFeeHandler{}
 
-// Yes, I know refactor a bit... really too late already
-
 func (h SimpleFeeHandler) CheckTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
-	feeTx, ok := tx.Unwrap().(*txs.Fee)
-	if !ok {
-		return res, errors.InvalidFormat()
+	feeTx, err := h.payFee(ctx, store, tx)
+	if err != nil {
+		return res, err
 	}
+	return h.Next().CheckTx(ctx, store, feeTx.Next())
+}
 
-	fees := types.Coins{feeTx.Fee}
-	if !fees.IsGTE(h.MinFee) {
-		return res, errors.InsufficientFees()
+func (h SimpleFeeHandler) DeliverTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+	feeTx, err := h.payFee(ctx, store, tx)
+	if err != nil {
+		return res, err
 	}
-
-	if !ctx.IsSignerAddr(feeTx.Payer) {
-		return res, errors.Unauthorized()
+	if feeTx.Gas == 0 {
+		return h.Next().DeliverTx(ctx, store, feeTx.Next())
 	}
 
-	_, err = h.ChangeAmount(store, feeTx.Payer, fees.Negative())
+	// the fee is paid even if the tx runs out of gas, so only
+	// the changes of the wrapped tx are rolled back
+	meter := types.NewGasMeter(feeTx.Gas)
+	cache := types.NewKVCache(store)
+	res, err = h.deliverMetered(ctx.WithGasMeter(meter), cache, feeTx.Next())
 	if err != nil {
+		if errors.Wrap(err).ErrorCode() == errors.CodeTypeOutOfGas {
+			return res, errors.Charged(err)
+		}
 		return res, err
 	}
+	cache.Sync()
 
-	return basecoin.Result{Log: "Valid tx"}, nil
+	// refund the unused gas
+	refund := types.Coins{{feeTx.Fee.Denom, feeTx.Fee.Amount * meter.GasRemaining()}}
+	if refund[0].Amount > 0 {
+		_, err = h.ChangeAmount(store, feeTx.Payer, refund)
+	}
+	return res, err
 }
 
-func (h SimpleFeeHandler) DeliverTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+// payFee checks the fee is sufficient, and takes the most it can cost
+// from the payer, whose owner must have authorized the tx
+func (h SimpleFeeHandler) payFee(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (*txs.Fee, error) {
 	feeTx, ok := tx.Unwrap().(*txs.Fee)
 	if !ok {
-		return res, errors.InvalidFormat()
+		return nil, errors.InvalidFormat()
 	}
 
-	fees := types.Coins{feeTx.Fee}
+	fees := feeTx.MaxFee()
 	if !fees.IsGTE(h.MinFee) {
-		return res, errors.InsufficientFees()
+		return nil, errors.InsufficientFees()
 	}
 
-	if !ctx.IsSignerAddr(feeTx.Payer) {
-		return res, errors.Unauthorized()
+	if !ctx.HasAccountPermission(feeTx.Payer) {
+		return nil, errors.Unauthorized()
 	}
 
-	_, err = h.ChangeAmount(store, feeTx.Payer, fees.Negative())
+	_, err := h.ChangeAmount(store, feeTx.Payer, fees.Negative())
 	if err != nil {
-		return res, err
+		return nil, err
 	}
+	return feeTx, nil
+}
+
+// deliverMetered charges the signatures and all store access to the
+// gas meter, and turns running out of gas into an error
+func (h SimpleFeeHandler) deliverMetered(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(types.OutOfGas); !ok {
+				panic(r)
+			}
+			err = errors.OutOfGas()
+		}
+	}()
+
+	meter := ctx.GasMeter()
+	meter.ConsumeGas(types.GasCostSignature*signatureCount(ctx.GetSigners()), "signature")
+	return h.Next().DeliverTx(ctx, types.NewGasKVStore(meter, store), tx)
+}
 
-	return h.Next().DeliverTx(ctx, store, feeTx.Next())
+// signatureCount is the number of signatures verified for the signers.
+// A ThresholdKey counts all its keys, as any of them may have signed.
+func signatureCount(signers []crypto.PubKey) (count int64) {
+	for _, pk := range signers {
+		if tk, ok := pk.Unwrap().(txs.ThresholdKey); ok {
+			count += int64(len(tk.Keys))
+		} else {
+			count++
+		}
+	}
+	return count
 }
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	crypto "github.com/tepleton/go-crypto"
+	wrsp "github.com/tepleton/wrsp/types"
+
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/errors"
+	"github.com/tepleton/basecoin/txs"
+	"github.com/tepleton/basecoin/types"
+)
+
+// writeHandler writes the raw bytes to the store
+type writeHandler struct{}
+
+var _ basecoin.Handler = writeHandler{}
+
+func (h writeHandler) CheckTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (basecoin.Result, error) {
+	return h.DeliverTx(ctx, store, tx)
+}
+
+func (writeHandler) DeliverTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+	raw, ok := tx.Unwrap().(txs.Raw)
+	if !ok {
+		return res, errors.InvalidFormat()
+	}
+	store.Set([]byte("data"), raw.Bytes)
+	return basecoin.Result{Log: "ok"}, nil
+}
+
+func TestFeeGas(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+
+	accts := SimpleAccountChecker{}
+	h := SimpleFeeHandler{
+		AccountChecker: accts,
+		MinFee:         types.Coins{{"atom", 1}},
+		Inner:          writeHandler{},
+	}
+	payer := types.PrivAccountFromSecret("payer").Account.PubKey
+	addr := payer.Address()
+	ctx := signedCtx(payer)
+	price := types.Coin{"atom", 2}
+
+	// signature and writing 10 bytes
+	raw := txs.NewRaw(make([]byte, 10)).Wrap()
+	gasUsed := types.GasCostSignature + types.GasCostWrite + 10*types.GasCostWritePerByte
+
+	cases := []struct {
+		tx   basecoin.Tx
+		code wrsp.CodeType
+		paid int64
+	}{
+		// flat fee
+		{txs.NewFee(raw, types.Coin{"atom", 7}, addr).Wrap(), wrsp.CodeType_OK, 7},
+		// refund all but the gas used
+		{txs.NewGasFee(raw, price, 1000, addr).Wrap(), wrsp.CodeType_OK, 2 * gasUsed},
+		{txs.NewGasFee(raw, price, gasUsed, addr).Wrap(), wrsp.CodeType_OK, 2 * gasUsed},
+		// out of gas fails the tx, but still pays the full fee
+		{txs.NewGasFee(raw, price, gasUsed-1, addr).Wrap(), errors.CodeTypeOutOfGas, 2 * (gasUsed - 1)},
+		// max fee must cover the balance
+		{txs.NewGasFee(raw, price, 100000, addr).Wrap(), wrsp.CodeType_BaseInsufficientFunds, 0},
+	}
+
+	for idx, tc := range cases {
+		i := strconv.Itoa(idx)
+		store := types.NewMemKVStore()
+		_, err := accts.ChangeAmount(store, addr, types.Coins{{"atom", 5000}})
+		require.Nil(err, i)
+
+		// like the app, only keep changes on success or a charged failure
+		cache := types.NewKVCache(store)
+		_, err = h.DeliverTx(ctx, cache, tc.tx)
+		assert.Equal(tc.code, errCode(err), i)
+		if err == nil || errors.IsCharged(err) {
+			cache.Sync()
+		}
+
+		bal, err := accts.GetAmount(store, addr)
+		require.Nil(err, i)
+		assert.Equal(types.Coins{{"atom", 5000 - tc.paid}}, bal, i)
+	}
+}
+
+func TestFeeThresholdPayer(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+
+	accts := SimpleAccountChecker{}
+	h := SignedHandler{Inner: SimpleFeeHandler{
+		AccountChecker: accts,
+		MinFee:         types.Coins{{"atom", 1}},
+		Inner:          writeHandler{},
+	}}
+	var privs []types.PrivAccount
+	var pubs []crypto.PubKey
+	for _, secret := range []string{"one", "two", "three"} {
+		priv := types.PrivAccountFromSecret(secret)
+		privs = append(privs, priv)
+		pubs = append(pubs, priv.Account.PubKey)
+	}
+	payer := txs.NewThresholdKey(2, pubs...)
+	addr := payer.Address()
+	price := types.Coin{"atom", 2}
+
+	// every key of the payer is charged, and writing 10 bytes
+	raw := txs.NewRaw(make([]byte, 10)).Wrap()
+	gasUsed := 3*types.GasCostSignature + types.GasCostWrite + 10*types.GasCostWritePerByte
+
+	cases := []struct {
+		tx      basecoin.Tx
+		signers []types.PrivAccount
+		code    wrsp.CodeType
+		paid    int64
+	}{
+		{txs.NewFee(raw, types.Coin{"atom", 7}, addr).Wrap(), privs[:2], wrsp.CodeType_OK, 7},
+		{txs.NewFee(raw, types.Coin{"atom", 7}, addr).Wrap(), privs, wrsp.CodeType_OK, 7},
+		{txs.NewGasFee(raw, price, 1000, addr).Wrap(), privs[1:], wrsp.CodeType_OK, 2 * gasUsed},
+		// one of two is not enough
+		{txs.NewFee(raw, types.Coin{"atom", 7}, addr).Wrap(), privs[2:], wrsp.CodeType_Unauthorized, 0},
+		// the keys can't pay for someone else
+		{txs.NewFee(raw, types.Coin{"atom", 7}, pubs[0].Address()).Wrap(), privs, wrsp.CodeType_Unauthorized, 0},
+	}
+
+	for idx, tc := range cases {
+		i := strconv.Itoa(idx)
+		store := types.NewMemKVStore()
+		_, err := accts.ChangeAmount(store, addr, types.Coins{{"atom", 5000}})
+		require.Nil(err, i)
+
+		stx := txs.NewThresholdSig(tc.tx, payer)
+		for _, priv := range tc.signers {
+			err = stx.Sign(priv.Account.PubKey, priv.Sign(stx.SignBytes()))
+			require.Nil(err, i)
+		}
+
+		cache := types.NewKVCache(store)
+		_, err = h.DeliverTx(signedCtx(), cache, stx.Wrap())
+		assert.Equal(tc.code, errCode(err), i)
+		if err == nil {
+			cache.Sync()
+		}
+
+		bal, err := accts.GetAmount(store, addr)
+		require.Nil(err, i)
+		assert.Equal(types.Coins{{"atom", 5000 - tc.paid}}, bal, i)
+	}
+}
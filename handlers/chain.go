@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/errors"
+	"github.com/tepleton/basecoin/txs"
+	"github.com/tepleton/basecoin/types"
+)
+
+// ChainHandler only lets through txs wrapped in txs.Chain with the
+// chain id of this chain, which have not expired yet
+type ChainHandler struct {
+	Inner basecoin.Handler
+}
+
+func (h ChainHandler) Next() basecoin.Handler {
+	return h.Inner
+}
+
+var _ basecoin.Handler = ChainHandler{}
+
+func (h ChainHandler) CheckTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+	stx, err := checkChain(ctx, tx)
+	if err != nil {
+		return res, err
+	}
+	return h.Next().CheckTx(ctx, store, stx)
+}
+
+func (h ChainHandler) DeliverTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+	stx, err := checkChain(ctx, tx)
+	if err != nil {
+		return res, err
+	}
+	return h.Next().DeliverTx(ctx, store, stx)
+}
+
+// checkChain makes sure the tx is valid on this chain at this height,
+// and returns the wrapped tx
+func checkChain(ctx basecoin.Context, tx basecoin.Tx) (basecoin.Tx, error) {
+	chainTx, ok := tx.Unwrap().(*txs.Chain)
+	if !ok {
+		return tx, errors.InvalidFormat()
+	}
+	if chainTx.ChainID != ctx.ChainID() {
+		return tx, errors.WrongChain()
+	}
+	if chainTx.ExpiresAt != 0 && ctx.BlockHeight() > chainTx.ExpiresAt {
+		return tx, errors.Expired()
+	}
+	return chainTx.Tx, nil
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	crypto "github.com/tepleton/go-crypto"
+	"github.com/tepleton/tmlibs/log"
+	wrsp "github.com/tepleton/wrsp/types"
+
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/errors"
+	"github.com/tepleton/basecoin/txs"
+	"github.com/tepleton/basecoin/types"
+)
+
+// okHandler accepts every tx
+type okHandler struct{}
+
+var _ basecoin.Handler = okHandler{}
+
+func (okHandler) CheckTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (basecoin.Result, error) {
+	return basecoin.Result{Log: "ok"}, nil
+}
+
+func (okHandler) DeliverTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (basecoin.Result, error) {
+	return basecoin.Result{Log: "ok"}, nil
+}
+
+func TestChain(t *testing.T) {
+	assert := assert.New(t)
+
+	store := types.NewMemKVStore()
+	h := ChainHandler{Inner: okHandler{}}
+	raw := txs.NewRaw([]byte{1, 2, 3}).Wrap()
+
+	expiring := func(height uint64) basecoin.Tx {
+		tx := txs.NewChain(raw, "my-chain")
+		tx.ExpiresAt = height
+		return tx.Wrap()
+	}
+
+	cases := []struct {
+		tx     basecoin.Tx
+		height uint64
+		code   wrsp.CodeType
+	}{
+		{txs.NewChain(raw, "my-chain").Wrap(), 10, wrsp.CodeType_OK},
+		{txs.NewChain(raw, "other-chain").Wrap(), 10, errors.CodeTypeWrongChain},
+		{raw, 10, wrsp.CodeType_BaseInvalidInput},
+		{expiring(10), 10, wrsp.CodeType_OK},
+		{expiring(10), 11, errors.CodeTypeExpired},
+	}
+
+	for idx, tc := range cases {
+		i := strconv.Itoa(idx)
+		ctx := basecoin.NewContext("my-chain", tc.height, 0, log.NewNopLogger())
+
+		_, err := h.CheckTx(ctx, store, tc.tx)
+		assert.Equal(tc.code, errCode(err), i)
+		_, err = h.DeliverTx(ctx, store, tc.tx)
+		assert.Equal(tc.code, errCode(err), i)
+	}
+
+	// chain id is required
+	assert.NotNil(txs.NewChain(raw, "").ValidateBasic())
+}
+
+func errCode(err error) wrsp.CodeType {
+	if err == nil {
+		return wrsp.CodeType_OK
+	}
+	return errors.Wrap(err).ErrorCode()
+}
+
+// signedCtx is a context of the app, signed by the keys
+func signedCtx(keys ...crypto.PubKey) basecoin.Context {
+	ctx, err := basecoin.NewContext("", 0, 0, nil).AddSigners(keys...)
+	if err != nil {
+		panic(err)
+	}
+	return ctx
+}
+
+// permsCtx is a context of the app, authorized by the actors
+func permsCtx(actors ...basecoin.Actor) basecoin.Context {
+	ctx, err := basecoin.NewContext("", 0, 0, nil).WithPermissions(actors...)
+	if err != nil {
+		panic(err)
+	}
+	return ctx
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/errors"
+	"github.com/tepleton/basecoin/txs"
+	"github.com/tepleton/basecoin/types"
+)
+
+// CoinAccountChecker is an AccountChecker that also keeps the sequence
+// of every account, so the inputs of a SendTx can't be replayed
+type CoinAccountChecker interface {
+	AccountChecker
+
+	// GetSequence returns the sequence of the last input of the account
+	GetSequence(store types.KVStore, addr []byte) (int, error)
+	// SetSequence stores the sequence of the account
+	SetSequence(store types.KVStore, addr []byte, seq int) error
+}
+
+// CoinHandler moves coins for a txs.SendTx. Every input must be authorized
+// by the owner of the account and have its next sequence, and the
+// inputs must add up to the outputs for every denom. Either all balances
+// change or none.
+//
+// It must run after SignedHandler. CheckTx changes the balances as
+// well, so it should get a store only used for the mempool.
+type CoinHandler struct {
+	CoinAccountChecker
+}
+
+var _ basecoin.Handler = CoinHandler{}
+
+func (h CoinHandler) CheckTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+	return h.sendCoins(ctx, store, tx)
+}
+
+func (h CoinHandler) DeliverTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+	return h.sendCoins(ctx, store, tx)
+}
+
+func (h CoinHandler) sendCoins(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+	send, ok := tx.Unwrap().(txs.SendTx)
+	if !ok {
+		return res, errors.InvalidFormat()
+	}
+	err = send.ValidateBasic()
+	if err != nil {
+		return res, err
+	}
+
+	var in, out types.Coins
+	for _, input := range send.Inputs {
+		if !ctx.HasAccountPermission(input.Address) {
+			return res, errors.Unauthorized()
+		}
+		in = in.Plus(input.Coins)
+	}
+	for _, output := range send.Outputs {
+		out = out.Plus(output.Coins)
+	}
+	if !in.IsEqual(out) {
+		return res, errors.InvalidCoins()
+	}
+
+	// only write once all inputs and outputs are valid
+	cache := types.NewKVCache(store)
+	for _, input := range send.Inputs {
+		err = h.takeInput(cache, input)
+		if err != nil {
+			return res, err
+		}
+	}
+	for _, output := range send.Outputs {
+		_, err = h.ChangeAmount(cache, output.Address, output.Coins)
+		if err != nil {
+			return res, err
+		}
+	}
+	cache.Sync()
+	return res, nil
+}
+
+// takeInput checks the sequence of the input, and takes the coins
+// from the account
+func (h CoinHandler) takeInput(store types.KVStore, input txs.TxInput) error {
+	seq, err := h.GetSequence(store, input.Address)
+	if err != nil {
+		return err
+	}
+	if seq+1 != input.Sequence {
+		return errors.InvalidSequence()
+	}
+	err = h.SetSequence(store, input.Address, input.Sequence)
+	if err != nil {
+		return err
+	}
+	_, err = h.ChangeAmount(store, input.Address, input.Coins.Negative())
+	return err
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	crypto "github.com/tepleton/go-crypto"
+	wrsp "github.com/tepleton/wrsp/types"
+
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/txs"
+	"github.com/tepleton/basecoin/types"
+)
+
+func TestCoinHandler(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+
+	accts := SimpleAccountChecker{}
+	h := CoinHandler{accts}
+
+	a := types.PrivAccountFromSecret("a").Account.PubKey
+	b := types.PrivAccountFromSecret("b").Account.PubKey
+	c := types.PrivAccountFromSecret("c").Account.PubKey
+	atom := func(amount int64) types.Coins { return types.Coins{{"atom", amount}} }
+	in := func(pk crypto.PubKey, coins types.Coins, seq int) txs.TxInput {
+		return txs.NewTxInput(pk.Address(), coins, seq)
+	}
+	out := func(pk crypto.PubKey, coins types.Coins) txs.TxOutput {
+		return txs.NewTxOutput(pk.Address(), coins)
+	}
+	send := func(ins []txs.TxInput, outs []txs.TxOutput) basecoin.Tx {
+		return txs.SendTx{Inputs: ins, Outputs: outs}.Wrap()
+	}
+	initial := map[crypto.PubKey]types.Coins{
+		a: types.Coins{{"atom", 100}, {"eth", 50}},
+		b: atom(20),
+	}
+
+	cases := []struct {
+		tx      basecoin.Tx
+		signers []crypto.PubKey
+		code    wrsp.CodeType
+		// balances after the tx, all unchanged on error
+		balances map[crypto.PubKey]types.Coins
+	}{
+		{
+			txs.NewSendTx(in(a, atom(30), 1), out(c, atom(30))).Wrap(),
+			[]crypto.PubKey{a},
+			wrsp.CodeType_OK,
+			map[crypto.PubKey]types.Coins{a: {{"atom", 70}, {"eth", 50}}, b: atom(20), c: atom(30)},
+		},
+		// many inputs, many outputs, many denoms
+		{
+			send(
+				[]txs.TxInput{in(a, types.Coins{{"atom", 10}, {"eth", 5}}, 1), in(b, atom(20), 1)},
+				[]txs.TxOutput{out(c, types.Coins{{"atom", 25}, {"eth", 5}}), out(a, atom(5))},
+			),
+			[]crypto.PubKey{b, a},
+			wrsp.CodeType_OK,
+			map[crypto.PubKey]types.Coins{a: {{"atom", 95}, {"eth", 45}}, b: nil, c: {{"atom", 25}, {"eth", 5}}},
+		},
+		// every input must sign
+		{
+			txs.NewSendTx(in(a, atom(30), 1), out(c, atom(30))).Wrap(),
+			[]crypto.PubKey{b},
+			wrsp.CodeType_Unauthorized,
+			initial,
+		},
+		{
+			send([]txs.TxInput{in(a, atom(10), 1), in(b, atom(10), 1)}, []txs.TxOutput{out(c, atom(20))}),
+			[]crypto.PubKey{a},
+			wrsp.CodeType_Unauthorized,
+			initial,
+		},
+		// next sequence only
+		{
+			txs.NewSendTx(in(a, atom(30), 2), out(c, atom(30))).Wrap(),
+			[]crypto.PubKey{a},
+			wrsp.CodeType_BaseInvalidInput,
+			initial,
+		},
+		// inputs must match outputs
+		{
+			txs.NewSendTx(in(a, atom(30), 1), out(c, atom(20))).Wrap(),
+			[]crypto.PubKey{a},
+			wrsp.CodeType_BaseInvalidInput,
+			initial,
+		},
+		{
+			txs.NewSendTx(in(a, atom(30), 1), out(c, types.Coins{{"eth", 30}})).Wrap(),
+			[]crypto.PubKey{a},
+			wrsp.CodeType_BaseInvalidInput,
+			initial,
+		},
+		// nothing moves if any input is short
+		{
+			send([]txs.TxInput{in(a, atom(10), 1), in(b, atom(30), 1)}, []txs.TxOutput{out(c, atom(40))}),
+			[]crypto.PubKey{a, b},
+			wrsp.CodeType_BaseInsufficientFunds,
+			initial,
+		},
+		// only SendTx
+		{
+			txs.NewRaw([]byte("foo")).Wrap(),
+			[]crypto.PubKey{a},
+			wrsp.CodeType_BaseInvalidInput,
+			initial,
+		},
+	}
+
+	for idx, tc := range cases {
+		i := strconv.Itoa(idx)
+		ctx := signedCtx(tc.signers...)
+
+		for _, check := range []bool{true, false} {
+			store := types.NewMemKVStore()
+			for pk, coins := range initial {
+				_, err := accts.ChangeAmount(store, pk.Address(), coins)
+				require.Nil(err, i)
+			}
+
+			var err error
+			if check {
+				_, err = h.CheckTx(ctx, store, tc.tx)
+			} else {
+				_, err = h.DeliverTx(ctx, store, tc.tx)
+			}
+			assert.Equal(tc.code, errCode(err), i)
+
+			for pk, coins := range tc.balances {
+				bal, err := accts.GetAmount(store, pk.Address())
+				require.Nil(err, i)
+				assert.True(coins.IsEqual(bal), "%d: %v != %v", idx, coins, bal)
+			}
+			// the sequence only moves on success
+			seq, err := accts.GetSequence(store, a.Address())
+			require.Nil(err, i)
+			if tc.code == wrsp.CodeType_OK {
+				assert.Equal(1, seq, i)
+			} else {
+				assert.Equal(0, seq, i)
+			}
+		}
+	}
+
+	// a tx can't be replayed
+	store := types.NewMemKVStore()
+	_, err := accts.ChangeAmount(store, a.Address(), atom(100))
+	require.Nil(err)
+	ctx := signedCtx(a)
+	tx := txs.NewSendTx(in(a, atom(30), 1), out(c, atom(30))).Wrap()
+	_, err = h.DeliverTx(ctx, store, tx)
+	assert.Nil(err, "%+v", err)
+	_, err = h.DeliverTx(ctx, store, tx)
+	assert.Equal(wrsp.CodeType_BaseInvalidInput, errCode(err))
+	tx = txs.NewSendTx(in(a, atom(30), 2), out(c, atom(30))).Wrap()
+	_, err = h.DeliverTx(ctx, store, tx)
+	assert.Nil(err, "%+v", err)
+	bal, err := accts.GetAmount(store, c.Address())
+	require.Nil(err)
+	assert.Equal(atom(60), bal)
+
+	// modules and other chains can own accounts as well
+	pool := basecoin.NewActor("pool", []byte("reserve"))
+	foreign := basecoin.Actor{ChainID: "other-chain", App: basecoin.NameSigs, Address: a.Address()}
+	for _, owner := range []basecoin.Actor{pool, foreign} {
+		store := types.NewMemKVStore()
+		addr := owner.AccountAddress()
+		_, err := accts.ChangeAmount(store, addr, atom(100))
+		require.Nil(err)
+		tx := txs.NewSendTx(txs.NewTxInput(addr, atom(30), 1), out(c, atom(30))).Wrap()
+
+		// the key with the same address can't spend it
+		_, err = h.DeliverTx(signedCtx(a), store, tx)
+		assert.Equal(wrsp.CodeType_Unauthorized, errCode(err), owner.String())
+		_, err = h.DeliverTx(permsCtx(owner), store, tx)
+		assert.Nil(err, "%+v", err)
+		bal, err := accts.GetAmount(store, addr)
+		require.Nil(err)
+		assert.Equal(atom(70), bal)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/tepleton/go-wire"
+
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/errors"
+	"github.com/tepleton/basecoin/txs"
+	"github.com/tepleton/basecoin/types"
+)
+
+// NonceHandler protects against replay of authorized txs.
+// It keeps one sequence per account address of every actor that
+// authorized the tx (a key's own address for signers), and only accepts
+// a txs.Nonce with the next sequence of every actor, and no one else.
+//
+// It must run after SignedHandler, and any middleware granting actors.
+// CheckTx increments the sequence as well, so it should get a store
+// only used for the mempool.
+type NonceHandler struct {
+	Inner basecoin.Handler
+}
+
+func (h NonceHandler) Next() basecoin.Handler {
+	return h.Inner
+}
+
+var _ basecoin.Handler = NonceHandler{}
+
+func (h NonceHandler) CheckTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+	stx, err := checkIncrementNonce(ctx, store, tx)
+	if err != nil {
+		return res, err
+	}
+	return h.Next().CheckTx(ctx, store, stx)
+}
+
+func (h NonceHandler) DeliverTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+	stx, err := checkIncrementNonce(ctx, store, tx)
+	if err != nil {
+		return res, err
+	}
+	return h.Next().DeliverTx(ctx, store, stx)
+}
+
+// checkIncrementNonce verifies the sequence of all actors, and if
+// they are all valid, stores the new sequences and returns the wrapped tx
+func checkIncrementNonce(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (basecoin.Tx, error) {
+	ntx, ok := tx.Unwrap().(*txs.Nonce)
+	if !ok {
+		return tx, errors.InvalidFormat()
+	}
+
+	actors := ctx.GetPermissions()
+	if len(actors) == 0 {
+		return tx, errors.MissingSignature()
+	}
+	missing := make(map[string]bool, len(actors))
+	for _, a := range actors {
+		missing[string(a.AccountAddress())] = true
+	}
+	for _, in := range ntx.Inputs {
+		if !missing[string(in.Address)] {
+			return tx, errors.Unauthorized()
+		}
+		delete(missing, string(in.Address))
+		if GetNonce(store, in.Address)+1 != in.Sequence {
+			return tx, errors.InvalidSequence()
+		}
+	}
+	if len(missing) > 0 {
+		return tx, errors.InvalidSequence()
+	}
+
+	for _, in := range ntx.Inputs {
+		SetNonce(store, in.Address, in.Sequence)
+	}
+	return ntx.Tx, nil
+}
+
+// NonceKey is where the last sequence of the address is stored
+func NonceKey(addr []byte) []byte {
+	return append([]byte("base/n/"), addr...)
+}
+
+// GetNonce returns the last sequence used by this address, 0 if none
+func GetNonce(store types.KVStore, addr []byte) uint64 {
+	data := store.Get(NonceKey(addr))
+	if len(data) == 0 {
+		return 0
+	}
+	var seq uint64
+	err := wire.ReadBinaryBytes(data, &seq)
+	if err != nil {
+		panic(fmt.Sprintf("Error reading nonce %X error: %v",
+			data, err.Error()))
+	}
+	return seq
+}
+
+// SetNonce stores the last sequence used by this address
+func SetNonce(store types.KVStore, addr []byte, seq uint64) {
+	store.Set(NonceKey(addr), wire.BinaryBytes(seq))
+}
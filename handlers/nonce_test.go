@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	wrsp "github.com/tepleton/wrsp/types"
+
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/txs"
+	"github.com/tepleton/basecoin/types"
+)
+
+func TestNonce(t *testing.T) {
+	assert := assert.New(t)
+
+	store := types.NewMemKVStore()
+	h := NonceHandler{Inner: okHandler{}}
+	raw := txs.NewRaw([]byte{1, 2, 3}).Wrap()
+
+	alice := types.PrivAccountFromSecret("alice").Account.PubKey
+	bob := types.PrivAccountFromSecret("bob").Account.PubKey
+	ctxA := signedCtx(alice)
+	ctxB := signedCtx(bob)
+	ctxAB := signedCtx(alice, bob)
+	// an actor from another chain has its own sequence, apart from the key
+	foreign := basecoin.Actor{ChainID: "other-chain", App: basecoin.NameSigs, Address: alice.Address()}
+	ctxF := permsCtx(foreign)
+
+	a, b, f := alice.Address(), bob.Address(), foreign.AccountAddress()
+	in := txs.NewNonceInput
+	nonce := func(ins ...txs.NonceInput) basecoin.Tx { return txs.NewNonce(raw, ins...).Wrap() }
+
+	cases := []struct {
+		ctx  basecoin.Context
+		tx   basecoin.Tx
+		code wrsp.CodeType
+	}{
+		{ctxA, nonce(in(a, 1)), wrsp.CodeType_OK},
+		// replay is rejected
+		{ctxA, nonce(in(a, 1)), wrsp.CodeType_BaseInvalidInput},
+		// no skipping ahead
+		{ctxA, nonce(in(a, 3)), wrsp.CodeType_BaseInvalidInput},
+		{ctxA, nonce(in(a, 2)), wrsp.CodeType_OK},
+		// sequence is per signer
+		{ctxB, nonce(in(b, 1)), wrsp.CodeType_OK},
+		// every signer has its own sequence, and needs one
+		{ctxAB, nonce(in(a, 3), in(b, 2)), wrsp.CodeType_OK},
+		{ctxAB, nonce(in(a, 4)), wrsp.CodeType_BaseInvalidInput},
+		{ctxAB, nonce(in(a, 4), in(a, 4)), wrsp.CodeType_Unauthorized},
+		// and no one else has one
+		{ctxA, nonce(in(b, 3)), wrsp.CodeType_Unauthorized},
+		{ctxA, nonce(in(a, 4), in(b, 3)), wrsp.CodeType_Unauthorized},
+		{ctxF, nonce(in(f, 1)), wrsp.CodeType_OK},
+		{ctxF, nonce(in(f, 1)), wrsp.CodeType_BaseInvalidInput},
+		{basecoin.Context{}, nonce(in(a, 4)), wrsp.CodeType_Unauthorized},
+		{ctxA, raw, wrsp.CodeType_BaseInvalidInput},
+	}
+
+	for idx, tc := range cases {
+		i := strconv.Itoa(idx)
+		_, err := h.DeliverTx(tc.ctx, store, tc.tx)
+		assert.Equal(tc.code, errCode(err), i)
+	}
+	assert.EqualValues(3, GetNonce(store, a))
+	assert.EqualValues(2, GetNonce(store, b))
+	assert.EqualValues(1, GetNonce(store, f))
+
+	// CheckTx works on a mempool view, which can run ahead of the store
+	check := types.NewKVCache(store)
+	_, err := h.CheckTx(ctxA, check, nonce(in(a, 4)))
+	assert.Nil(err)
+	_, err = h.CheckTx(ctxA, check, nonce(in(a, 5)))
+	assert.Nil(err)
+	_, err = h.CheckTx(ctxA, check, nonce(in(a, 5)))
+	assert.NotNil(err)
+	assert.EqualValues(5, GetNonce(check, a))
+	assert.EqualValues(3, GetNonce(store, a))
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/errors"
+	"github.com/tepleton/basecoin/txs"
+	"github.com/tepleton/basecoin/types"
+)
+
+// PluginHandler runs a txs.PluginTx on the plugin it names, so the
+// plugins written for types.AppTx can be routed by the Router.
+// The plugin is called by the one key that signed the tx, and gets no
+// coins, as the middleware takes care of fees.
+//
+// Like for an AppTx, CheckTx only checks that the plugin exists.
+type PluginHandler struct {
+	Plugins *types.Plugins
+}
+
+var _ basecoin.Handler = PluginHandler{}
+
+func (h PluginHandler) CheckTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+	_, _, err = h.getPlugin(ctx, tx)
+	return res, err
+}
+
+func (h PluginHandler) DeliverTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (res basecoin.Result, err error) {
+	ptx, plugin, err := h.getPlugin(ctx, tx)
+	if err != nil {
+		return res, err
+	}
+
+	caller := ctx.GetSigners()[0].Address()
+	acc := types.GetAccount(store, caller)
+	if acc == nil {
+		acc = &types.Account{}
+	}
+	callCtx := types.NewCallContext(caller, acc, types.Coins{})
+	callCtx.GasMeter = ctx.GasMeter()
+	pres := plugin.RunTx(store, callCtx, ptx.Data)
+	if pres.IsErr() {
+		return res, errors.New(pres.Log, pres.Code)
+	}
+	return basecoin.Result{Data: pres.Data, Log: pres.Log}, nil
+}
+
+// getPlugin returns the tx and the plugin it calls, if it has one caller
+func (h PluginHandler) getPlugin(ctx basecoin.Context, tx basecoin.Tx) (ptx txs.PluginTx, plugin types.Plugin, err error) {
+	ptx, ok := tx.Unwrap().(txs.PluginTx)
+	if !ok {
+		return ptx, nil, errors.InvalidFormat()
+	}
+	switch len(ctx.GetSigners()) {
+	case 0:
+		return ptx, nil, errors.MissingSignature()
+	case 1:
+	default:
+		return ptx, nil, errors.TooManySignatures()
+	}
+	plugin = h.Plugins.GetByName(ptx.Name)
+	if plugin == nil {
+		return ptx, nil, errors.UnknownTx(ptx.Name)
+	}
+	return ptx, plugin, nil
+}
+
+// DefaultStack checks the signatures, the chain and the nonce of every
+// tx before it takes the fee, so a tx that runs out of gas still uses up
+// its nonce and can't be replayed. Then it routes txs.SendTx to the
+// CoinHandler, and txs.PluginTx to the plugins.
+func DefaultStack(minFee types.Coins, plugins *types.Plugins) basecoin.Handler {
+	accts := SimpleAccountChecker{}
+	router := NewRouter().
+		AddRoute(txs.TypeSend, CoinHandler{accts}).
+		AddRoute(txs.TypePlugin, PluginHandler{plugins})
+	fees := SimpleFeeHandler{
+		AccountChecker: accts,
+		MinFee:         minFee,
+		Inner:          router,
+	}
+	return SignedHandler{Inner: ChainHandler{NonceHandler{fees}}}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	wrsp "github.com/tepleton/wrsp/types"
+
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/errors"
+	"github.com/tepleton/basecoin/txs"
+	"github.com/tepleton/basecoin/types"
+)
+
+// callerPlugin returns the caller address, and fails on an empty tx
+type callerPlugin struct{}
+
+func (callerPlugin) Name() string { return "caller" }
+
+func (callerPlugin) RunTx(store types.KVStore, ctx types.CallContext, txBytes []byte) wrsp.Result {
+	if len(txBytes) == 0 {
+		return wrsp.ErrBaseEncodingError.AppendLog("Empty tx")
+	}
+	if ctx.CallerAccount == nil {
+		return wrsp.ErrInternalError.AppendLog("No caller account")
+	}
+	return wrsp.NewResultOK(ctx.CallerAddress, "")
+}
+
+func (callerPlugin) SetOption(store types.KVStore, key, value string) string          { return "" }
+func (callerPlugin) InitChain(store types.KVStore, vals []*wrsp.Validator)            {}
+func (callerPlugin) BeginBlock(store types.KVStore, hash []byte, header *wrsp.Header) {}
+func (callerPlugin) EndBlock(store types.KVStore, height uint64) (res wrsp.ResponseEndBlock) {
+	return
+}
+
+func TestPluginHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	plugins := types.NewPlugins()
+	plugins.RegisterPlugin(callerPlugin{})
+	h := PluginHandler{plugins}
+
+	a := types.PrivAccountFromSecret("a").Account.PubKey
+	b := types.PrivAccountFromSecret("b").Account.PubKey
+	call := txs.NewPluginTx("caller", []byte("tx")).Wrap()
+
+	cases := []struct {
+		ctx  basecoin.Context
+		tx   basecoin.Tx
+		code wrsp.CodeType
+	}{
+		{signedCtx(a), call, wrsp.CodeType_OK},
+		// the plugin has one caller
+		{signedCtx(), call, wrsp.CodeType_Unauthorized},
+		{signedCtx(a, b), call, wrsp.CodeType_Unauthorized},
+		// it must exist, and its errors are kept
+		{signedCtx(a), txs.NewPluginTx("unknown", []byte("tx")).Wrap(), errors.CodeTypeUnknownTx},
+		{signedCtx(a), txs.NewPluginTx("caller", nil).Wrap(), wrsp.ErrBaseEncodingError.Code},
+		{signedCtx(a), txs.NewRaw([]byte("tx")).Wrap(), wrsp.CodeType_BaseInvalidInput},
+	}
+
+	for idx, tc := range cases {
+		i := strconv.Itoa(idx)
+		store := types.NewMemKVStore()
+		res, err := h.DeliverTx(tc.ctx, store, tc.tx)
+		assert.Equal(tc.code, errCode(err), i)
+		if err == nil {
+			assert.EqualValues(a.Address(), res.Data, i)
+		}
+	}
+
+	// CheckTx doesn't run the plugin
+	_, err := h.CheckTx(signedCtx(a), types.NewMemKVStore(), txs.NewPluginTx("caller", nil).Wrap())
+	assert.Nil(err)
+	_, err = h.CheckTx(signedCtx(a), types.NewMemKVStore(), txs.NewPluginTx("unknown", nil).Wrap())
+	assert.Equal(errors.CodeTypeUnknownTx, errCode(err))
+}
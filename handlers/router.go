@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/errors"
+	"github.com/tepleton/basecoin/txs"
+	"github.com/tepleton/basecoin/types"
+)
+
+// Router sends every tx to the handler registered for its type name
+// in basecoin.TxMapper. It goes at the bottom of the stack, once all
+// middleware (signatures, fees, nonces...) has been unwrapped.
+//
+// Every handler gets the context scoped to its type name, so it can't
+// add signers. A txs.MultiTx is handled by the router itself: each of
+// its txs is routed in turn, and their changes are only kept if all of
+// them succeed.
+type Router struct {
+	routes map[string]basecoin.Handler
+}
+
+var _ basecoin.Handler = Router{}
+
+func NewRouter() Router {
+	return Router{routes: make(map[string]basecoin.Handler)}
+}
+
+// AddRoute sends all txs of the type name to h.
+// It panics if name already has a route, is the MultiTx type, or is
+// reserved for the signers, so no module can grant their actors.
+func (r Router) AddRoute(name string, h basecoin.Handler) Router {
+	if name == txs.TypeMulti {
+		panic("MultiTx is routed by the router itself")
+	}
+	if name == basecoin.NameSigs {
+		panic(fmt.Sprintf("%s is reserved for the signers", name))
+	}
+	if _, ok := r.routes[name]; ok {
+		panic(fmt.Sprintf("Route for %s already registered", name))
+	}
+	r.routes[name] = h
+	return r
+}
+
+func (r Router) CheckTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (basecoin.Result, error) {
+	return r.route(ctx, store, tx, true)
+}
+
+func (r Router) DeliverTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (basecoin.Result, error) {
+	return r.route(ctx, store, tx, false)
+}
+
+func (r Router) route(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx, isCheckTx bool) (res basecoin.Result, err error) {
+	if mtx, ok := tx.Unwrap().(*txs.MultiTx); ok {
+		return r.routeMulti(ctx, store, mtx, isCheckTx)
+	}
+
+	name := tx.TypeName()
+	h, ok := r.routes[name]
+	if !ok {
+		if name == "" {
+			name = fmt.Sprintf("%T", tx.Unwrap())
+		}
+		return res, errors.UnknownTx(name)
+	}
+	ctx, err = ctx.WithModule(name)
+	if err != nil {
+		return res, err
+	}
+	if isCheckTx {
+		return h.CheckTx(ctx, store, tx)
+	}
+	return h.DeliverTx(ctx, store, tx)
+}
+
+// routeMulti runs all txs atomically, so the store is left untouched
+// unless they all passed. The result holds the data of the last tx,
+// and the logs of all of them.
+func (r Router) routeMulti(ctx basecoin.Context, store types.KVStore, mtx *txs.MultiTx, isCheckTx bool) (res basecoin.Result, err error) {
+	if len(mtx.Txs) == 0 {
+		return res, errors.InvalidFormat()
+	}
+
+	logs := make([]string, len(mtx.Txs))
+	err = types.Atomic(store, func(store types.KVStore) error {
+		for i, tx := range mtx.Txs {
+			res, err = r.route(ctx, store, tx, isCheckTx)
+			if err != nil {
+				return err
+			}
+			logs[i] = res.Log
+		}
+		return nil
+	})
+	if err != nil {
+		return basecoin.Result{}, err
+	}
+
+	res.Log = strings.Join(logs, "\n")
+	return res, nil
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	wrsp "github.com/tepleton/wrsp/types"
+
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/errors"
+	"github.com/tepleton/basecoin/txs"
+	"github.com/tepleton/basecoin/types"
+)
+
+// moduleHandler returns the module of the context it gets
+type moduleHandler struct{}
+
+var _ basecoin.Handler = moduleHandler{}
+
+func (h moduleHandler) CheckTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (basecoin.Result, error) {
+	return h.DeliverTx(ctx, store, tx)
+}
+
+func (moduleHandler) DeliverTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (basecoin.Result, error) {
+	return basecoin.Result{Log: ctx.Module()}, nil
+}
+
+func TestRouter(t *testing.T) {
+	assert := assert.New(t)
+
+	// raw txs are written, chain txs fail in the writeHandler
+	r := NewRouter().
+		AddRoute(txs.TypeRaw, writeHandler{}).
+		AddRoute(txs.TypeChain, writeHandler{}).
+		AddRoute(txs.TypeFees, okHandler{})
+	assert.Panics(func() { r.AddRoute(txs.TypeRaw, okHandler{}) })
+	assert.Panics(func() { r.AddRoute(txs.TypeMulti, okHandler{}) })
+	assert.Panics(func() { r.AddRoute(basecoin.NameSigs, okHandler{}) })
+
+	raw := func(d string) basecoin.Tx { return txs.NewRaw([]byte(d)).Wrap() }
+	chain := txs.NewChain(raw("chain"), "my-chain").Wrap()
+	fee := txs.NewFee(raw("fee"), types.Coin{"atom", 1}, []byte("payer")).Wrap()
+	nonce := txs.NewNonce(raw("nonce"), txs.NewNonceInput([]byte("addr"), 1)).Wrap()
+	multi := func(tx ...basecoin.Tx) basecoin.Tx { return txs.NewMultiTx(tx...).Wrap() }
+
+	cases := []struct {
+		tx   basecoin.Tx
+		code wrsp.CodeType
+		data string
+	}{
+		{raw("foo"), wrsp.CodeType_OK, "foo"},
+		{fee, wrsp.CodeType_OK, ""},
+		{chain, wrsp.CodeType_BaseInvalidInput, ""},
+		// no route
+		{nonce, errors.CodeTypeUnknownTx, ""},
+		// all sub txs go through the router
+		{multi(raw("one"), fee, raw("two")), wrsp.CodeType_OK, "two"},
+		{multi(raw("one"), multi(raw("two"), raw("three"))), wrsp.CodeType_OK, "three"},
+		// and nothing is written if any fails
+		{multi(raw("one"), chain), wrsp.CodeType_BaseInvalidInput, ""},
+		{multi(raw("one"), nonce), errors.CodeTypeUnknownTx, ""},
+		{multi(raw("one"), multi(raw("two"), chain)), wrsp.CodeType_BaseInvalidInput, ""},
+		{multi(), wrsp.CodeType_BaseInvalidInput, ""},
+	}
+
+	for idx, tc := range cases {
+		i := strconv.Itoa(idx)
+
+		for _, check := range []bool{true, false} {
+			store := types.NewMemKVStore()
+			var err error
+			if check {
+				_, err = r.CheckTx(basecoin.Context{}, store, tc.tx)
+			} else {
+				_, err = r.DeliverTx(basecoin.Context{}, store, tc.tx)
+			}
+			assert.Equal(tc.code, errCode(err), i)
+			assert.Equal(tc.data, string(store.Get([]byte("data"))), i)
+		}
+	}
+
+	// logs of all txs are kept
+	res, err := r.DeliverTx(basecoin.Context{}, types.NewMemKVStore(), multi(raw("one"), fee))
+	assert.Nil(err, "%+v", err)
+	assert.Equal("ok\nok", res.Log)
+
+	// every handler runs in the scope of its type
+	r = NewRouter().AddRoute(txs.TypeRaw, moduleHandler{})
+	res, err = r.CheckTx(basecoin.Context{}, types.NewMemKVStore(), raw("foo"))
+	assert.Nil(err, "%+v", err)
+	assert.Equal(txs.TypeRaw, res.Log)
+}
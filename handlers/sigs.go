@@ -38,7 +38,10 @@ func (h SignedHandler) CheckTx(ctx basecoin.Context, store types.KVStore, tx bas
 	}
 
 	// add the signers to the context and continue
-	ctx2 := ctx.AddSigners(sigs...)
+	ctx2, err := ctx.AddSigners(sigs...)
+	if err != nil {
+		return res, err
+	}
 	return h.Next().CheckTx(ctx2, store, stx.Next())
 }
 
@@ -56,6 +59,9 @@ func (h SignedHandler) DeliverTx(ctx basecoin.Context, store types.KVStore, tx b
 	}
 
 	// add the signers to the context and continue
-	ctx2 := ctx.AddSigners(sigs...)
+	ctx2, err := ctx.AddSigners(sigs...)
+	if err != nil {
+		return res, err
+	}
 	return h.Next().DeliverTx(ctx2, store, stx.Next())
 }
@@ -1,6 +1,7 @@
 package counter
 
 import (
+	"encoding/json"
 	"fmt"
 
 	wrsp "github.com/tepleton/wrsp/types"
@@ -39,6 +40,35 @@ func (cp *CounterPlugin) SetOption(store types.KVStore, key, value string) (log
 	return ""
 }
 
+var _ types.GenesisPlugin = (*CounterPlugin)(nil)
+
+// InitGenesis sets the CounterPluginState from its json
+func (cp *CounterPlugin) InitGenesis(store types.KVStore, genesis json.RawMessage) error {
+	var cpState CounterPluginState
+	err := json.Unmarshal(genesis, &cpState)
+	if err != nil {
+		return err
+	}
+	if !cpState.TotalFees.IsValid() {
+		return fmt.Errorf("invalid TotalFees %v", cpState.TotalFees)
+	}
+	store.Set(cp.StateKey(), wire.BinaryBytes(cpState))
+	return nil
+}
+
+// ExportGenesis returns the CounterPluginState as json
+func (cp *CounterPlugin) ExportGenesis(store types.KVStore) (json.RawMessage, error) {
+	var cpState CounterPluginState
+	cpStateBytes := store.Get(cp.StateKey())
+	if len(cpStateBytes) > 0 {
+		err := wire.ReadBinaryBytes(cpStateBytes, &cpState)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(cpState)
+}
+
 func (cp *CounterPlugin) RunTx(store types.KVStore, ctx types.CallContext, txBytes []byte) (res wrsp.Result) {
 	// Decode tx
 	var tx CounterTx
@@ -100,3 +100,31 @@ func TestCounterPlugin(t *testing.T) {
 	// REF: DeliverCounterTx(gas, fee, inputCoins, inputSequence, appFee) {w
 
 }
+
+func TestCounterGenesis(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+
+	store := types.NewMemKVStore()
+	cp := New()
+
+	// empty state exports fine
+	genesis, err := cp.ExportGenesis(store)
+	require.Nil(err, "%+v", err)
+
+	err = cp.InitGenesis(store, json.RawMessage(`{"Counter": 5, "TotalFees": [{"denom": "gold", "amount": 12}]}`))
+	require.Nil(err, "%+v", err)
+	genesis, err = cp.ExportGenesis(store)
+	require.Nil(err, "%+v", err)
+
+	var cpState CounterPluginState
+	err = json.Unmarshal(genesis, &cpState)
+	require.Nil(err, "%+v", err)
+	assert.Equal(5, cpState.Counter)
+	assert.Equal(types.Coins{{"gold", 12}}, cpState.TotalFees)
+
+	// bad json or coins are rejected
+	err = cp.InitGenesis(store, json.RawMessage(`{"Counter": "five"}`))
+	assert.NotNil(err)
+	err = cp.InitGenesis(store, json.RawMessage(`{"TotalFees": [{"denom": "gold", "amount": 0}]}`))
+	assert.NotNil(err)
+}
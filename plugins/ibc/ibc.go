@@ -2,6 +2,7 @@ package ibc
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,9 +12,12 @@ import (
 
 	wrsp "github.com/tepleton/wrsp/types"
 	"github.com/tepleton/go-wire"
+	"github.com/tepleton/go-wire/data"
 	merkle "github.com/tepleton/merkleeyes/iavl"
 	cmn "github.com/tepleton/tmlibs/common"
+	"github.com/tepleton/tmlibs/log"
 
+	"github.com/tepleton/basecoin"
 	"github.com/tepleton/basecoin/types"
 	tm "github.com/tepleton/tepleton/types"
 )
@@ -27,16 +31,39 @@ const (
 	_HEADER     = "header"
 	_EGRESS     = "egress"
 	_INGRESS    = "ingress"
+	_ACK        = "ack"
+	_RECEIPT    = "receipt"
+	_ESCROW     = "escrow"
+	_HEIGHT     = "height"
 	_CONNECTION = "connection"
+	_LOCKED     = "locked"
+	_LIST       = "list"
+	_PRUNING    = "pruning"
+	_PRUNED     = "pruned"
+	_ADMIN      = "admin"
+	_ROUTE      = "route"
+
+	// VoucherPrefix starts the denom of coins that came from another chain
+	VoucherPrefix = "ibc/"
 )
 
 type IBCPluginState struct {
+	// @[:ibc, :blockchain, :list] <~ []string # ids of all registered chains
 	// @[:ibc, :blockchain, :genesis, ChainID] <~ BlockchainGenesis
 	// @[:ibc, :blockchain, :state, ChainID] <~ BlockchainState
 	// @[:ibc, :blockchain, :header, ChainID, Height] <~ tm.Header
 	// @[:ibc, :egress, Src, Dst, Sequence] <~ Packet
 	// @[:ibc, :ingress, Dst, Src, Sequence] <~ Packet
-	// @[:ibc, :connection, Src, Dst] <~ Connection # TODO - keep connection state
+	// @[:ibc, :ack, Src, Dst, Sequence] <~ Acknowledgement (on Dst)
+	// @[:ibc, :escrow, Src, Dst, Sequence] <~ Escrow (on Src, until acknowledged)
+	// @[:ibc, :receipt, Src, Dst, Sequence] <~ Acknowledgement (on Src)
+	// @[:ibc, :height] <~ uint64 # height of the current block
+	// @[:ibc, :connection, Src, Dst] <~ Connection
+	// @[:ibc, :locked, Dst] <~ types.Coins # our coins, that Dst holds vouchers for
+	// @[:ibc, :pruning] <~ Pruning
+	// @[:ibc, :pruned, Counterparty] <~ PrunedPackets
+	// @[:ibc, :admin] <~ []byte # the address that opens and closes connections
+	// @[:ibc, :route, Plugin] <~ string # the name of a plugin other chains may call
 }
 
 type BlockchainGenesis struct {
@@ -49,14 +76,69 @@ type BlockchainState struct {
 	Validators      []*tm.Validator
 	LastBlockHash   []byte
 	LastBlockHeight uint64
+	Frozen          bool   // after misbehaviour, nothing from the chain is accepted
+	PrunedHeight    uint64 // headers below this height were pruned
 }
 
 type Packet struct {
+	SrcChainID    string
+	DstChainID    string
+	Sequence      uint64
+	Type          string // redundant now that Type() is a method on Payload ?
+	Payload       Payload
+	TimeoutHeight uint64 // the packet is rejected from this height on Dst, 0 for never
+}
+
+// Acknowledgement is written by the Dst chain for every packet it receives,
+// and relayed back to the Src chain, which refunds the escrow on failure.
+type Acknowledgement struct {
 	SrcChainID string
 	DstChainID string
 	Sequence   uint64
-	Type       string // redundant now that Type() is a method on Payload ?
-	Payload    Payload
+	Success    bool
+	Log        string // why the packet was rejected
+	Data       []byte // the result of a PluginPayload
+}
+
+// Connection states, in the order of the handshake
+const (
+	ConnectionInit    = "INIT"
+	ConnectionTryOpen = "TRYOPEN"
+	ConnectionOpen    = "OPEN"
+	ConnectionClosed  = "CLOSED"
+)
+
+// Connection is one end of the ordered channel between ChainID and the
+// Counterparty. It is opened with a handshake of four txs:
+//
+//	ChainID:      IBCConnectionInitTx                  -> INIT
+//	Counterparty: IBCConnectionTryTx (proves INIT)     -> TRYOPEN
+//	ChainID:      IBCConnectionAckTx (proves TRYOPEN)  -> OPEN
+//	Counterparty: IBCConnectionConfirmTx (proves OPEN) -> OPEN
+//
+// Only the admin of each chain sends IBCConnectionInitTx and
+// IBCConnectionTryTx, the proofs that follow can be relayed by anyone.
+//
+// Packets from the Counterparty are only accepted while it is open, and
+// strictly in order of their sequence. The admin can close it with
+// IBCConnectionCloseTx, and the Counterparty follows with
+// IBCConnectionCloseConfirmTx. A closed connection is never reopened.
+// Once a chain has proof that the Counterparty closed, it refunds the
+// escrow of all packets the Counterparty never received, so the chain
+// that closed first gets an IBCConnectionCloseConfirmTx as well.
+type Connection struct {
+	ChainID      string
+	Counterparty string
+	State        string
+	NextSequence uint64 // the sequence of the next packet accepted from the Counterparty
+	Refunded     bool   // the escrow of the packets the Counterparty never received was refunded
+}
+
+// Escrow holds the coins of an outgoing CoinsPayload, until the
+// packet is acknowledged. They are refunded if it was rejected.
+type Escrow struct {
+	Sender []byte
+	Coins  types.Coins
 }
 
 func NewPacket(src, dst string, seq uint64, payload Payload) Packet {
@@ -73,7 +155,7 @@ func NewPacket(src, dst string, seq uint64, payload Payload) Packet {
 // The sequence number counts how many packets have been sent.
 // The next packet must include the latest sequence number.
 func GetSequenceNumber(store types.KVStore, src, dst string) uint64 {
-	sequenceKey := toKey(_IBC, _EGRESS, src, dst)
+	sequenceKey := SequenceKey(src, dst)
 	seqBytes := store.Get(sequenceKey)
 	if seqBytes == nil {
 		return 0
@@ -87,25 +169,170 @@ func GetSequenceNumber(store types.KVStore, src, dst string) uint64 {
 
 // SetSequenceNumber sets the sequence number for packets being sent from the src chain to the dst chain
 func SetSequenceNumber(store types.KVStore, src, dst string, seq uint64) {
-	sequenceKey := toKey(_IBC, _EGRESS, src, dst)
+	sequenceKey := SequenceKey(src, dst)
 	store.Set(sequenceKey, []byte(strconv.FormatUint(seq, 10)))
 }
 
 // SaveNewIBCPacket creates an IBC packet with the given payload from the src chain to the dst chain
 // using the correct sequence number. It also increments the sequence number by 1
-func SaveNewIBCPacket(state types.KVStore, src, dst string, payload Payload) {
+func SaveNewIBCPacket(state types.KVStore, src, dst string, payload Payload) Packet {
 	// fetch sequence number and increment by 1
 	seq := GetSequenceNumber(state, src, dst)
 	SetSequenceNumber(state, src, dst, seq+1)
 
 	// save ibc packet
-	packetKey := toKey(_IBC, _EGRESS, src, dst, cmn.Fmt("%v", seq))
+	packetKey := PacketKey(src, dst, seq)
 	packet := NewPacket(src, dst, uint64(seq), payload)
 	save(state, packetKey, packet)
+	return packet
+}
+
+// SetEscrow takes the coins of an outgoing packet out of circulation.
+// Our own coins are locked until they come back, vouchers of the dst chain
+// are burned. It remembers who sent them, so they can be refunded if
+// the packet is rejected. Other payloads need no escrow.
+func SetEscrow(store types.KVStore, packet Packet, sender []byte) {
+	payload, ok := packet.Payload.(CoinsPayload)
+	if !ok {
+		return
+	}
+	_, native := splitVouchers(payload.Coins, packet.DstChainID)
+	locked := GetLockedCoins(store, packet.DstChainID)
+	setLockedCoins(store, packet.DstChainID, locked.Plus(native))
+	if len(sender) == 0 {
+		return
+	}
+	escrowKey := EscrowKey(packet.SrcChainID, packet.DstChainID, packet.Sequence)
+	save(store, escrowKey, Escrow{Sender: sender, Coins: payload.Coins})
+}
+
+// refundEscrow returns the coins of our packet to its sender, after it
+// was rejected or never received. It is false if there was no escrow.
+func refundEscrow(store types.KVStore, src, dst string, seq uint64) (bool, error) {
+	escrowKey := EscrowKey(src, dst, seq)
+	var escrow Escrow
+	exists, err := load(store, escrowKey, &escrow)
+	if err != nil || !exists {
+		return false, err
+	}
+	store.Delete(escrowKey)
+
+	// unlock our coins, and mint the burned vouchers again
+	_, native := splitVouchers(escrow.Coins, dst)
+	locked := GetLockedCoins(store, dst)
+	setLockedCoins(store, dst, locked.Minus(native))
+
+	acc := types.GetAccount(store, escrow.Sender)
+	if acc == nil {
+		acc = &types.Account{}
+	}
+	acc.Balance = acc.Balance.Plus(escrow.Coins)
+	types.SetAccount(store, escrow.Sender, acc)
+	return true, nil
+}
+
+// VoucherDenom is the denom of the coins minted for denom, when they
+// arrive from chainID
+func VoucherDenom(chainID, denom string) string {
+	return VoucherPrefix + chainID + "/" + denom
+}
+
+// splitVouchers returns the vouchers for coins of chainID, with the
+// prefix removed, and all other coins
+func splitVouchers(coins types.Coins, chainID string) (vouchers, others types.Coins) {
+	prefix := VoucherDenom(chainID, "")
+	for _, coin := range coins {
+		if strings.HasPrefix(coin.Denom, prefix) {
+			vouchers = append(vouchers, types.Coin{
+				Denom:  strings.TrimPrefix(coin.Denom, prefix),
+				Amount: coin.Amount,
+			})
+		} else {
+			others = append(others, coin)
+		}
+	}
+	vouchers.Sort()
+	return vouchers, others
+}
+
+// GetLockedCoins returns our coins that were sent to the dst chain,
+// and are held there as vouchers
+func GetLockedCoins(store types.KVStore, dst string) types.Coins {
+	var coins types.Coins
+	_, err := load(store, toKey(_IBC, _LOCKED, dst), &coins)
+	if err != nil {
+		cmn.PanicSanity(err.Error())
+	}
+	return coins
+}
+
+func setLockedCoins(store types.KVStore, dst string, coins types.Coins) {
+	save(store, toKey(_IBC, _LOCKED, dst), coins)
+}
+
+// GetAcknowledgement loads the ack the dst chain wrote for a packet
+func GetAcknowledgement(store types.KVStore, src, dst string, seq uint64) (ack Acknowledgement, exists bool, err error) {
+	ackKey := AckKey(src, dst, seq)
+	exists, err = load(store, ackKey, &ack)
+	return
+}
+
+// GetConnection loads the connection from chainID to the counterparty
+func GetConnection(store types.KVStore, chainID, counterparty string) (conn Connection, exists bool, err error) {
+	exists, err = load(store, ConnectionKey(chainID, counterparty), &conn)
+	return
+}
+
+func setConnection(store types.KVStore, conn Connection) {
+	save(store, ConnectionKey(conn.ChainID, conn.Counterparty), conn)
+}
+
+// GetAdmin returns the address that opens and closes connections,
+// nil if none was set in the genesis
+func GetAdmin(store types.KVStore) (admin []byte, err error) {
+	_, err = load(store, AdminKey(), &admin)
+	return
+}
+
+func setAdmin(store types.KVStore, admin []byte) {
+	save(store, AdminKey(), admin)
+}
+
+// IsRouted returns true if other chains may call the plugin
+func IsRouted(store types.KVStore, plugin string) bool {
+	return exists(store, RouteKey(plugin))
+}
+
+func setRouted(store types.KVStore, plugin string) {
+	save(store, RouteKey(plugin), plugin)
+}
+
+// getRoutes returns the names of all plugins other chains may call
+func getRoutes(store types.KVStore) (plugins []string, err error) {
+	prefix := append(toKey(_IBC, _ROUTE), ',')
+	for it := types.PrefixIterator(store, prefix); it.Valid(); it.Next() {
+		var plugin string
+		err = wire.ReadBinaryBytes(it.Value(), &plugin)
+		if err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, plugin)
+	}
+	return plugins, nil
+}
+
+// getHeight returns the height of the current block, as set in BeginBlock
+func getHeight(store types.KVStore) uint64 {
+	var height uint64
+	_, err := load(store, toKey(_IBC, _HEIGHT), &height)
+	if err != nil {
+		cmn.PanicSanity(err.Error())
+	}
+	return height
 }
 
 func GetIBCPacket(state types.KVStore, src, dst string, seq uint64) (Packet, error) {
-	packetKey := toKey(_IBC, _EGRESS, src, dst, cmn.Fmt("%v", seq))
+	packetKey := PacketKey(src, dst, seq)
 	packetBytes := state.Get(packetKey)
 
 	var packet Packet
@@ -116,14 +343,16 @@ func GetIBCPacket(state types.KVStore, src, dst string, seq uint64) (Packet, err
 //--------------------------------------------------------------------------------
 
 const (
-	PayloadTypeBytes = byte(0x01)
-	PayloadTypeCoins = byte(0x02)
+	PayloadTypeBytes  = byte(0x01)
+	PayloadTypeCoins  = byte(0x02)
+	PayloadTypePlugin = byte(0x03)
 )
 
 var _ = wire.RegisterInterface(
 	struct{ Payload }{},
 	wire.ConcreteType{DataPayload{}, PayloadTypeBytes},
 	wire.ConcreteType{CoinsPayload{}, PayloadTypeCoins},
+	wire.ConcreteType{PluginPayload{}, PayloadTypePlugin},
 )
 
 type Payload interface {
@@ -132,8 +361,9 @@ type Payload interface {
 	ValidateBasic() wrsp.Result
 }
 
-func (DataPayload) AssertIsPayload()  {}
-func (CoinsPayload) AssertIsPayload() {}
+func (DataPayload) AssertIsPayload()   {}
+func (CoinsPayload) AssertIsPayload()  {}
+func (PluginPayload) AssertIsPayload() {}
 
 type DataPayload []byte
 
@@ -155,7 +385,34 @@ func (p CoinsPayload) Type() string {
 }
 
 func (p CoinsPayload) ValidateBasic() wrsp.Result {
-	// TODO: validate
+	if len(p.Address) == 0 {
+		return wrsp.ErrBaseInvalidOutput.AppendLog("No address")
+	}
+	if !p.Coins.IsValid() || !p.Coins.IsPositive() {
+		return wrsp.ErrBaseInvalidOutput.AppendLog(cmn.Fmt("Invalid coins %v", p.Coins))
+	}
+	return wrsp.OK
+}
+
+// PluginPayload runs Data as a tx of the Plugin on the Dst chain, called
+// by the Sender on the Src chain. The result goes into the ack.
+type PluginPayload struct {
+	Plugin string
+	Sender []byte
+	Data   []byte
+}
+
+func (p PluginPayload) Type() string {
+	return "plugin"
+}
+
+func (p PluginPayload) ValidateBasic() wrsp.Result {
+	if p.Plugin == "" {
+		return wrsp.ErrBaseInvalidInput.AppendLog("No plugin")
+	}
+	if len(p.Sender) == 0 {
+		return wrsp.ErrBaseInvalidInput.AppendLog("No sender")
+	}
 	return wrsp.OK
 }
 
@@ -166,6 +423,17 @@ const (
 	IBCTxTypeUpdateChain   = byte(0x02)
 	IBCTxTypePacketCreate  = byte(0x03)
 	IBCTxTypePacketPost    = byte(0x04)
+	IBCTxTypePacketAck     = byte(0x05)
+
+	IBCTxTypeConnectionInit         = byte(0x06)
+	IBCTxTypeConnectionTry          = byte(0x07)
+	IBCTxTypeConnectionAck          = byte(0x08)
+	IBCTxTypeConnectionConfirm      = byte(0x09)
+	IBCTxTypeConnectionClose        = byte(0x0a)
+	IBCTxTypeConnectionCloseConfirm = byte(0x0b)
+	IBCTxTypeMisbehaviour           = byte(0x0c)
+	IBCTxTypeMulti                  = byte(0x0d)
+	IBCTxTypePacketTimeout          = byte(0x0e)
 
 	IBCCodeEncodingError       = wrsp.CodeType(1001)
 	IBCCodeChainAlreadyExists  = wrsp.CodeType(1002)
@@ -173,6 +441,12 @@ const (
 	IBCCodeUnknownHeight       = wrsp.CodeType(1004)
 	IBCCodeInvalidCommit       = wrsp.CodeType(1005)
 	IBCCodeInvalidProof        = wrsp.CodeType(1006)
+	IBCCodeUnknownPacket       = wrsp.CodeType(1007)
+	IBCCodeConnectionState     = wrsp.CodeType(1008)
+	IBCCodePacketOutOfOrder    = wrsp.CodeType(1009)
+	IBCCodeChainFrozen         = wrsp.CodeType(1010)
+	IBCCodePruned              = wrsp.CodeType(1011)
+	IBCCodeNotTimedOut         = wrsp.CodeType(1012)
 )
 
 var _ = wire.RegisterInterface(
@@ -181,6 +455,16 @@ var _ = wire.RegisterInterface(
 	wire.ConcreteType{IBCUpdateChainTx{}, IBCTxTypeUpdateChain},
 	wire.ConcreteType{IBCPacketCreateTx{}, IBCTxTypePacketCreate},
 	wire.ConcreteType{IBCPacketPostTx{}, IBCTxTypePacketPost},
+	wire.ConcreteType{IBCPacketAckTx{}, IBCTxTypePacketAck},
+	wire.ConcreteType{IBCConnectionInitTx{}, IBCTxTypeConnectionInit},
+	wire.ConcreteType{IBCConnectionTryTx{}, IBCTxTypeConnectionTry},
+	wire.ConcreteType{IBCConnectionAckTx{}, IBCTxTypeConnectionAck},
+	wire.ConcreteType{IBCConnectionConfirmTx{}, IBCTxTypeConnectionConfirm},
+	wire.ConcreteType{IBCConnectionCloseTx{}, IBCTxTypeConnectionClose},
+	wire.ConcreteType{IBCConnectionCloseConfirmTx{}, IBCTxTypeConnectionCloseConfirm},
+	wire.ConcreteType{IBCMisbehaviourTx{}, IBCTxTypeMisbehaviour},
+	wire.ConcreteType{IBCMultiTx{}, IBCTxTypeMulti},
+	wire.ConcreteType{IBCPacketTimeoutTx{}, IBCTxTypePacketTimeout},
 )
 
 type IBCTx interface {
@@ -192,6 +476,17 @@ func (IBCRegisterChainTx) AssertIsIBCTx() {}
 func (IBCUpdateChainTx) AssertIsIBCTx()   {}
 func (IBCPacketCreateTx) AssertIsIBCTx()  {}
 func (IBCPacketPostTx) AssertIsIBCTx()    {}
+func (IBCPacketAckTx) AssertIsIBCTx()     {}
+func (IBCPacketTimeoutTx) AssertIsIBCTx() {}
+
+func (IBCConnectionInitTx) AssertIsIBCTx()         {}
+func (IBCConnectionTryTx) AssertIsIBCTx()          {}
+func (IBCConnectionAckTx) AssertIsIBCTx()          {}
+func (IBCConnectionConfirmTx) AssertIsIBCTx()      {}
+func (IBCConnectionCloseTx) AssertIsIBCTx()        {}
+func (IBCConnectionCloseConfirmTx) AssertIsIBCTx() {}
+func (IBCMisbehaviourTx) AssertIsIBCTx()           {}
+func (IBCMultiTx) AssertIsIBCTx()                  {}
 
 type IBCRegisterChainTx struct {
 	BlockchainGenesis
@@ -202,14 +497,23 @@ func (IBCRegisterChainTx) ValidateBasic() (res wrsp.Result) {
 	return
 }
 
+// IBCUpdateChainTx stores a header committed by the chain.
+// If the validator set changed since the last update, NextValidators
+// holds the set that signed the header. The change is accepted if more
+// than 2/3 of the voting power we trust signed it as well, so a relayer
+// can skip any number of blocks in between.
 type IBCUpdateChainTx struct {
-	Header tm.Header
-	Commit tm.Commit
-	// TODO: NextValidators
+	Header         tm.Header
+	Commit         tm.Commit
+	NextValidators []*tm.Validator
 }
 
-func (IBCUpdateChainTx) ValidateBasic() (res wrsp.Result) {
-	// TODO - validate
+func (tx IBCUpdateChainTx) ValidateBasic() (res wrsp.Result) {
+	for _, val := range tx.NextValidators {
+		if val == nil || val.PubKey.Empty() || val.VotingPower <= 0 {
+			return wrsp.ErrBaseInvalidInput.AppendLog("Invalid validator in NextValidators")
+		}
+	}
 	return
 }
 
@@ -223,20 +527,159 @@ func (IBCPacketCreateTx) ValidateBasic() (res wrsp.Result) {
 }
 
 type IBCPacketPostTx struct {
-	FromChainID     string // The immediate source of the packet, not always Packet.SrcChainID
+	FromChainID     string // The Src chain of the packet, that committed it
 	FromChainHeight uint64 // The block height in which Packet was committed, to check Proof
 	Packet
 	Proof *merkle.IAVLProof
 }
 
-func (IBCPacketPostTx) ValidateBasic() (res wrsp.Result) {
-	// TODO - validate
+func (tx IBCPacketPostTx) ValidateBasic() (res wrsp.Result) {
+	// only the chain a packet claims to come from can prove it
+	if tx.FromChainID != tx.SrcChainID {
+		return wrsp.ErrBaseInvalidInput.AppendLog("Packet must come from its source")
+	}
+	return
+}
+
+// IBCPacketAckTx relays the Acknowledgement of a packet from the
+// Dst chain back to the Src chain
+type IBCPacketAckTx struct {
+	FromChainID     string // The Dst chain of the packet, that wrote the ack
+	FromChainHeight uint64 // The block height in which the ack was committed, to check Proof
+	Acknowledgement
+	Proof *merkle.IAVLProof
+}
+
+func (tx IBCPacketAckTx) ValidateBasic() (res wrsp.Result) {
+	if tx.FromChainID != tx.DstChainID {
+		return wrsp.ErrBaseInvalidInput.AppendLog("Ack must come from the packet destination")
+	}
+	return
+}
+
+// IBCPacketTimeoutTx refunds a packet that timed out, with a proof of
+// the connection of the Dst chain to us, at a height from the packet's
+// TimeoutHeight on. Packets are received in order, so if the connection
+// still expects this packet or an earlier one, it was never received.
+type IBCPacketTimeoutTx struct {
+	Packet
+	ConnectionProof
+}
+
+func (tx IBCPacketTimeoutTx) ValidateBasic() (res wrsp.Result) {
+	if tx.TimeoutHeight == 0 {
+		return wrsp.ErrBaseInvalidInput.AppendLog("Packet never times out")
+	}
+	return tx.ConnectionProof.ValidateBasic()
+}
+
+// IBCMultiTx runs all txs in order, and fails if any of them fails.
+// Relayers use it to post headers together with the packets they prove.
+type IBCMultiTx struct {
+	Txs []IBCTx
+}
+
+func (tx IBCMultiTx) ValidateBasic() (res wrsp.Result) {
+	for i, tx := range tx.Txs {
+		if tx == nil {
+			return wrsp.ErrBaseInvalidInput.AppendLog(cmn.Fmt("Tx %d is empty", i))
+		}
+		if _, ok := tx.(IBCMultiTx); ok {
+			return wrsp.ErrBaseInvalidInput.AppendLog("IBCMultiTx cannot be nested")
+		}
+		res = tx.ValidateBasic()
+		if res.IsErr() {
+			return res.PrependLog(cmn.Fmt("Tx %d: ", i))
+		}
+	}
+	return wrsp.OK
+}
+
+// IBCMisbehaviourTx proves that the validators of a chain signed two
+// different headers at the same height. The chain is frozen for good,
+// unfreezing it is left to the governance of this chain.
+type IBCMisbehaviourTx struct {
+	Header1 tm.Header
+	Commit1 tm.Commit
+	Header2 tm.Header
+	Commit2 tm.Commit
+}
+
+func (tx IBCMisbehaviourTx) ValidateBasic() (res wrsp.Result) {
+	if tx.Header1.ChainID != tx.Header2.ChainID || tx.Header1.Height != tx.Header2.Height {
+		return wrsp.ErrBaseInvalidInput.AppendLog("Headers must have the same chain and height")
+	}
+	if bytes.Equal(tx.Header1.Hash(), tx.Header2.Hash()) {
+		return wrsp.ErrBaseInvalidInput.AppendLog("Headers are the same")
+	}
+	return
+}
+
+// IBCConnectionInitTx starts the handshake with the Counterparty
+type IBCConnectionInitTx struct {
+	Counterparty string
+}
+
+func (tx IBCConnectionInitTx) ValidateBasic() (res wrsp.Result) {
+	if tx.Counterparty == "" {
+		return wrsp.ErrBaseInvalidInput.AppendLog("No counterparty")
+	}
+	return
+}
+
+// ConnectionProof proves the state of the connection on the counterparty
+type ConnectionProof struct {
+	FromChainHeight uint64     // The block height in which Connection was committed, to check Proof
+	Connection      Connection // As stored on the counterparty
+	Proof           *merkle.IAVLProof
+}
+
+func (cp ConnectionProof) ValidateBasic() (res wrsp.Result) {
+	if cp.Connection.ChainID == "" || cp.Connection.Counterparty == "" {
+		return wrsp.ErrBaseInvalidInput.AppendLog("Connection needs both chain ids")
+	}
+	return
+}
+
+// IBCConnectionTryTx answers an IBCConnectionInitTx on the counterparty
+type IBCConnectionTryTx struct {
+	ConnectionProof
+}
+
+// IBCConnectionAckTx opens the connection, once the counterparty tried it
+type IBCConnectionAckTx struct {
+	ConnectionProof
+}
+
+// IBCConnectionConfirmTx opens the connection, once the counterparty opened it
+type IBCConnectionConfirmTx struct {
+	ConnectionProof
+}
+
+// IBCConnectionCloseTx closes an open connection, only the admin may send it
+type IBCConnectionCloseTx struct {
+	Counterparty string
+}
+
+func (tx IBCConnectionCloseTx) ValidateBasic() (res wrsp.Result) {
+	if tx.Counterparty == "" {
+		return wrsp.ErrBaseInvalidInput.AppendLog("No counterparty")
+	}
 	return
 }
 
+// IBCConnectionCloseConfirmTx closes the connection, once the counterparty closed it,
+// and refunds the packets the counterparty never received
+type IBCConnectionCloseConfirmTx struct {
+	ConnectionProof
+}
+
 //--------------------------------------------------------------------------------
 
 type IBCPlugin struct {
+	routes   map[string]types.Plugin     // the plugins PluginPayloads may call, once routed
+	handlers map[string]basecoin.Handler // the handlers PluginPayloads may call, once routed
+	logger   log.Logger
 }
 
 func (ibc *IBCPlugin) Name() string {
@@ -248,11 +691,58 @@ func (ibc *IBCPlugin) StateKey() []byte {
 }
 
 func New() *IBCPlugin {
-	return &IBCPlugin{}
+	return &IBCPlugin{
+		routes:   make(map[string]types.Plugin),
+		handlers: make(map[string]basecoin.Handler),
+		logger:   log.NewNopLogger(),
+	}
+}
+
+// SetLogger sets the logger for what fails outside of a tx, like pruning
+func (ibc *IBCPlugin) SetLogger(l log.Logger) {
+	ibc.logger = l
 }
 
+// AddRoute lets PluginPayloads from other chains call the plugin, once it
+// is routed with the "route" option or in the genesis
+func (ibc *IBCPlugin) AddRoute(plugin types.Plugin) {
+	if _, ok := ibc.handlers[plugin.Name()]; ok {
+		panic(cmn.Fmt("Route for %s already registered", plugin.Name()))
+	}
+	ibc.routes[plugin.Name()] = plugin
+}
+
+// AddHandler lets PluginPayloads from other chains run a basecoin.Tx
+// through the handler, once name is routed like a plugin. The sender
+// on the other chain is the only permission the tx gets.
+func (ibc *IBCPlugin) AddHandler(name string, h basecoin.Handler) {
+	if _, ok := ibc.routes[name]; ok {
+		panic(cmn.Fmt("Route for %s already registered", name))
+	}
+	ibc.handlers[name] = h
+}
+
+// SetOption sets the admin with the key "admin" (a hex address), routes
+// PluginPayloads to the plugin named by the key "route", and sets the
+// retention policy, with the keys "header_retention" (a number of heights)
+// and "prune_packets" (a bool)
 func (ibc *IBCPlugin) SetOption(store types.KVStore, key string, value string) (log string) {
-	return ""
+	switch key {
+	case "admin":
+		admin, err := hex.DecodeString(value)
+		if err != nil || len(admin) == 0 {
+			return cmn.Fmt("Invalid admin address %v", value)
+		}
+		setAdmin(store, admin)
+		return "Success"
+	case "route":
+		if value == "" {
+			return "No plugin to route"
+		}
+		setRouted(store, value)
+		return "Success"
+	}
+	return setPruningOption(store, key, value)
 }
 
 func (ibc *IBCPlugin) RunTx(store types.KVStore, ctx types.CallContext, txBytes []byte) (res wrsp.Result) {
@@ -278,8 +768,20 @@ func (ibc *IBCPlugin) RunTx(store types.KVStore, ctx types.CallContext, txBytes
 		// NOTE: We should use the CallContext to store fund/refund information.
 	}()
 
-	sm := &IBCStateMachine{store, ctx, wrsp.OK}
+	sm := &IBCStateMachine{store, ctx, wrsp.OK, ibc.routes, ibc.handlers}
+	sm.run(tx)
+	return sm.res
+}
+
+type IBCStateMachine struct {
+	store    types.KVStore
+	ctx      types.CallContext
+	res      wrsp.Result
+	routes   map[string]types.Plugin
+	handlers map[string]basecoin.Handler
+}
 
+func (sm *IBCStateMachine) run(tx IBCTx) {
 	switch tx := tx.(type) {
 	case IBCRegisterChainTx:
 		sm.runRegisterChainTx(tx)
@@ -289,40 +791,74 @@ func (ibc *IBCPlugin) RunTx(store types.KVStore, ctx types.CallContext, txBytes
 		sm.runPacketCreateTx(tx)
 	case IBCPacketPostTx:
 		sm.runPacketPostTx(tx)
+	case IBCPacketAckTx:
+		sm.runPacketAckTx(tx)
+	case IBCPacketTimeoutTx:
+		sm.runPacketTimeoutTx(tx)
+	case IBCConnectionInitTx:
+		sm.runConnectionInitTx(tx)
+	case IBCConnectionTryTx:
+		sm.runConnectionTryTx(tx)
+	case IBCConnectionAckTx:
+		sm.runConnectionOpenTx(tx.ConnectionProof, ConnectionInit, ConnectionTryOpen)
+	case IBCConnectionConfirmTx:
+		sm.runConnectionOpenTx(tx.ConnectionProof, ConnectionTryOpen, ConnectionOpen)
+	case IBCConnectionCloseTx:
+		sm.runConnectionCloseTx(tx)
+	case IBCConnectionCloseConfirmTx:
+		sm.runConnectionCloseConfirmTx(tx)
+	case IBCMisbehaviourTx:
+		sm.runMisbehaviourTx(tx)
+	case IBCMultiTx:
+		sm.runMultiTx(tx)
 	}
-
-	return sm.res
 }
 
-type IBCStateMachine struct {
-	store types.KVStore
-	ctx   types.CallContext
-	res   wrsp.Result
+// runMultiTx stops at the first failure. The caller discards all
+// changes of a failed tx, so the earlier txs are rolled back too.
+func (sm *IBCStateMachine) runMultiTx(tx IBCMultiTx) {
+	for i, tx := range tx.Txs {
+		sm.run(tx)
+		if sm.res.IsErr() {
+			sm.res = sm.res.PrependLog(cmn.Fmt("Tx %d: ", i))
+			return
+		}
+	}
 }
 
 func (sm *IBCStateMachine) runRegisterChainTx(tx IBCRegisterChainTx) {
-	chainGenKey := toKey(_IBC, _BLOCKCHAIN, _GENESIS, tx.ChainID)
-	chainStateKey := toKey(_IBC, _BLOCKCHAIN, _STATE, tx.ChainID)
-	chainGen := tx.BlockchainGenesis
+	sm.res = saveNewChain(sm.store, tx.BlockchainGenesis)
+}
+
+// saveNewChain saves the genesis and initial state of a new chain
+func saveNewChain(store types.KVStore, chainGen BlockchainGenesis) (res wrsp.Result) {
+	chainGenKey := ChainGenesisKey(chainGen.ChainID)
+	chainStateKey := ChainStateKey(chainGen.ChainID)
 
 	// Parse genesis
 	chainGenDoc := new(tm.GenesisDoc)
 	err := json.Unmarshal([]byte(chainGen.Genesis), chainGenDoc)
 	if err != nil {
-		sm.res.Code = IBCCodeEncodingError
-		sm.res.Log = "Genesis doc couldn't be parsed: " + err.Error()
+		res.Code = IBCCodeEncodingError
+		res.Log = "Genesis doc couldn't be parsed: " + err.Error()
 		return
 	}
 
 	// Make sure chainGen doesn't already exist
-	if exists(sm.store, chainGenKey) {
-		sm.res.Code = IBCCodeChainAlreadyExists
-		sm.res.Log = "Already exists"
+	if exists(store, chainGenKey) {
+		res.Code = IBCCodeChainAlreadyExists
+		res.Log = "Already exists"
 		return
 	}
 
-	// Save new BlockchainGenesis
-	save(sm.store, chainGenKey, chainGen)
+	// Save new BlockchainGenesis, and list it
+	save(store, chainGenKey, chainGen)
+	chainIDs, err := GetChainIDs(store)
+	if err != nil {
+		res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading chain list: %v", err.Error()))
+		return
+	}
+	save(store, ChainListKey(), append(chainIDs, chainGen.ChainID))
 
 	// Create new BlockchainState
 	chainState := BlockchainState{
@@ -343,12 +879,13 @@ func (sm *IBCStateMachine) runRegisterChainTx(tx IBCRegisterChainTx) {
 	}
 
 	// Save new BlockchainState
-	save(sm.store, chainStateKey, chainState)
+	save(store, chainStateKey, chainState)
+	return
 }
 
 func (sm *IBCStateMachine) runUpdateChainTx(tx IBCUpdateChainTx) {
 	chainID := tx.Header.ChainID
-	chainStateKey := toKey(_IBC, _BLOCKCHAIN, _STATE, chainID)
+	chainStateKey := ChainStateKey(chainID)
 
 	// Make sure chainState exists
 	if !exists(sm.store, chainStateKey) {
@@ -367,16 +904,35 @@ func (sm *IBCStateMachine) runUpdateChainTx(tx IBCUpdateChainTx) {
 		return
 	}
 
-	// Check commit against last known state & validators
-	err = verifyCommit(chainState, &tx.Header, &tx.Commit)
+	if chainState.Frozen {
+		sm.res.Code = IBCCodeChainFrozen
+		sm.res.Log = "Chain is frozen"
+		return
+	}
+	if uint64(tx.Header.Height) < chainState.PrunedHeight {
+		sm.res.Code = IBCCodePruned
+		sm.res.Log = cmn.Fmt("Headers below height %v are pruned", chainState.PrunedHeight)
+		return
+	}
+
+	// Check commit against last known state & validators,
+	// or the change of validators
+	if len(tx.NextValidators) == 0 {
+		err = verifyCommit(chainState, &tx.Header, &tx.Commit)
+	} else {
+		err = verifyValidatorChange(chainState, &tx.Header, &tx.Commit, tx.NextValidators)
+	}
 	if err != nil {
 		sm.res.Code = IBCCodeInvalidCommit
 		sm.res.Log = cmn.Fmt("Invalid Commit: %v", err.Error())
 		return
 	}
+	if len(tx.NextValidators) > 0 {
+		chainState.Validators = tx.NextValidators
+	}
 
 	// Store header
-	headerKey := toKey(_IBC, _BLOCKCHAIN, _HEADER, chainID, cmn.Fmt("%v", tx.Header.Height))
+	headerKey := HeaderKey(chainID, uint64(tx.Header.Height))
 	save(sm.store, headerKey, tx.Header)
 
 	// Update chainState
@@ -387,13 +943,55 @@ func (sm *IBCStateMachine) runUpdateChainTx(tx IBCUpdateChainTx) {
 	save(sm.store, chainStateKey, chainState)
 }
 
+func (sm *IBCStateMachine) runMisbehaviourTx(tx IBCMisbehaviourTx) {
+	chainID := tx.Header1.ChainID
+	chainStateKey := ChainStateKey(chainID)
+
+	var chainState BlockchainState
+	exists, err := load(sm.store, chainStateKey, &chainState)
+	if err != nil {
+		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading ChainState: %v", err.Error()))
+		return
+	}
+	if !exists {
+		sm.res = wrsp.ErrBaseInvalidInput.AppendLog(cmn.Fmt("Unknown chain %v", chainID))
+		return
+	}
+	if chainState.Frozen {
+		sm.res.Code = IBCCodeChainFrozen
+		sm.res.Log = "Chain is frozen"
+		return
+	}
+
+	// Both headers must be signed by the validators we trust
+	for _, signed := range []struct {
+		header *tm.Header
+		commit *tm.Commit
+	}{{&tx.Header1, &tx.Commit1}, {&tx.Header2, &tx.Commit2}} {
+		err = verifyCommit(chainState, signed.header, signed.commit)
+		if err != nil {
+			sm.res.Code = IBCCodeInvalidCommit
+			sm.res.Log = cmn.Fmt("Invalid Commit: %v", err.Error())
+			return
+		}
+	}
+
+	chainState.Frozen = true
+	save(sm.store, chainStateKey, chainState)
+	sm.res.Log = cmn.Fmt("Froze chain %v", chainID)
+}
+
 func (sm *IBCStateMachine) runPacketCreateTx(tx IBCPacketCreateTx) {
 	packet := tx.Packet
-	packetKey := toKey(_IBC, _EGRESS,
-		packet.SrcChainID,
-		packet.DstChainID,
-		cmn.Fmt("%v", packet.Sequence),
-	)
+	chainID := sm.chainID()
+	if chainID == "" {
+		return
+	}
+	if packet.SrcChainID != chainID {
+		sm.res = wrsp.ErrBaseInvalidInput.AppendLog(cmn.Fmt("Packet must be sent from %v", chainID))
+		return
+	}
+	packetKey := PacketKey(packet.SrcChainID, packet.DstChainID, packet.Sequence)
 	// Make sure packet doesn't already exist
 	if exists(sm.store, packetKey) {
 		sm.res.Code = IBCCodePacketAlreadyExists
@@ -402,6 +1000,24 @@ func (sm *IBCStateMachine) runPacketCreateTx(tx IBCPacketCreateTx) {
 		return
 	}
 
+	// Packets are sent in order, and never on a closed connection
+	seq := GetSequenceNumber(sm.store, packet.SrcChainID, packet.DstChainID)
+	if packet.Sequence != seq {
+		sm.res.Code = IBCCodePacketOutOfOrder
+		sm.res.Log = cmn.Fmt("Expected sequence %v, got %v", seq, packet.Sequence)
+		return
+	}
+	conn, exists, err := GetConnection(sm.store, packet.SrcChainID, packet.DstChainID)
+	if err != nil {
+		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading Connection: %v", err.Error()))
+		return
+	}
+	if exists && conn.State == ConnectionClosed {
+		sm.res.Code = IBCCodeConnectionState
+		sm.res.Log = "Connection is closed"
+		return
+	}
+
 	// Execute the payload
 	switch payload := tx.Packet.Payload.(type) {
 	case DataPayload:
@@ -414,101 +1030,797 @@ func (sm *IBCStateMachine) runPacketCreateTx(tx IBCPacketCreateTx) {
 			return
 		}
 
-		// deduct coins from context
+		// deduct coins from context, and hold them until the packet is acknowledged
 		sm.ctx.Coins = sm.ctx.Coins.Minus(payload.Coins)
+		SetEscrow(sm.store, packet, sm.ctx.CallerAddress)
+	case PluginPayload:
+		// no one can call another chain in our name
+		if !bytes.Equal(payload.Sender, sm.ctx.CallerAddress) {
+			sm.res.Code = wrsp.CodeType_Unauthorized
+			sm.res.Log = "Sender must be the caller"
+			return
+		}
 	}
 
 	// Save new Packet
 	save(sm.store, packetKey, packet)
 
-	// set the sequence number
-	SetSequenceNumber(sm.store, packet.SrcChainID, packet.DstChainID, packet.Sequence)
+	// set the sequence number for the next packet
+	SetSequenceNumber(sm.store, packet.SrcChainID, packet.DstChainID, packet.Sequence+1)
 }
 
 func (sm *IBCStateMachine) runPacketPostTx(tx IBCPacketPostTx) {
 	packet := tx.Packet
-	packetKeyEgress := toKey(_IBC, _EGRESS,
-		packet.SrcChainID,
-		packet.DstChainID,
-		cmn.Fmt("%v", packet.Sequence),
-	)
+	packetKeyEgress := PacketKey(packet.SrcChainID, packet.DstChainID, packet.Sequence)
 	packetKeyIngress := toKey(_IBC, _INGRESS,
 		packet.DstChainID,
 		packet.SrcChainID,
 		cmn.Fmt("%v", packet.Sequence),
 	)
-	headerKey := toKey(_IBC, _BLOCKCHAIN, _HEADER,
-		tx.FromChainID,
-		cmn.Fmt("%v", tx.FromChainHeight),
-	)
-
-	// Make sure packet doesn't already exist
-	if exists(sm.store, packetKeyIngress) {
-		sm.res.Code = IBCCodePacketAlreadyExists
-		sm.res.Log = "Already exists"
+	chainID := sm.chainID()
+	if chainID == "" {
+		return
+	}
+	if packet.DstChainID != chainID {
+		sm.res = wrsp.ErrBaseInvalidInput.AppendLog(cmn.Fmt("Packet is not for %v", chainID))
 		return
 	}
 
-	// Save new Packet (just for fun)
-	save(sm.store, packetKeyIngress, packet)
-
-	// Load Header and make sure it exists
-	// If it exists, we already checked a valid commit for it in UpdateChainTx
-	var header tm.Header
-	exists, err := load(sm.store, headerKey, &header)
+	// Packets are only accepted on an open connection, and without gaps
+	conn, exists, err := GetConnection(sm.store, packet.DstChainID, packet.SrcChainID)
 	if err != nil {
-		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading Header: %v", err.Error()))
+		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading Connection: %v", err.Error()))
 		return
 	}
-	if !exists {
-		sm.res.Code = IBCCodeUnknownHeight
-		sm.res.Log = cmn.Fmt("Loading Header: Unknown height")
+	if !exists || conn.State != ConnectionOpen {
+		sm.res.Code = IBCCodeConnectionState
+		sm.res.Log = "Connection is not open"
 		return
 	}
-
-	proof := tx.Proof
-	if proof == nil {
-		sm.res.Code = IBCCodeInvalidProof
-		sm.res.Log = "Proof is nil"
+	if packet.Sequence < conn.NextSequence {
+		sm.res.Code = IBCCodePacketAlreadyExists
+		sm.res.Log = "Already exists"
+		return
+	}
+	if packet.Sequence > conn.NextSequence {
+		sm.res.Code = IBCCodePacketOutOfOrder
+		sm.res.Log = cmn.Fmt("Expected sequence %v, got %v", conn.NextSequence, packet.Sequence)
 		return
 	}
-	packetBytes := wire.BinaryBytes(packet)
+
+	// Save new Packet (just for fun)
+	save(sm.store, packetKeyIngress, packet)
 
 	// Make sure packet's proof matches given (packet, key, blockhash)
-	ok := proof.Verify(packetKeyEgress, packetBytes, header.AppHash)
-	if !ok {
-		sm.res.Code = IBCCodeInvalidProof
-		sm.res.Log = fmt.Sprintf("Proof is invalid. key: %s; packetByes %X; header %v; proof %v", packetKeyEgress, packetBytes, header, proof)
+	if !sm.verifyProof(tx.FromChainID, tx.FromChainHeight, packetKeyEgress, wire.BinaryBytes(packet), tx.Proof) {
 		return
 	}
 
-	// Execute payload
-	switch payload := packet.Payload.(type) {
-	case DataPayload:
-		// do nothing
-	case CoinsPayload:
-		// Add coins to destination account
-		acc := types.GetAccount(sm.store, payload.Address)
-		if acc == nil {
-			acc = &types.Account{}
-		}
-		acc.Balance = acc.Balance.Plus(payload.Coins)
-		types.SetAccount(sm.store, payload.Address, acc)
+	// Execute payload, unless it is rejected, and acknowledge it either way
+	ack := Acknowledgement{
+		SrcChainID: packet.SrcChainID,
+		DstChainID: packet.DstChainID,
+		Sequence:   packet.Sequence,
+	}
+	if packet.TimeoutHeight > 0 && getHeight(sm.store) >= packet.TimeoutHeight {
+		ack.Log = cmn.Fmt("Timed out at height %v", packet.TimeoutHeight)
+	} else if res := packet.Payload.ValidateBasic(); res.IsErr() {
+		ack.Log = "Invalid payload: " + res.Log
+	} else if data, err := sm.receivePayload(packet); err != nil {
+		ack.Log = err.Error()
+	} else {
+		ack.Success = true
+		ack.Data = data
 	}
+	ackKey := AckKey(packet.SrcChainID, packet.DstChainID, packet.Sequence)
+	save(sm.store, ackKey, ack)
+	sm.res.Log = ack.Log
 
-	return
+	conn.NextSequence++
+	setConnection(sm.store, conn)
 }
 
-func (ibc *IBCPlugin) InitChain(store types.KVStore, vals []*wrsp.Validator) {
-}
+// receivePayload executes the payload of an incoming packet,
+// and returns the result for the ack
+func (sm *IBCStateMachine) receivePayload(packet Packet) ([]byte, error) {
+	switch payload := packet.Payload.(type) {
+	case CoinsPayload:
+		return nil, receiveCoins(sm.store, packet.SrcChainID, packet.DstChainID, payload)
+	case PluginPayload:
+		return sm.callPlugin(packet.SrcChainID, payload)
+	}
+	return nil, nil
+}
+
+// callPlugin runs the payload atomically, so a failing plugin leaves
+// nothing behind. The caller is the sender on the src chain, which has
+// no account here, so the plugin gets an empty one.
+func (sm *IBCStateMachine) callPlugin(src string, payload PluginPayload) ([]byte, error) {
+	if !IsRouted(sm.store, payload.Plugin) {
+		return nil, fmt.Errorf("Unknown plugin %v", payload.Plugin)
+	}
+	if h := sm.handlers[payload.Plugin]; h != nil {
+		return sm.callHandler(src, h, payload)
+	}
+	plugin := sm.routes[payload.Plugin]
+	if plugin == nil {
+		return nil, fmt.Errorf("Unknown plugin %v", payload.Plugin)
+	}
+	ctx := types.NewCallContext(payload.Sender, &types.Account{}, types.Coins{})
+	ctx.CallerChainID = src
+	ctx.GasMeter = sm.ctx.GasMeter
+
+	var res wrsp.Result
+	err := types.Atomic(sm.store, func(store types.KVStore) error {
+		res = plugin.RunTx(store, ctx, payload.Data)
+		if res.IsErr() {
+			return fmt.Errorf("Plugin %v failed: %v", payload.Plugin, res.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// callHandler runs the payload as a basecoin.Tx through the handler,
+// atomically like callPlugin. The tx is authorized by the sender on the
+// src chain, and nobody else. Plugins don't know the time of the block.
+func (sm *IBCStateMachine) callHandler(src string, h basecoin.Handler, payload PluginPayload) ([]byte, error) {
+	var tx basecoin.Tx
+	err := data.FromWire(payload.Data, &tx)
+	if err != nil {
+		return nil, fmt.Errorf("Decoding tx: %v", err)
+	}
+	err = tx.ValidateBasic()
+	if err != nil {
+		return nil, fmt.Errorf("Handler %v failed: %v", payload.Plugin, err)
+	}
+	sender := basecoin.Actor{ChainID: src, App: basecoin.NameSigs, Address: payload.Sender}
+	ctx, err := basecoin.NewContext(types.GetChainID(sm.store), getHeight(sm.store), 0, nil).
+		WithPermissions(sender)
+	if err != nil {
+		return nil, err
+	}
+	ctx = ctx.WithGasMeter(sm.ctx.GasMeter)
+
+	var res basecoin.Result
+	err = types.Atomic(sm.store, func(store types.KVStore) (err error) {
+		res, err = h.DeliverTx(ctx, store, tx)
+		if err != nil {
+			return fmt.Errorf("Handler %v failed: %v", payload.Plugin, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// receiveCoins releases our own coins coming back from src,
+// and mints all others as vouchers
+func receiveCoins(store types.KVStore, src, dst string, payload CoinsPayload) error {
+	returning, foreign := splitVouchers(payload.Coins, dst)
+	locked := GetLockedCoins(store, src)
+	if !locked.IsGTE(returning) {
+		return fmt.Errorf("Chain %v holds %v of our coins, cannot return %v", src, locked, returning)
+	}
+	setLockedCoins(store, src, locked.Minus(returning))
+
+	coins := returning
+	for _, coin := range foreign {
+		coins = append(coins, types.Coin{
+			Denom:  VoucherDenom(src, coin.Denom),
+			Amount: coin.Amount,
+		})
+	}
+	coins.Sort()
+
+	// Add coins to destination account
+	acc := types.GetAccount(store, payload.Address)
+	if acc == nil {
+		acc = &types.Account{}
+	}
+	acc.Balance = acc.Balance.Plus(coins)
+	types.SetAccount(store, payload.Address, acc)
+	return nil
+}
+
+func (sm *IBCStateMachine) runPacketAckTx(tx IBCPacketAckTx) {
+	ack := tx.Acknowledgement
+	packetKey := PacketKey(ack.SrcChainID, ack.DstChainID, ack.Sequence)
+	ackKey := AckKey(ack.SrcChainID, ack.DstChainID, ack.Sequence)
+	receiptKey := ReceiptKey(ack.SrcChainID, ack.DstChainID, ack.Sequence)
+
+	// We must have sent the packet, and not seen the ack yet
+	pruned, err := GetPrunedPackets(sm.store, ack.DstChainID)
+	if err != nil {
+		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading PrunedPackets: %v", err.Error()))
+		return
+	}
+	if ack.Sequence < pruned.Egress {
+		sm.res.Code = IBCCodePruned
+		sm.res.Log = "Already acknowledged and pruned"
+		return
+	}
+	if !exists(sm.store, packetKey) {
+		sm.res.Code = IBCCodeUnknownPacket
+		sm.res.Log = "Unknown packet"
+		return
+	}
+	if exists(sm.store, receiptKey) {
+		sm.res.Code = IBCCodePacketAlreadyExists
+		sm.res.Log = "Already acknowledged"
+		return
+	}
+
+	// Make sure the ack was committed on the Dst chain
+	if !sm.verifyProof(tx.FromChainID, tx.FromChainHeight, ackKey, wire.BinaryBytes(ack), tx.Proof) {
+		return
+	}
+
+	// Store the ack, so it is only processed once, and release the escrow
+	save(sm.store, receiptKey, ack)
+	if ack.Success {
+		sm.store.Delete(EscrowKey(ack.SrcChainID, ack.DstChainID, ack.Sequence))
+		return
+	}
+	refunded, err := refundEscrow(sm.store, ack.SrcChainID, ack.DstChainID, ack.Sequence)
+	if err != nil {
+		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading Escrow: %v", err.Error()))
+		return
+	}
+	if refunded {
+		sm.res.Log = "Refunded: " + ack.Log
+	}
+}
+
+func (sm *IBCStateMachine) runPacketTimeoutTx(tx IBCPacketTimeoutTx) {
+	chainID := sm.chainID()
+	if chainID == "" {
+		return
+	}
+	packet, conn := tx.Packet, tx.Connection
+	if packet.SrcChainID != chainID {
+		sm.res = wrsp.ErrBaseInvalidInput.AppendLog(cmn.Fmt("Packet was not sent from %v", chainID))
+		return
+	}
+
+	// We must have sent the packet, and not seen its ack yet
+	pruned, err := GetPrunedPackets(sm.store, packet.DstChainID)
+	if err != nil {
+		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading PrunedPackets: %v", err.Error()))
+		return
+	}
+	if packet.Sequence < pruned.Egress {
+		sm.res.Code = IBCCodePruned
+		sm.res.Log = "Already acknowledged and pruned"
+		return
+	}
+	packetKey := PacketKey(packet.SrcChainID, packet.DstChainID, packet.Sequence)
+	if !bytes.Equal(sm.store.Get(packetKey), wire.BinaryBytes(packet)) {
+		sm.res.Code = IBCCodeUnknownPacket
+		sm.res.Log = "Unknown packet"
+		return
+	}
+	receiptKey := ReceiptKey(packet.SrcChainID, packet.DstChainID, packet.Sequence)
+	if exists(sm.store, receiptKey) {
+		sm.res.Code = IBCCodePacketAlreadyExists
+		sm.res.Log = "Already acknowledged"
+		return
+	}
+
+	// The Dst chain had not received it at the timeout
+	if conn.ChainID != packet.DstChainID || conn.Counterparty != chainID {
+		sm.res.Code = IBCCodeConnectionState
+		sm.res.Log = cmn.Fmt("Expected the connection of %v to %v, got %v to %v",
+			packet.DstChainID, chainID, conn.ChainID, conn.Counterparty)
+		return
+	}
+	if tx.FromChainHeight < packet.TimeoutHeight {
+		sm.res.Code = IBCCodeNotTimedOut
+		sm.res.Log = cmn.Fmt("Packet times out at height %v", packet.TimeoutHeight)
+		return
+	}
+	if conn.NextSequence > packet.Sequence {
+		sm.res.Code = IBCCodePacketAlreadyExists
+		sm.res.Log = "Packet was received"
+		return
+	}
+	connKey := ConnectionKey(conn.ChainID, conn.Counterparty)
+	if !sm.verifyProof(packet.DstChainID, tx.FromChainHeight, connKey, wire.BinaryBytes(conn), tx.Proof) {
+		return
+	}
+
+	// Refund it like a rejected packet. The Dst chain rejects it from the
+	// timeout on, and the receipt keeps us from processing that ack.
+	ack := Acknowledgement{
+		SrcChainID: packet.SrcChainID,
+		DstChainID: packet.DstChainID,
+		Sequence:   packet.Sequence,
+		Log:        cmn.Fmt("Timed out at height %v", packet.TimeoutHeight),
+	}
+	save(sm.store, receiptKey, ack)
+	refunded, err := refundEscrow(sm.store, packet.SrcChainID, packet.DstChainID, packet.Sequence)
+	if err != nil {
+		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading Escrow: %v", err.Error()))
+		return
+	}
+	if refunded {
+		sm.res.Log = "Refunded: " + ack.Log
+	}
+}
+
+func (sm *IBCStateMachine) runConnectionInitTx(tx IBCConnectionInitTx) {
+	chainID := sm.chainID()
+	if chainID == "" || !sm.isAdmin() {
+		return
+	}
+	_, exists, err := GetConnection(sm.store, chainID, tx.Counterparty)
+	if err != nil {
+		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading Connection: %v", err.Error()))
+		return
+	}
+	if exists {
+		sm.res.Code = IBCCodeConnectionState
+		sm.res.Log = "Connection already exists"
+		return
+	}
+	setConnection(sm.store, Connection{
+		ChainID:      chainID,
+		Counterparty: tx.Counterparty,
+		State:        ConnectionInit,
+	})
+}
+
+func (sm *IBCStateMachine) runConnectionTryTx(tx IBCConnectionTryTx) {
+	if !sm.isAdmin() {
+		return
+	}
+	conn, exists, ok := sm.verifyConnection(tx.ConnectionProof, ConnectionInit)
+	if !ok {
+		return
+	}
+	if exists {
+		sm.res.Code = IBCCodeConnectionState
+		sm.res.Log = "Connection already exists"
+		return
+	}
+	conn.State = ConnectionTryOpen
+	setConnection(sm.store, conn)
+}
+
+// runConnectionOpenTx opens our connection in the given state, once the
+// counterparty has proven to be in the state that follows it
+func (sm *IBCStateMachine) runConnectionOpenTx(cp ConnectionProof, ours, theirs string) {
+	conn, exists, ok := sm.verifyConnection(cp, theirs)
+	if !ok {
+		return
+	}
+	if !exists || conn.State != ours {
+		sm.res.Code = IBCCodeConnectionState
+		sm.res.Log = cmn.Fmt("Connection is not %v", ours)
+		return
+	}
+	conn.State = ConnectionOpen
+	setConnection(sm.store, conn)
+}
+
+func (sm *IBCStateMachine) runConnectionCloseTx(tx IBCConnectionCloseTx) {
+	chainID := sm.chainID()
+	if chainID == "" || !sm.isAdmin() {
+		return
+	}
+	conn, exists, err := GetConnection(sm.store, chainID, tx.Counterparty)
+	if err != nil {
+		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading Connection: %v", err.Error()))
+		return
+	}
+	if !exists || conn.State != ConnectionOpen {
+		sm.res.Code = IBCCodeConnectionState
+		sm.res.Log = "Connection is not open"
+		return
+	}
+	conn.State = ConnectionClosed
+	setConnection(sm.store, conn)
+}
+
+// runConnectionCloseConfirmTx closes our end, unless we closed first.
+// The counterparty accepts no packets once it is closed, so we refund
+// the escrow of all packets from the next one it expected.
+func (sm *IBCStateMachine) runConnectionCloseConfirmTx(tx IBCConnectionCloseConfirmTx) {
+	conn, exists, ok := sm.verifyConnection(tx.ConnectionProof, ConnectionClosed)
+	if !ok {
+		return
+	}
+	if !exists || conn.Refunded {
+		sm.res.Code = IBCCodeConnectionState
+		sm.res.Log = "No connection to close"
+		return
+	}
+
+	next := tx.Connection.NextSequence
+	last := GetSequenceNumber(sm.store, conn.ChainID, conn.Counterparty)
+	for seq := next; seq < last; seq++ {
+		// the receipt keeps a late ack from being processed, and lets
+		// the packet be pruned
+		save(sm.store, ReceiptKey(conn.ChainID, conn.Counterparty, seq), Acknowledgement{
+			SrcChainID: conn.ChainID,
+			DstChainID: conn.Counterparty,
+			Sequence:   seq,
+			Log:        "Connection closed",
+		})
+		_, err := refundEscrow(sm.store, conn.ChainID, conn.Counterparty, seq)
+		if err != nil {
+			sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading Escrow: %v", err.Error()))
+			return
+		}
+	}
+	if last > next {
+		sm.res.Log = cmn.Fmt("Refunded packets %v to %v", next, last-1)
+	}
+
+	conn.State = ConnectionClosed
+	conn.Refunded = true
+	setConnection(sm.store, conn)
+}
+
+// isAdmin checks that the caller is the admin, who alone may open
+// and close connections
+func (sm *IBCStateMachine) isAdmin() bool {
+	admin, err := GetAdmin(sm.store)
+	if err != nil {
+		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading Admin: %v", err.Error()))
+		return false
+	}
+	if len(admin) == 0 || !bytes.Equal(admin, sm.ctx.CallerAddress) {
+		sm.res.Code = wrsp.CodeType_Unauthorized
+		sm.res.Log = "Only the admin may open or close connections"
+		return false
+	}
+	return true
+}
+
+// chainID returns our chain id, which every connection needs
+func (sm *IBCStateMachine) chainID() string {
+	chainID := types.GetChainID(sm.store)
+	if chainID == "" {
+		sm.res = wrsp.ErrInternalError.AppendLog("Chain ID is not set")
+	}
+	return chainID
+}
+
+// verifyConnection checks that the counterparty committed its end of the
+// connection in the given state, and returns our end of it.
+// If ours doesn't exist yet, it is initialized for the handshake.
+func (sm *IBCStateMachine) verifyConnection(cp ConnectionProof, state string) (conn Connection, exists bool, ok bool) {
+	chainID := sm.chainID()
+	if chainID == "" {
+		return
+	}
+	theirs := cp.Connection
+	if theirs.Counterparty != chainID || theirs.State != state {
+		sm.res.Code = IBCCodeConnectionState
+		sm.res.Log = cmn.Fmt("Expected counterparty connection to %v in state %v, got %v in state %v",
+			chainID, state, theirs.Counterparty, theirs.State)
+		return
+	}
+	key := ConnectionKey(theirs.ChainID, chainID)
+	if !sm.verifyProof(theirs.ChainID, cp.FromChainHeight, key, wire.BinaryBytes(theirs), cp.Proof) {
+		return
+	}
+
+	conn, exists, err := GetConnection(sm.store, chainID, theirs.ChainID)
+	if err != nil {
+		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading Connection: %v", err.Error()))
+		return
+	}
+	if !exists {
+		conn = Connection{ChainID: chainID, Counterparty: theirs.ChainID}
+	}
+	return conn, exists, true
+}
+
+// verifyProof checks that the value was stored under key on the given
+// chain, in the block at height.
+func (sm *IBCStateMachine) verifyProof(chainID string, height uint64, key, value []byte, proof *merkle.IAVLProof) bool {
+	headerKey := HeaderKey(chainID, height)
+
+	// Nothing is proven by a chain that misbehaved
+	var chainState BlockchainState
+	_, err := load(sm.store, ChainStateKey(chainID), &chainState)
+	if err != nil {
+		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading ChainState: %v", err.Error()))
+		return false
+	}
+	if chainState.Frozen {
+		sm.res.Code = IBCCodeChainFrozen
+		sm.res.Log = "Chain is frozen"
+		return false
+	}
+
+	// Load Header and make sure it exists
+	// If it exists, we already checked a valid commit for it in UpdateChainTx
+	var header tm.Header
+	exists, err := load(sm.store, headerKey, &header)
+	if err != nil {
+		sm.res = wrsp.ErrInternalError.AppendLog(cmn.Fmt("Loading Header: %v", err.Error()))
+		return false
+	}
+	if !exists && height < chainState.PrunedHeight {
+		sm.res.Code = IBCCodePruned
+		sm.res.Log = cmn.Fmt("Loading Header: Height %v was pruned", height)
+		return false
+	}
+	if !exists {
+		sm.res.Code = IBCCodeUnknownHeight
+		sm.res.Log = cmn.Fmt("Loading Header: Unknown height")
+		return false
+	}
 
+	if proof == nil {
+		sm.res.Code = IBCCodeInvalidProof
+		sm.res.Log = "Proof is nil"
+		return false
+	}
+	if !proof.Verify(key, value, header.AppHash) {
+		sm.res.Code = IBCCodeInvalidProof
+		sm.res.Log = fmt.Sprintf("Proof is invalid. key: %s; value %X; header %v; proof %v", key, value, header, proof)
+		return false
+	}
+	return true
+}
+
+func (ibc *IBCPlugin) InitChain(store types.KVStore, vals []*wrsp.Validator) {
+}
+
+// BeginBlock remembers the height, to check packet timeouts
 func (cp *IBCPlugin) BeginBlock(store types.KVStore, hash []byte, header *wrsp.Header) {
+	if header != nil {
+		save(store, toKey(_IBC, _HEIGHT), header.Height)
+	}
 }
 
+// EndBlock prunes the state according to the retention policy.
+// This only fails on corrupt state, then nothing is pruned.
 func (cp *IBCPlugin) EndBlock(store types.KVStore, height uint64) (res wrsp.ResponseEndBlock) {
+	cache := types.NewKVCache(store)
+	err := prune(cache)
+	if err != nil {
+		cp.logger.Error("Pruning failed", "height", height, "error", err.Error())
+		return
+	}
+	cache.Sync()
 	return
 }
 
+//--------------------------------------------------------------------------------
+
+var _ types.GenesisPlugin = (*IBCPlugin)(nil)
+
+// IBCGenesis is the genesis section of the IBC plugin
+type IBCGenesis struct {
+	Chains  []GenesisChain `json:"chains"`
+	Pruning *Pruning       `json:"pruning,omitempty"`
+	Admin   data.Bytes     `json:"admin,omitempty"`  // opens and closes connections
+	Routes  []string       `json:"routes,omitempty"` // plugins other chains may call
+	// Connections are our ends of all connections, with the packets in flight
+	Connections []GenesisConnection `json:"connections,omitempty"`
+}
+
+// GenesisChain registers a chain at genesis
+type GenesisChain struct {
+	ChainID string `json:"chain_id"`
+	Genesis string `json:"genesis"` // the genesis doc json of the chain
+	// State is the latest known state, taken from the genesis if nil
+	State *BlockchainState `json:"state,omitempty"`
+}
+
+// GenesisConnection is our end of a connection, with all that is needed
+// to go on from where it was exported. The copies of incoming packets
+// are not kept, they count as pruned.
+type GenesisConnection struct {
+	Connection Connection  `json:"connection"`
+	Sequence   uint64      `json:"sequence"`         // the number of packets we sent
+	Locked     types.Coins `json:"locked,omitempty"` // our coins the counterparty holds vouchers for
+	// Packets are the packets we sent that were not pruned, in order from Pruned
+	Pruned  uint64          `json:"pruned"`
+	Packets []GenesisPacket `json:"packets,omitempty"`
+	// Acks are the acks of all packets we received, for the counterparty to prove
+	Acks []Acknowledgement `json:"acks,omitempty"`
+}
+
+// GenesisPacket is a packet we sent, with the escrow of its coins until it
+// is acknowledged, and the receipt once the ack was relayed back
+type GenesisPacket struct {
+	Packet  data.Bytes       `json:"packet"` // wire encoded, as in the state
+	Escrow  *Escrow          `json:"escrow,omitempty"`
+	Receipt *Acknowledgement `json:"receipt,omitempty"`
+}
+
+// InitGenesis registers all chains in the genesis section, and sets
+// the retention policy, the admin, the routes and the connections
+func (ibc *IBCPlugin) InitGenesis(store types.KVStore, genesis json.RawMessage) error {
+	var gen IBCGenesis
+	err := json.Unmarshal(genesis, &gen)
+	if err != nil {
+		return err
+	}
+	for _, chain := range gen.Chains {
+		res := saveNewChain(store, BlockchainGenesis{chain.ChainID, chain.Genesis})
+		if res.IsErr() {
+			return fmt.Errorf("chain %s: %s", chain.ChainID, res.Log)
+		}
+		if chain.State != nil {
+			if chain.State.ChainID != chain.ChainID {
+				return fmt.Errorf("chain %s: state for %s", chain.ChainID, chain.State.ChainID)
+			}
+			save(store, ChainStateKey(chain.ChainID), *chain.State)
+		}
+	}
+	if gen.Pruning != nil {
+		setPruning(store, *gen.Pruning)
+	}
+	if len(gen.Admin) > 0 {
+		setAdmin(store, gen.Admin)
+	}
+	for _, plugin := range gen.Routes {
+		if plugin == "" {
+			return fmt.Errorf("route to no plugin")
+		}
+		setRouted(store, plugin)
+	}
+	for _, conn := range gen.Connections {
+		err = initConnection(store, conn)
+		if err != nil {
+			return fmt.Errorf("connection to %s: %v", conn.Connection.Counterparty, err)
+		}
+	}
+	return nil
+}
+
+// initConnection restores a connection from the genesis
+func initConnection(store types.KVStore, gen GenesisConnection) error {
+	conn := gen.Connection
+	ours, theirs := conn.ChainID, conn.Counterparty
+	if ours == "" || theirs == "" {
+		return fmt.Errorf("missing chain id")
+	}
+	if gen.Pruned+uint64(len(gen.Packets)) != gen.Sequence {
+		return fmt.Errorf("expected the packets from %d to %d", gen.Pruned, gen.Sequence)
+	}
+	setConnection(store, conn)
+	SetSequenceNumber(store, ours, theirs, gen.Sequence)
+	if len(gen.Locked) > 0 {
+		setLockedCoins(store, theirs, gen.Locked)
+	}
+	for i, gp := range gen.Packets {
+		seq := gen.Pruned + uint64(i)
+		var packet Packet
+		err := wire.ReadBinaryBytes(gp.Packet, &packet)
+		if err != nil {
+			return fmt.Errorf("packet %d: %v", seq, err)
+		}
+		if packet.SrcChainID != ours || packet.DstChainID != theirs || packet.Sequence != seq {
+			return fmt.Errorf("packet %d: not on this connection", seq)
+		}
+		store.Set(PacketKey(ours, theirs, seq), gp.Packet)
+		if gp.Escrow != nil {
+			save(store, EscrowKey(ours, theirs, seq), *gp.Escrow)
+		}
+		if gp.Receipt != nil {
+			save(store, ReceiptKey(ours, theirs, seq), *gp.Receipt)
+		}
+	}
+	for _, ack := range gen.Acks {
+		if ack.SrcChainID != theirs || ack.DstChainID != ours || ack.Sequence >= conn.NextSequence {
+			return fmt.Errorf("ack %d: not on this connection", ack.Sequence)
+		}
+		save(store, AckKey(theirs, ours, ack.Sequence), ack)
+	}
+	save(store, PrunedPacketsKey(theirs), PrunedPackets{Egress: gen.Pruned, Ingress: conn.NextSequence})
+	return nil
+}
+
+// exportConnection returns the connection with the packets we sent that
+// were not pruned, and the acks of all packets we received
+func exportConnection(store types.KVStore, conn Connection) (gen GenesisConnection, err error) {
+	ours, theirs := conn.ChainID, conn.Counterparty
+	pruned, err := GetPrunedPackets(store, theirs)
+	if err != nil {
+		return gen, err
+	}
+	gen.Connection = conn
+	gen.Sequence = GetSequenceNumber(store, ours, theirs)
+	gen.Locked = GetLockedCoins(store, theirs)
+	gen.Pruned = pruned.Egress
+	for seq := pruned.Egress; seq < gen.Sequence; seq++ {
+		gp := GenesisPacket{Packet: store.Get(PacketKey(ours, theirs, seq))}
+		var escrow Escrow
+		found, err := load(store, EscrowKey(ours, theirs, seq), &escrow)
+		if err != nil {
+			return gen, err
+		}
+		if found {
+			gp.Escrow = &escrow
+		}
+		var receipt Acknowledgement
+		found, err = load(store, ReceiptKey(ours, theirs, seq), &receipt)
+		if err != nil {
+			return gen, err
+		}
+		if found {
+			gp.Receipt = &receipt
+		}
+		gen.Packets = append(gen.Packets, gp)
+	}
+	for seq := uint64(0); seq < conn.NextSequence; seq++ {
+		ack, found, err := GetAcknowledgement(store, theirs, ours, seq)
+		if err != nil {
+			return gen, err
+		}
+		if found {
+			gen.Acks = append(gen.Acks, ack)
+		}
+	}
+	return gen, nil
+}
+
+// ExportGenesis returns all registered chains with their latest state,
+// the retention policy, the admin, the routes and the connections
+func (ibc *IBCPlugin) ExportGenesis(store types.KVStore) (json.RawMessage, error) {
+	var gen IBCGenesis
+	pruning, err := GetPruning(store)
+	if err != nil {
+		return nil, err
+	}
+	if pruning != (Pruning{}) {
+		gen.Pruning = &pruning
+	}
+	gen.Admin, err = GetAdmin(store)
+	if err != nil {
+		return nil, err
+	}
+	gen.Routes, err = getRoutes(store)
+	if err != nil {
+		return nil, err
+	}
+	prefix := append(toKey(_IBC, _BLOCKCHAIN, _GENESIS), ',')
+	for it := types.PrefixIterator(store, prefix); it.Valid(); it.Next() {
+		var chainGen BlockchainGenesis
+		err := wire.ReadBinaryBytes(it.Value(), &chainGen)
+		if err != nil {
+			return nil, err
+		}
+		var chainState BlockchainState
+		_, err = load(store, ChainStateKey(chainGen.ChainID), &chainState)
+		if err != nil {
+			return nil, err
+		}
+		gen.Chains = append(gen.Chains, GenesisChain{
+			ChainID: chainGen.ChainID,
+			Genesis: chainGen.Genesis,
+			State:   &chainState,
+		})
+	}
+	prefix = append(toKey(_IBC, _CONNECTION), ',')
+	for it := types.PrefixIterator(store, prefix); it.Valid(); it.Next() {
+		var conn Connection
+		err := wire.ReadBinaryBytes(it.Value(), &conn)
+		if err != nil {
+			return nil, err
+		}
+		genConn, err := exportConnection(store, conn)
+		if err != nil {
+			return nil, err
+		}
+		gen.Connections = append(gen.Connections, genConn)
+	}
+	return json.Marshal(gen)
+}
+
 //--------------------------------------------------------------------------------
 // TODO: move to utils
 
@@ -550,10 +1862,8 @@ func toKey(parts ...string) []byte {
 	return []byte(strings.Join(escParts, ","))
 }
 
-// NOTE: Commit's votes include ValidatorAddress, so can be matched up
-// against chainState.Validators, even if the validator set had changed.
-// For the purpose of the demo, we assume that the validator set hadn't changed,
-// though we should check that explicitly.
+// NOTE: This only verifies commits of chainState.Validators.
+// Changes of the validator set are checked by verifyValidatorChange.
 func verifyCommit(chainState BlockchainState, header *tm.Header, commit *tm.Commit) error {
 
 	// Ensure that chainState and header ChainID match.
@@ -598,3 +1908,51 @@ func verifyCommit(chainState BlockchainState, header *tm.Header, commit *tm.Comm
 	// All ok!
 	return nil
 }
+
+// verifyValidatorChange checks that the header was committed by the new
+// validators, and that more than 2/3 of the voting power of the validators
+// we trust signed it, too.
+func verifyValidatorChange(chainState BlockchainState, header *tm.Header, commit *tm.Commit, nextVals []*tm.Validator) error {
+	if uint64(header.Height) <= chainState.LastBlockHeight {
+		return errors.New(cmn.Fmt("Validators can only change after height %v, got %v",
+			chainState.LastBlockHeight, header.Height))
+	}
+	nextValSet := tm.NewValidatorSet(nextVals)
+	if !bytes.Equal(header.ValidatorsHash, nextValSet.Hash()) {
+		return errors.New(cmn.Fmt("header.ValidatorsHash (%X) does not match NextValidators (%X)",
+			header.ValidatorsHash, nextValSet.Hash()))
+	}
+
+	// the new validators committed the header
+	nextState := chainState
+	nextState.Validators = nextVals
+	err := verifyCommit(nextState, header, commit)
+	if err != nil {
+		return err
+	}
+
+	// and enough of the old ones signed it
+	valSet := tm.NewValidatorSet(chainState.Validators)
+	blockHash := header.Hash()
+	signed := int64(0)
+	seen := make(map[string]bool)
+	for _, pc := range commit.Precommits {
+		if pc == nil || !bytes.Equal(pc.BlockID.Hash, blockHash) || seen[string(pc.ValidatorAddress)] {
+			continue
+		}
+		seen[string(pc.ValidatorAddress)] = true
+		_, val := valSet.GetByAddress(pc.ValidatorAddress)
+		if val == nil {
+			continue
+		}
+		if !val.PubKey.VerifyBytes(tm.SignBytes(header.ChainID, pc), pc.Signature) {
+			return errors.New(cmn.Fmt("Invalid signature of %X", pc.ValidatorAddress))
+		}
+		signed += val.VotingPower
+	}
+	if signed*3 <= valSet.TotalVotingPower()*2 {
+		return errors.New(cmn.Fmt("Only %v of %v trusted voting power signed the change",
+			signed, valSet.TotalVotingPower()))
+	}
+	return nil
+}
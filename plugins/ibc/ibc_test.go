@@ -2,6 +2,7 @@ package ibc
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"sort"
 	"strings"
@@ -13,10 +14,13 @@ import (
 	wrsp "github.com/tepleton/wrsp/types"
 	crypto "github.com/tepleton/go-crypto"
 	"github.com/tepleton/go-wire"
+	"github.com/tepleton/go-wire/data"
 	eyes "github.com/tepleton/merkleeyes/client"
 	"github.com/tepleton/merkleeyes/iavl"
 	cmn "github.com/tepleton/tmlibs/common"
 
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/txs"
 	"github.com/tepleton/basecoin/types"
 	tm "github.com/tepleton/tepleton/types"
 )
@@ -102,7 +106,7 @@ var testGenesisDoc = `{
 
 func TestIBCGenesisFromString(t *testing.T) {
 	eyesClient := eyes.NewLocalClient("", 0)
-	store := types.NewKVCache(eyesClient)
+	store := types.NewKVCache(types.NewEyesStore(eyesClient))
 	store.SetLogging() // Log all activity
 
 	ibcPlugin := New()
@@ -111,13 +115,145 @@ func TestIBCGenesisFromString(t *testing.T) {
 	registerChain(t, ibcPlugin, store, ctx, "test_chain", testGenesisDoc)
 }
 
+func TestIBCInitExportGenesis(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+
+	store := types.NewMemKVStore()
+	ibcPlugin := New()
+
+	genDoc_1, _ := genGenesisDoc("test_chain_1", 4)
+	genDocJSON_1, err := json.Marshal(genDoc_1)
+	require.Nil(err)
+	genDoc_2, _ := genGenesisDoc("test_chain_2", 2)
+	genDocJSON_2, err := json.Marshal(genDoc_2)
+	require.Nil(err)
+	gen := IBCGenesis{
+		Chains: []GenesisChain{
+			{ChainID: "test_chain_1", Genesis: string(genDocJSON_1)},
+			{ChainID: "test_chain_2", Genesis: string(genDocJSON_2)},
+		},
+		Admin:  []byte("admin"),
+		Routes: []string{"caller", "counter"},
+	}
+	bz, err := json.Marshal(gen)
+	require.Nil(err)
+	err = ibcPlugin.InitGenesis(store, bz)
+	require.Nil(err, "%+v", err)
+
+	// the chains are registered, with the state from the genesis
+	var chainState BlockchainState
+	exists, err := load(store, toKey(_IBC, _BLOCKCHAIN, _STATE, "test_chain_1"), &chainState)
+	require.True(exists)
+	require.Nil(err)
+	assert.Equal(4, len(chainState.Validators))
+	chainIDs, err := GetChainIDs(store)
+	require.Nil(err)
+	assert.Equal([]string{"test_chain_1", "test_chain_2"}, chainIDs)
+	admin, err := GetAdmin(store)
+	require.Nil(err)
+	assert.Equal([]byte("admin"), admin)
+	assert.True(IsRouted(store, "caller"))
+	assert.False(IsRouted(store, "other"))
+
+	// export and init again gives the same state
+	exported, err := ibcPlugin.ExportGenesis(store)
+	require.Nil(err, "%+v", err)
+	store2 := types.NewMemKVStore()
+	err = ibcPlugin.InitGenesis(store2, exported)
+	require.Nil(err, "%+v", err)
+	for _, chainID := range []string{"test_chain_1", "test_chain_2"} {
+		for _, key := range [][]byte{
+			toKey(_IBC, _BLOCKCHAIN, _GENESIS, chainID),
+			toKey(_IBC, _BLOCKCHAIN, _STATE, chainID),
+			ChainListKey(),
+			AdminKey(),
+			RouteKey("caller"),
+			RouteKey("counter"),
+		} {
+			assert.Equal(store.Get(key), store2.Get(key), chainID)
+		}
+	}
+
+	// registering a chain twice fails
+	err = ibcPlugin.InitGenesis(store2, exported)
+	assert.NotNil(err)
+}
+
+func TestIBCExportConnection(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+
+	ibcPlugin := New()
+	ctx := types.NewCallContext(nil, nil, types.Coins{})
+	sender := types.NewCallContext([]byte("sender"), nil, types.Coins{{"mycoin", 20}})
+	store := types.NewKVCache(types.NewMemKVStore())
+	types.SetChainID(store, "chain_a")
+	genDoc, _ := genGenesisDoc("chain_b", 4)
+	genDocJSON, err := json.Marshal(genDoc)
+	require.Nil(err)
+	registerChain(t, ibcPlugin, store, ctx, "chain_b", string(genDocJSON))
+	openConnection(store, "chain_a", "chain_b")
+
+	// two packets with coins in escrow, one was received from chain_b
+	for seq := uint64(0); seq < 2; seq++ {
+		packet := NewPacket("chain_a", "chain_b", seq, CoinsPayload{[]byte("receiver"), types.Coins{{"mycoin", 10}}})
+		res := ibcPlugin.RunTx(store, sender, wire.BinaryBytes(struct{ IBCTx }{IBCPacketCreateTx{packet}}))
+		assertAndLog(t, store, res, wrsp.CodeType_OK)
+	}
+	conn, _, err := GetConnection(store, "chain_a", "chain_b")
+	require.Nil(err)
+	conn.NextSequence = 1
+	setConnection(store, conn)
+	save(store, AckKey("chain_b", "chain_a", 0), Acknowledgement{"chain_b", "chain_a", 0, true, "", nil})
+
+	// export and init again keeps the connection and everything in flight
+	exported, err := ibcPlugin.ExportGenesis(store)
+	require.Nil(err, "%+v", err)
+	store2 := types.NewMemKVStore()
+	err = ibcPlugin.InitGenesis(store2, exported)
+	require.Nil(err, "%+v", err)
+	for _, key := range [][]byte{
+		ConnectionKey("chain_a", "chain_b"),
+		SequenceKey("chain_a", "chain_b"),
+		PacketKey("chain_a", "chain_b", 0),
+		PacketKey("chain_a", "chain_b", 1),
+		EscrowKey("chain_a", "chain_b", 0),
+		EscrowKey("chain_a", "chain_b", 1),
+		AckKey("chain_b", "chain_a", 0),
+		toKey(_IBC, _LOCKED, "chain_b"),
+	} {
+		require.NotNil(store.Get(key), "%s", key)
+		assert.Equal(store.Get(key), store2.Get(key), "%s", key)
+	}
+	pruned, err := GetPrunedPackets(store2, "chain_b")
+	require.Nil(err)
+	assert.Equal(PrunedPackets{Egress: 0, Ingress: 1}, pruned)
+
+	// the escrow can still be refunded
+	refunded, err := refundEscrow(store2, "chain_a", "chain_b", 1)
+	require.Nil(err)
+	assert.True(refunded)
+	acc := types.GetAccount(store2, sender.CallerAddress)
+	require.NotNil(acc)
+	assert.Equal(types.Coins{{"mycoin", 10}}, acc.Balance)
+	assert.Equal(types.Coins{{"mycoin", 10}}, GetLockedCoins(store2, "chain_b"))
+
+	// packets that don't belong to the connection are rejected
+	var gen IBCGenesis
+	require.Nil(json.Unmarshal(exported, &gen))
+	gen.Chains = nil
+	gen.Connections[0].Packets = gen.Connections[0].Packets[1:]
+	bz, err := json.Marshal(gen)
+	require.Nil(err)
+	assert.NotNil(ibcPlugin.InitGenesis(types.NewMemKVStore(), bz))
+}
+
 //--------------------------------------------------------------------------------
 
 func TestIBCPluginRegister(t *testing.T) {
 	require := require.New(t)
 
 	eyesClient := eyes.NewLocalClient("", 0)
-	store := types.NewKVCache(eyesClient)
+	store := types.NewKVCache(types.NewEyesStore(eyesClient))
 	store.SetLogging() // Log all activity
 
 	ibcPlugin := New()
@@ -154,8 +290,9 @@ func TestIBCPluginPost(t *testing.T) {
 	require := require.New(t)
 
 	eyesClient := eyes.NewLocalClient("", 0)
-	store := types.NewKVCache(eyesClient)
+	store := types.NewKVCache(types.NewEyesStore(eyesClient))
 	store.SetLogging() // Log all activity
+	types.SetChainID(store, "test_chain")
 
 	ibcPlugin := New()
 	ctx := types.NewCallContext(nil, nil, types.Coins{})
@@ -187,8 +324,9 @@ func TestIBCPluginPayloadBytes(t *testing.T) {
 	require := require.New(t)
 
 	eyesClient := eyes.NewLocalClient("", 0)
-	store := types.NewKVCache(eyesClient)
+	store := types.NewKVCache(types.NewEyesStore(eyesClient))
 	store.SetLogging() // Log all activity
+	types.SetChainID(store, "test_chain")
 
 	ibcPlugin := New()
 	ctx := types.NewCallContext(nil, nil, types.Coins{})
@@ -241,6 +379,9 @@ func TestIBCPluginPayloadBytes(t *testing.T) {
 	assert.Nil(err)
 
 	// Post a packet
+	// loop back, and receive the packet as the dst chain
+	types.SetChainID(store, "dst_chain")
+	openConnection(store, "dst_chain", "test_chain")
 	res = ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{IBCPacketPostTx{
 		FromChainID:     "test_chain",
 		FromChainHeight: 999,
@@ -255,8 +396,9 @@ func TestIBCPluginPayloadCoins(t *testing.T) {
 	require := require.New(t)
 
 	eyesClient := eyes.NewLocalClient("", 0)
-	store := types.NewKVCache(eyesClient)
+	store := types.NewKVCache(types.NewEyesStore(eyesClient))
 	store.SetLogging() // Log all activity
+	types.SetChainID(store, "test_chain")
 
 	ibcPlugin := New()
 	coins := types.Coins{
@@ -337,6 +479,9 @@ func TestIBCPluginPayloadCoins(t *testing.T) {
 	assert.Nil(acc)
 
 	// Post a packet
+	// loop back, and receive the packet as the dst chain
+	types.SetChainID(store, "dst_chain")
+	openConnection(store, "dst_chain", "test_chain")
 	res = ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{IBCPacketPostTx{
 		FromChainID:     "test_chain",
 		FromChainHeight: 999,
@@ -345,16 +490,841 @@ func TestIBCPluginPayloadCoins(t *testing.T) {
 	}}))
 	assertAndLog(t, store, res, wrsp.CodeType_OK)
 
-	// Account should now have some coins
+	// Account should now have some vouchers
 	acc = types.GetAccount(store, destinationAddr)
-	assert.Equal(acc.Balance, coinsGood)
+	assert.Equal(types.Coins{{"ibc/test_chain/mycoin", 1}}, acc.Balance)
+	assert.Equal(coinsGood, GetLockedCoins(store, "dst_chain"))
+}
+
+func TestIBCPluginAck(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	eyesClient := eyes.NewLocalClient("", 0)
+	store := types.NewKVCache(types.NewEyesStore(eyesClient))
+	store.SetLogging() // Log all activity
+	types.SetChainID(store, "test_chain")
+
+	ibcPlugin := New()
+	sender := []byte("sender address")
+	receiver := []byte("receiver address")
+	ctx := types.NewCallContext(sender, nil, types.Coins{{"mycoin", 100}})
+
+	// Register both sides of the connection, as we loop back to ourselves
+	genDoc_1, privAccs_1 := genGenesisDoc("test_chain", 4)
+	genDocJSON_1, err := json.Marshal(genDoc_1)
+	require.Nil(err)
+	registerChain(t, ibcPlugin, store, ctx, "test_chain", string(genDocJSON_1))
+	genDoc_2, privAccs_2 := genGenesisDoc("dst_chain", 4)
+	genDocJSON_2, err := json.Marshal(genDoc_2)
+	require.Nil(err)
+	registerChain(t, ibcPlugin, store, ctx, "dst_chain", string(genDocJSON_2))
+
+	// Send coins with a packet that arrives in time, and one that times out
+	coins := types.Coins{{"mycoin", 7}}
+	packets := []Packet{
+		NewPacket("test_chain", "dst_chain", 0, CoinsPayload{receiver, coins}),
+		NewPacket("test_chain", "dst_chain", 1, CoinsPayload{receiver, coins}),
+	}
+	packets[0].TimeoutHeight = 100
+	packets[1].TimeoutHeight = 5
+	for _, packet := range packets {
+		res := ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{IBCPacketCreateTx{
+			Packet: packet,
+		}}))
+		assertAndLog(t, store, res, wrsp.CodeType_OK)
+	}
+
+	// Receive them at height 10, as the dst chain
+	types.SetChainID(store, "dst_chain")
+	openConnection(store, "dst_chain", "test_chain")
+	ibcPlugin.BeginBlock(store, nil, &wrsp.Header{Height: 10})
+	commitAndUpdate(t, ibcPlugin, store, eyesClient, privAccs_1, "test_chain", 999)
+	for _, packet := range packets {
+		packetKey := toKey(_IBC, _EGRESS, packet.SrcChainID, packet.DstChainID, cmn.Fmt("%v", packet.Sequence))
+		res := ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{IBCPacketPostTx{
+			FromChainID:     "test_chain",
+			FromChainHeight: 999,
+			Packet:          packet,
+			Proof:           getProof(t, eyesClient, packetKey),
+		}}))
+		assertAndLog(t, store, res, wrsp.CodeType_OK)
+	}
+	acc := types.GetAccount(store, receiver)
+	require.NotNil(acc)
+	assert.Equal(types.Coins{{"ibc/test_chain/mycoin", 7}}, acc.Balance)
+
+	// Relay the acks back, only the timed out packet is refunded
+	types.SetChainID(store, "test_chain")
+	commitAndUpdate(t, ibcPlugin, store, eyesClient, privAccs_2, "dst_chain", 1000)
+	for i, packet := range packets {
+		ack, exists, err := GetAcknowledgement(store, packet.SrcChainID, packet.DstChainID, packet.Sequence)
+		require.Nil(err)
+		require.True(exists)
+		assert.Equal(i == 0, ack.Success, ack.Log)
+
+		ackKey := toKey(_IBC, _ACK, packet.SrcChainID, packet.DstChainID, cmn.Fmt("%v", packet.Sequence))
+		ackTx := IBCPacketAckTx{
+			FromChainID:     "dst_chain",
+			FromChainHeight: 1000,
+			Acknowledgement: ack,
+			Proof:           getProof(t, eyesClient, ackKey),
+		}
+		res := ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{ackTx}))
+		assertAndLog(t, store, res, wrsp.CodeType_OK)
+
+		// but only once
+		res = ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{ackTx}))
+		assertAndLog(t, store, res, IBCCodePacketAlreadyExists)
+	}
+	acc = types.GetAccount(store, sender)
+	require.NotNil(acc)
+	assert.Equal(coins, acc.Balance)
+	assert.Equal(coins, GetLockedCoins(store, "dst_chain"))
+
+	// a forged ack is not accepted
+	forged := IBCPacketAckTx{
+		FromChainID:     "dst_chain",
+		FromChainHeight: 1000,
+		Acknowledgement: Acknowledgement{"test_chain", "dst_chain", 5, false, "forged"},
+	}
+	res := ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{forged}))
+	assertAndLog(t, store, res, IBCCodeUnknownPacket)
+}
+
+func TestIBCConnection(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ibcPlugin := New()
+	admin := types.NewCallContext([]byte("admin"), nil, types.Coins{})
+	other := types.NewCallContext([]byte("other"), nil, types.Coins{})
+
+	// Two chains, that know each other
+	clientA, clientB := eyes.NewLocalClient("", 0), eyes.NewLocalClient("", 0)
+	storeA := types.NewKVCache(types.NewEyesStore(clientA))
+	storeB := types.NewKVCache(types.NewEyesStore(clientB))
+	types.SetChainID(storeA, "chain_a")
+	types.SetChainID(storeB, "chain_b")
+	assert.NotEqual("Success", ibcPlugin.SetOption(storeA, "admin", "not hex"))
+	assert.Equal("Success", ibcPlugin.SetOption(storeA, "admin", hex.EncodeToString(admin.CallerAddress)))
+	assert.Equal("Success", ibcPlugin.SetOption(storeB, "admin", hex.EncodeToString(admin.CallerAddress)))
+	genDocA, privAccsA := genGenesisDoc("chain_a", 4)
+	genDocJSONA, err := json.Marshal(genDocA)
+	require.Nil(err)
+	genDocB, privAccsB := genGenesisDoc("chain_b", 4)
+	genDocJSONB, err := json.Marshal(genDocB)
+	require.Nil(err)
+	registerChain(t, ibcPlugin, storeA, admin, "chain_b", string(genDocJSONB))
+	registerChain(t, ibcPlugin, storeB, admin, "chain_a", string(genDocJSONA))
+
+	runTx := func(store *types.KVCache, ctx types.CallContext, tx IBCTx, code wrsp.CodeType) {
+		res := ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{tx}))
+		assertAndLog(t, store, res, code)
+	}
+	assertState := func(store types.KVStore, chainID, counterparty, state string) {
+		conn, exists, err := GetConnection(store, chainID, counterparty)
+		require.Nil(err)
+		require.True(exists)
+		assert.Equal(state, conn.State, chainID)
+	}
+
+	// only the admin inits on A, and twice is not allowed
+	runTx(storeA, other, IBCConnectionInitTx{"chain_b"}, wrsp.CodeType_Unauthorized)
+	runTx(storeA, admin, IBCConnectionInitTx{"chain_b"}, wrsp.CodeType_OK)
+	runTx(storeA, admin, IBCConnectionInitTx{"chain_b"}, IBCCodeConnectionState)
+	assertState(storeA, "chain_a", "chain_b", ConnectionInit)
+
+	// the admin tries on B, with a proof of A
+	commitAndRelay(t, ibcPlugin, storeA, clientA, storeB, privAccsA, "chain_a", 1)
+	proof := connectionProof(t, storeA, clientA, "chain_a", "chain_b", 1)
+	forged := proof
+	forged.Connection.NextSequence = 5
+	runTx(storeB, admin, IBCConnectionTryTx{forged}, IBCCodeInvalidProof)
+	runTx(storeB, admin, IBCConnectionConfirmTx{proof}, IBCCodeConnectionState)
+	runTx(storeB, other, IBCConnectionTryTx{proof}, wrsp.CodeType_Unauthorized)
+	runTx(storeB, admin, IBCConnectionTryTx{proof}, wrsp.CodeType_OK)
+	assertState(storeB, "chain_b", "chain_a", ConnectionTryOpen)
+
+	// anyone acks on A, with a proof of B
+	commitAndRelay(t, ibcPlugin, storeB, clientB, storeA, privAccsB, "chain_b", 1)
+	proof = connectionProof(t, storeB, clientB, "chain_b", "chain_a", 1)
+	runTx(storeA, other, IBCConnectionAckTx{proof}, wrsp.CodeType_OK)
+	assertState(storeA, "chain_a", "chain_b", ConnectionOpen)
+
+	// confirm on B, with a proof of A
+	commitAndRelay(t, ibcPlugin, storeA, clientA, storeB, privAccsA, "chain_a", 2)
+	proof = connectionProof(t, storeA, clientA, "chain_a", "chain_b", 2)
+	runTx(storeB, admin, IBCConnectionConfirmTx{proof}, wrsp.CodeType_OK)
+	assertState(storeB, "chain_b", "chain_a", ConnectionOpen)
+
+	// packets are sent in order, and received in order
+	packets := make([]Packet, 3)
+	for i := range packets {
+		packets[i] = NewPacket("chain_a", "chain_b", uint64(i), DataPayload([]byte("hello")))
+		runTx(storeA, admin, IBCPacketCreateTx{packets[i]}, wrsp.CodeType_OK)
+	}
+	// the last one escrows coins, that are never received
+	sender := types.NewCallContext([]byte("sender"), nil, types.Coins{{"mycoin", 10}})
+	lost := NewPacket("chain_a", "chain_b", 3, CoinsPayload{[]byte("receiver"), types.Coins{{"mycoin", 10}}})
+	runTx(storeA, sender, IBCPacketCreateTx{lost}, wrsp.CodeType_OK)
+	gap := NewPacket("chain_a", "chain_b", 5, DataPayload([]byte("gap")))
+	runTx(storeA, admin, IBCPacketCreateTx{gap}, IBCCodePacketOutOfOrder)
+
+	commitAndRelay(t, ibcPlugin, storeA, clientA, storeB, privAccsA, "chain_a", 3)
+	postTx := func(packet Packet) IBCPacketPostTx {
+		packetKey := toKey(_IBC, _EGRESS, packet.SrcChainID, packet.DstChainID, cmn.Fmt("%v", packet.Sequence))
+		return IBCPacketPostTx{
+			FromChainID:     "chain_a",
+			FromChainHeight: 3,
+			Packet:          packet,
+			Proof:           getProof(t, clientA, packetKey),
+		}
+	}
+	runTx(storeB, other, postTx(packets[1]), IBCCodePacketOutOfOrder)
+	runTx(storeB, other, postTx(packets[0]), wrsp.CodeType_OK)
+	runTx(storeB, other, postTx(packets[0]), IBCCodePacketAlreadyExists)
+	runTx(storeB, other, postTx(packets[1]), wrsp.CodeType_OK)
+
+	// only the admin can close, and then no more packets are sent
+	runTx(storeA, other, IBCConnectionCloseTx{"chain_b"}, wrsp.CodeType_Unauthorized)
+	runTx(storeA, admin, IBCConnectionCloseTx{"chain_b"}, wrsp.CodeType_OK)
+	assertState(storeA, "chain_a", "chain_b", ConnectionClosed)
+	next := NewPacket("chain_a", "chain_b", 4, DataPayload([]byte("late")))
+	runTx(storeA, admin, IBCPacketCreateTx{next}, IBCCodeConnectionState)
+
+	// B follows, and no more packets are received
+	commitAndRelay(t, ibcPlugin, storeA, clientA, storeB, privAccsA, "chain_a", 4)
+	proof = connectionProof(t, storeA, clientA, "chain_a", "chain_b", 4)
+	runTx(storeB, other, IBCConnectionCloseConfirmTx{proof}, wrsp.CodeType_OK)
+	assertState(storeB, "chain_b", "chain_a", ConnectionClosed)
+	runTx(storeB, other, postTx(packets[2]), IBCCodeConnectionState)
+	runTx(storeB, other, IBCConnectionCloseConfirmTx{proof}, IBCCodeConnectionState)
+
+	// A learns that B closed, and refunds what B never received
+	assert.Nil(types.GetAccount(storeA, sender.CallerAddress))
+	commitAndRelay(t, ibcPlugin, storeB, clientB, storeA, privAccsB, "chain_b", 2)
+	proof = connectionProof(t, storeB, clientB, "chain_b", "chain_a", 2)
+	runTx(storeA, other, IBCConnectionCloseConfirmTx{proof}, wrsp.CodeType_OK)
+	acc := types.GetAccount(storeA, sender.CallerAddress)
+	require.NotNil(acc)
+	assert.Equal(types.Coins{{"mycoin", 10}}, acc.Balance)
+	assert.True(GetLockedCoins(storeA, "chain_b").IsZero())
+	for seq := uint64(2); seq <= 3; seq++ {
+		assert.True(exists(storeA, ReceiptKey("chain_a", "chain_b", seq)), "%d", seq)
+	}
+	assert.False(exists(storeA, ReceiptKey("chain_a", "chain_b", 1)))
+	runTx(storeA, other, IBCConnectionCloseConfirmTx{proof}, IBCCodeConnectionState)
+}
+
+func TestIBCValidatorChange(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	eyesClient := eyes.NewLocalClient("", 0)
+	store := types.NewKVCache(types.NewEyesStore(eyesClient))
+	store.SetLogging() // Log all activity
+
+	ibcPlugin := New()
+	ctx := types.NewCallContext(nil, nil, types.Coins{})
+
+	genDoc, privAccs := genGenesisDoc("test_chain", 4)
+	genDocJSON, err := json.Marshal(genDoc)
+	require.Nil(err)
+	registerChain(t, ibcPlugin, store, ctx, "test_chain", string(genDocJSON))
+
+	// replace some of the validators with new ones
+	rotate := func(n int) []types.PrivAccount {
+		accs := append([]types.PrivAccount{}, privAccs[n:]...)
+		for i := 0; i < n; i++ {
+			accs = append(accs, types.PrivAccountFromSecret(cmn.Fmt("test_chain_new_%v", i)))
+		}
+		sort.Sort(PrivAccountsByAddress(accs))
+		return accs
+	}
+	update := func(accs []types.PrivAccount, height int, change bool) wrsp.Result {
+		var vals []*tm.Validator
+		valHash := []byte("must_exist")
+		if change {
+			vals = validators(accs)
+			valHash = tm.NewValidatorSet(vals).Hash()
+		}
+		header := newHeader("test_chain", height, nil, valHash)
+		return ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{IBCUpdateChainTx{
+			Header:         header,
+			Commit:         constructCommit(accs, header),
+			NextValidators: vals,
+		}}))
+	}
+
+	// the new set must sign, but not on its own
+	oneNew, twoNew := rotate(1), rotate(2)
+	res := update(oneNew, 10, false)
+	assertAndLog(t, store, res, IBCCodeInvalidCommit)
+	res = update(twoNew, 10, true)
+	assertAndLog(t, store, res, IBCCodeInvalidCommit)
+
+	// 3 of 4 old validators are enough to trust the change
+	res = update(oneNew, 10, true)
+	assertAndLog(t, store, res, wrsp.CodeType_OK)
+	var chainState BlockchainState
+	_, err = load(store, toKey(_IBC, _BLOCKCHAIN, _STATE, "test_chain"), &chainState)
+	require.Nil(err)
+	assert.Equal(validators(oneNew), chainState.Validators)
+
+	// but not to change it again at the same height
+	res = update(rotate(1), 10, true)
+	assertAndLog(t, store, res, IBCCodeInvalidCommit)
+
+	// later headers verify against the new set only
+	res = update(privAccs, 20, false)
+	assertAndLog(t, store, res, IBCCodeInvalidCommit)
+	res = update(oneNew, 20, false)
+	assertAndLog(t, store, res, wrsp.CodeType_OK)
+}
+
+func TestIBCMisbehaviour(t *testing.T) {
+	require := require.New(t)
+
+	eyesClient := eyes.NewLocalClient("", 0)
+	store := types.NewKVCache(types.NewEyesStore(eyesClient))
+	store.SetLogging() // Log all activity
+	types.SetChainID(store, "test_chain")
+
+	ibcPlugin := New()
+	ctx := types.NewCallContext(nil, nil, types.Coins{})
+
+	genDoc, privAccs := genGenesisDoc("test_chain", 4)
+	genDocJSON, err := json.Marshal(genDoc)
+	require.Nil(err)
+	registerChain(t, ibcPlugin, store, ctx, "test_chain", string(genDocJSON))
+	openConnection(store, "dst_chain", "test_chain")
+
+	packet := NewPacket("test_chain", "dst_chain", 0, DataPayload([]byte("hello world")))
+	res := ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{IBCPacketCreateTx{
+		Packet: packet,
+	}}))
+	assertAndLog(t, store, res, wrsp.CodeType_OK)
+	commitAndUpdate(t, ibcPlugin, store, eyesClient, privAccs, "test_chain", 999)
+
+	// the validators sign another header at the same height
+	header1 := newHeader("test_chain", 10, []byte("one"), []byte("must_exist"))
+	header2 := newHeader("test_chain", 10, []byte("two"), []byte("must_exist"))
+	other := newHeader("test_chain", 11, []byte("two"), []byte("must_exist"))
+	_, otherAccs := genGenesisDoc("test_chain_fake", 4)
+	cases := []struct {
+		tx   IBCMisbehaviourTx
+		code wrsp.CodeType
+	}{
+		// same header
+		{IBCMisbehaviourTx{header1, constructCommit(privAccs, header1), header1, constructCommit(privAccs, header1)},
+			wrsp.CodeType_BaseInvalidInput},
+		// different height
+		{IBCMisbehaviourTx{header1, constructCommit(privAccs, header1), other, constructCommit(privAccs, other)},
+			wrsp.CodeType_BaseInvalidInput},
+		// not signed by the validators
+		{IBCMisbehaviourTx{header1, constructCommit(privAccs, header1), header2, constructCommit(otherAccs, header2)},
+			IBCCodeInvalidCommit},
+		// misbehaviour
+		{IBCMisbehaviourTx{header1, constructCommit(privAccs, header1), header2, constructCommit(privAccs, header2)},
+			wrsp.CodeType_OK},
+		// only once
+		{IBCMisbehaviourTx{header1, constructCommit(privAccs, header1), header2, constructCommit(privAccs, header2)},
+			IBCCodeChainFrozen},
+	}
+	for _, tc := range cases {
+		res = ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{tc.tx}))
+		assertAndLog(t, store, res, tc.code)
+	}
+
+	// the chain can't be updated, and its packets are refused
+	header := newHeader("test_chain", 1000, nil, []byte("must_exist"))
+	res = ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{IBCUpdateChainTx{
+		Header: header,
+		Commit: constructCommit(privAccs, header),
+	}}))
+	assertAndLog(t, store, res, IBCCodeChainFrozen)
+
+	types.SetChainID(store, "dst_chain")
+	packetKey := toKey(_IBC, _EGRESS, packet.SrcChainID, packet.DstChainID, cmn.Fmt("%v", packet.Sequence))
+	res = ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{IBCPacketPostTx{
+		FromChainID:     "test_chain",
+		FromChainHeight: 999,
+		Packet:          packet,
+		Proof:           getProof(t, eyesClient, packetKey),
+	}}))
+	assertAndLog(t, store, res, IBCCodeChainFrozen)
+}
+
+func TestIBCVouchers(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ibcPlugin := New()
+	receiverA, receiverB := []byte("receiver a"), []byte("receiver b")
+
+	// Two chains, with open connections
+	clientA, clientB := eyes.NewLocalClient("", 0), eyes.NewLocalClient("", 0)
+	storeA := types.NewKVCache(types.NewEyesStore(clientA))
+	storeB := types.NewKVCache(types.NewEyesStore(clientB))
+	types.SetChainID(storeA, "chain_a")
+	types.SetChainID(storeB, "chain_b")
+	genDocA, privAccsA := genGenesisDoc("chain_a", 4)
+	genDocJSONA, err := json.Marshal(genDocA)
+	require.Nil(err)
+	genDocB, privAccsB := genGenesisDoc("chain_b", 4)
+	genDocJSONB, err := json.Marshal(genDocB)
+	require.Nil(err)
+	ctx := types.NewCallContext(nil, nil, types.Coins{})
+	registerChain(t, ibcPlugin, storeA, ctx, "chain_b", string(genDocJSONB))
+	registerChain(t, ibcPlugin, storeB, ctx, "chain_a", string(genDocJSONA))
+	openConnection(storeA, "chain_a", "chain_b")
+	openConnection(storeB, "chain_b", "chain_a")
+
+	// send creates a packet with the coins, and posts it on the other chain
+	height := 0
+	send := func(src, dst *types.KVCache, srcClient *eyes.Client, privAccs []types.PrivAccount,
+		packet Packet) Acknowledgement {
+
+		ctx := types.NewCallContext([]byte("sender"), nil, packet.Payload.(CoinsPayload).Coins)
+		res := ibcPlugin.RunTx(src, ctx, wire.BinaryBytes(struct{ IBCTx }{IBCPacketCreateTx{packet}}))
+		assertAndLog(t, src, res, wrsp.CodeType_OK)
+
+		height++
+		commitAndRelay(t, ibcPlugin, src, srcClient, dst, privAccs, packet.SrcChainID, height)
+		packetKey := toKey(_IBC, _EGRESS, packet.SrcChainID, packet.DstChainID, cmn.Fmt("%v", packet.Sequence))
+		res = ibcPlugin.RunTx(dst, ctx, wire.BinaryBytes(struct{ IBCTx }{IBCPacketPostTx{
+			FromChainID:     packet.SrcChainID,
+			FromChainHeight: uint64(height),
+			Packet:          packet,
+			Proof:           getProof(t, srcClient, packetKey),
+		}}))
+		assertAndLog(t, dst, res, wrsp.CodeType_OK)
+
+		ack, exists, err := GetAcknowledgement(dst, packet.SrcChainID, packet.DstChainID, packet.Sequence)
+		require.Nil(err)
+		require.True(exists)
+		return ack
+	}
+	balance := func(store types.KVStore, addr []byte) types.Coins {
+		acc := types.GetAccount(store, addr)
+		if acc == nil {
+			return nil
+		}
+		return acc.Balance
+	}
+
+	// our coins arrive as vouchers, and are locked until they return
+	ack := send(storeA, storeB, clientA, privAccsA,
+		NewPacket("chain_a", "chain_b", 0, CoinsPayload{receiverB, types.Coins{{"mycoin", 10}}}))
+	assert.True(ack.Success, ack.Log)
+	assert.Equal(types.Coins{{"ibc/chain_a/mycoin", 10}}, balance(storeB, receiverB))
+	assert.Equal(types.Coins{{"mycoin", 10}}, GetLockedCoins(storeA, "chain_b"))
+
+	// returning vouchers are burned, and release the coins
+	ack = send(storeB, storeA, clientB, privAccsB,
+		NewPacket("chain_b", "chain_a", 0, CoinsPayload{receiverA, types.Coins{{"ibc/chain_a/mycoin", 4}}}))
+	assert.True(ack.Success, ack.Log)
+	assert.Equal(types.Coins{{"mycoin", 4}}, balance(storeA, receiverA))
+	assert.Equal(types.Coins{{"mycoin", 6}}, GetLockedCoins(storeA, "chain_b"))
+	assert.Empty(GetLockedCoins(storeB, "chain_a"))
+
+	// chain b cannot return more than it got
+	ack = send(storeB, storeA, clientB, privAccsB,
+		NewPacket("chain_b", "chain_a", 1, CoinsPayload{receiverA, types.Coins{{"ibc/chain_a/mycoin", 100}}}))
+	assert.False(ack.Success)
+	assert.Equal(types.Coins{{"mycoin", 4}}, balance(storeA, receiverA))
+	assert.Equal(types.Coins{{"mycoin", 6}}, GetLockedCoins(storeA, "chain_b"))
+
+	// nor mint our coins
+	ack = send(storeB, storeA, clientB, privAccsB,
+		NewPacket("chain_b", "chain_a", 2, CoinsPayload{receiverA, types.Coins{{"mycoin", 50}}}))
+	assert.True(ack.Success, ack.Log)
+	assert.Equal(types.Coins{{"ibc/chain_b/mycoin", 50}, {"mycoin", 4}}, balance(storeA, receiverA))
+}
+
+func TestIBCPacketSource(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ibcPlugin := New()
+	ctx := types.NewCallContext([]byte("sender"), nil, types.Coins{{"mycoin", 10}})
+	receiver := []byte("receiver")
+
+	// chain a knows chain b, and has connections to chains b and c
+	clientA, clientB := eyes.NewLocalClient("", 0), eyes.NewLocalClient("", 0)
+	storeA := types.NewKVCache(types.NewEyesStore(clientA))
+	storeB := types.NewKVCache(types.NewEyesStore(clientB))
+	types.SetChainID(storeA, "chain_a")
+	types.SetChainID(storeB, "chain_b")
+	genDocB, privAccsB := genGenesisDoc("chain_b", 4)
+	genDocJSONB, err := json.Marshal(genDocB)
+	require.Nil(err)
+	registerChain(t, ibcPlugin, storeA, ctx, "chain_b", string(genDocJSONB))
+	openConnection(storeA, "chain_a", "chain_b")
+	openConnection(storeA, "chain_a", "chain_c")
+
+	// chain b cannot send a packet in the name of chain c
+	spoofed := NewPacket("chain_c", "chain_a", 0, CoinsPayload{receiver, types.Coins{{"mycoin", 10}}})
+	res := ibcPlugin.RunTx(storeB, ctx, wire.BinaryBytes(struct{ IBCTx }{IBCPacketCreateTx{spoofed}}))
+	assertAndLog(t, storeB, res, wrsp.CodeType_BaseInvalidInput)
+
+	// and if its validators commit one anyway, chain a rejects it
+	save(storeB, PacketKey("chain_c", "chain_a", 0), spoofed)
+	other := NewPacket("chain_b", "chain_d", 0, CoinsPayload{receiver, types.Coins{{"mycoin", 10}}})
+	save(storeB, PacketKey("chain_b", "chain_d", 0), other)
+	commitAndRelay(t, ibcPlugin, storeB, clientB, storeA, privAccsB, "chain_b", 1)
+
+	postTx := func(from string, packet Packet) IBCPacketPostTx {
+		key := PacketKey(packet.SrcChainID, packet.DstChainID, packet.Sequence)
+		return IBCPacketPostTx{
+			FromChainID:     from,
+			FromChainHeight: 1,
+			Packet:          packet,
+			Proof:           getProof(t, clientB, key),
+		}
+	}
+	cases := []struct {
+		tx   IBCPacketPostTx
+		code wrsp.CodeType
+	}{
+		// proven by chain b, but claims to come from chain c
+		{postTx("chain_b", spoofed), wrsp.CodeType_BaseInvalidInput},
+		// chain c never committed it
+		{postTx("chain_c", spoofed), IBCCodeUnknownHeight},
+		// from chain b, but not for us
+		{postTx("chain_b", other), wrsp.CodeType_BaseInvalidInput},
+	}
+	for _, tc := range cases {
+		res = ibcPlugin.RunTx(storeA, ctx, wire.BinaryBytes(struct{ IBCTx }{tc.tx}))
+		assertAndLog(t, storeA, res, tc.code)
+	}
+	assert.Nil(types.GetAccount(storeA, receiver))
+	_, exists, err := GetAcknowledgement(storeA, "chain_c", "chain_a", 0)
+	require.Nil(err)
+	assert.False(exists)
+}
+
+func TestIBCPacketTimeout(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ibcPlugin := New()
+	ctx := types.NewCallContext(nil, nil, types.Coins{})
+	sender := types.NewCallContext([]byte("sender"), nil, types.Coins{{"mycoin", 20}})
+
+	// Two chains, with an open connection
+	clientA, clientB := eyes.NewLocalClient("", 0), eyes.NewLocalClient("", 0)
+	storeA := types.NewKVCache(types.NewEyesStore(clientA))
+	storeB := types.NewKVCache(types.NewEyesStore(clientB))
+	types.SetChainID(storeA, "chain_a")
+	types.SetChainID(storeB, "chain_b")
+	genDocA, privAccsA := genGenesisDoc("chain_a", 4)
+	genDocJSONA, err := json.Marshal(genDocA)
+	require.Nil(err)
+	genDocB, privAccsB := genGenesisDoc("chain_b", 4)
+	genDocJSONB, err := json.Marshal(genDocB)
+	require.Nil(err)
+	registerChain(t, ibcPlugin, storeA, ctx, "chain_b", string(genDocJSONB))
+	registerChain(t, ibcPlugin, storeB, ctx, "chain_a", string(genDocJSONA))
+	openConnection(storeA, "chain_a", "chain_b")
+	openConnection(storeB, "chain_b", "chain_a")
+
+	runTx := func(store *types.KVCache, ctx types.CallContext, tx IBCTx, code wrsp.CodeType) {
+		res := ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{tx}))
+		assertAndLog(t, store, res, code)
+	}
+
+	// two packets that time out at height 5, only the first is received
+	packets := make([]Packet, 2)
+	for i := range packets {
+		packets[i] = NewPacket("chain_a", "chain_b", uint64(i), CoinsPayload{[]byte("receiver"), types.Coins{{"mycoin", 10}}})
+		packets[i].TimeoutHeight = 5
+		runTx(storeA, sender, IBCPacketCreateTx{packets[i]}, wrsp.CodeType_OK)
+	}
+	commitAndRelay(t, ibcPlugin, storeA, clientA, storeB, privAccsA, "chain_a", 1)
+	runTx(storeB, ctx, IBCPacketPostTx{
+		FromChainID:     "chain_a",
+		FromChainHeight: 1,
+		Packet:          packets[0],
+		Proof:           getProof(t, clientA, PacketKey("chain_a", "chain_b", 0)),
+	}, wrsp.CodeType_OK)
+
+	timeoutTx := func(packet Packet, height uint64) IBCPacketTimeoutTx {
+		return IBCPacketTimeoutTx{packet, connectionProof(t, storeB, clientB, "chain_b", "chain_a", height)}
+	}
+
+	// not before the timeout height
+	commitAndRelay(t, ibcPlugin, storeB, clientB, storeA, privAccsB, "chain_b", 3)
+	runTx(storeA, ctx, timeoutTx(packets[1], 3), IBCCodeNotTimedOut)
+
+	// the proof must be valid, and the packet not received
+	commitAndRelay(t, ibcPlugin, storeB, clientB, storeA, privAccsB, "chain_b", 5)
+	runTx(storeA, ctx, timeoutTx(packets[0], 5), IBCCodePacketAlreadyExists)
+	forged := timeoutTx(packets[1], 5)
+	forged.Connection.NextSequence = 0
+	forged.Packet = packets[0]
+	runTx(storeA, ctx, forged, IBCCodeInvalidProof)
+	changed := timeoutTx(packets[1], 5)
+	changed.Packet.TimeoutHeight = 3
+	runTx(storeA, ctx, changed, IBCCodeUnknownPacket)
+	never := timeoutTx(packets[1], 5)
+	never.Packet.TimeoutHeight = 0
+	runTx(storeA, ctx, never, wrsp.CodeType_BaseInvalidInput)
+
+	// then the coins go back to the sender, only once
+	runTx(storeA, ctx, timeoutTx(packets[1], 5), wrsp.CodeType_OK)
+	acc := types.GetAccount(storeA, sender.CallerAddress)
+	require.NotNil(acc)
+	assert.Equal(types.Coins{{"mycoin", 10}}, acc.Balance)
+	assert.Equal(types.Coins{{"mycoin", 10}}, GetLockedCoins(storeA, "chain_b"))
+	runTx(storeA, ctx, timeoutTx(packets[1], 5), IBCCodePacketAlreadyExists)
+}
+
+// callerPlugin saves who called it, and returns it as the result.
+// It fails on an empty tx, after saving, and without a caller account.
+type callerPlugin struct{}
+
+func (callerPlugin) Name() string { return "caller" }
+
+func (callerPlugin) RunTx(store types.KVStore, ctx types.CallContext, txBytes []byte) wrsp.Result {
+	if ctx.CallerAccount == nil {
+		return wrsp.ErrInternalError.AppendLog("No caller account")
+	}
+	caller := ctx.CallerChainID + "/" + string(ctx.CallerAddress)
+	store.Set([]byte("caller"), []byte(caller))
+	if len(txBytes) == 0 {
+		return wrsp.ErrBaseEncodingError.AppendLog("Empty tx")
+	}
+	return wrsp.NewResultOK([]byte(caller), "")
+}
+
+func (callerPlugin) SetOption(store types.KVStore, key, value string) string          { return "" }
+func (callerPlugin) InitChain(store types.KVStore, vals []*wrsp.Validator)            {}
+func (callerPlugin) BeginBlock(store types.KVStore, hash []byte, header *wrsp.Header) {}
+func (callerPlugin) EndBlock(store types.KVStore, height uint64) (res wrsp.ResponseEndBlock) {
+	return
+}
+
+func TestIBCPluginPayloadRouted(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ibcPlugin := New()
+	ibcPlugin.AddRoute(callerPlugin{})
+	ibcPlugin.AddHandler("perms", permsHandler{})
+	assert.Panics(func() { ibcPlugin.AddHandler("caller", permsHandler{}) })
+	sender := types.NewCallContext([]byte("sender"), nil, types.Coins{})
+
+	// Two chains, with open connections
+	clientA, clientB := eyes.NewLocalClient("", 0), eyes.NewLocalClient("", 0)
+	storeA := types.NewKVCache(types.NewEyesStore(clientA))
+	storeB := types.NewKVCache(types.NewEyesStore(clientB))
+	types.SetChainID(storeA, "chain_a")
+	types.SetChainID(storeB, "chain_b")
+	genDocA, privAccsA := genGenesisDoc("chain_a", 4)
+	genDocJSONA, err := json.Marshal(genDocA)
+	require.Nil(err)
+	genDocB, _ := genGenesisDoc("chain_b", 4)
+	genDocJSONB, err := json.Marshal(genDocB)
+	require.Nil(err)
+	registerChain(t, ibcPlugin, storeA, sender, "chain_b", string(genDocJSONB))
+	registerChain(t, ibcPlugin, storeB, sender, "chain_a", string(genDocJSONA))
+	openConnection(storeA, "chain_a", "chain_b")
+	openConnection(storeB, "chain_b", "chain_a")
+
+	// no one can send in the name of someone else
+	packet := NewPacket("chain_a", "chain_b", 0, PluginPayload{"caller", []byte("other"), []byte("tx")})
+	res := ibcPlugin.RunTx(storeA, sender, wire.BinaryBytes(struct{ IBCTx }{IBCPacketCreateTx{packet}}))
+	assertAndLog(t, storeA, res, wrsp.CodeType_Unauthorized)
+
+	// send creates a packet from the sender, and posts it on chain b
+	height := 0
+	send := func(seq uint64, plugin string, data []byte) Acknowledgement {
+		packet := NewPacket("chain_a", "chain_b", seq, PluginPayload{plugin, []byte("sender"), data})
+		res := ibcPlugin.RunTx(storeA, sender, wire.BinaryBytes(struct{ IBCTx }{IBCPacketCreateTx{packet}}))
+		assertAndLog(t, storeA, res, wrsp.CodeType_OK)
+
+		height++
+		commitAndRelay(t, ibcPlugin, storeA, clientA, storeB, privAccsA, "chain_a", height)
+		res = ibcPlugin.RunTx(storeB, sender, wire.BinaryBytes(struct{ IBCTx }{IBCPacketPostTx{
+			FromChainID:     "chain_a",
+			FromChainHeight: uint64(height),
+			Packet:          packet,
+			Proof:           getProof(t, clientA, PacketKey("chain_a", "chain_b", seq)),
+		}}))
+		assertAndLog(t, storeB, res, wrsp.CodeType_OK)
+
+		ack, exists, err := GetAcknowledgement(storeB, "chain_a", "chain_b", seq)
+		require.Nil(err)
+		require.True(exists)
+		return ack
+	}
+
+	// unknown or unrouted plugins and failing txs are rejected, and leave nothing behind
+	ack := send(0, "unknown", []byte("tx"))
+	assert.False(ack.Success)
+	ack = send(1, "caller", []byte("tx"))
+	assert.False(ack.Success)
+	assert.Empty(storeB.Get([]byte("caller")))
+	assert.Equal("Success", ibcPlugin.SetOption(storeB, "route", "caller"))
+	ack = send(2, "caller", nil)
+	assert.False(ack.Success)
+	assert.Empty(storeB.Get([]byte("caller")))
+
+	// the plugin knows the caller is the sender on chain a, and the result is acked
+	ack = send(3, "caller", []byte("tx"))
+	assert.True(ack.Success, ack.Log)
+	assert.Equal([]byte("chain_a/sender"), ack.Data)
+	assert.Equal([]byte("chain_a/sender"), storeB.Get([]byte("caller")))
+
+	// a handler gets the sender on chain a as its only permission
+	assert.Equal("Success", ibcPlugin.SetOption(storeB, "route", "perms"))
+	raw, err := data.ToWire(txs.NewRaw([]byte("tx")).Wrap())
+	require.Nil(err)
+	ack = send(4, "perms", []byte("junk"))
+	assert.False(ack.Success)
+	ack = send(5, "perms", raw)
+	assert.True(ack.Success, ack.Log)
+	actor := basecoin.Actor{ChainID: "chain_a", App: basecoin.NameSigs, Address: []byte("sender")}
+	assert.Equal(wire.BinaryBytes([]basecoin.Actor{actor}), ack.Data)
+}
+
+// permsHandler returns the permissions of the tx as the result
+type permsHandler struct{}
+
+func (permsHandler) CheckTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (basecoin.Result, error) {
+	return basecoin.Result{}, nil
+}
+
+func (permsHandler) DeliverTx(ctx basecoin.Context, store types.KVStore, tx basecoin.Tx) (basecoin.Result, error) {
+	return basecoin.Result{Data: wire.BinaryBytes(ctx.GetPermissions())}, nil
+}
+
+func TestIBCPruning(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	eyesClient := eyes.NewLocalClient("", 0)
+	store := types.NewKVCache(types.NewEyesStore(eyesClient))
+	types.SetChainID(store, "chain_a")
+
+	ibcPlugin := New()
+	ctx := types.NewCallContext(nil, nil, types.Coins{})
+	runTx := func(tx IBCTx, code wrsp.CodeType) {
+		res := ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{tx}))
+		assertAndLog(t, store, res, code)
+	}
+
+	genDoc, privAccs := genGenesisDoc("test_chain", 4)
+	genDocJSON, err := json.Marshal(genDoc)
+	require.Nil(err)
+	registerChain(t, ibcPlugin, store, ctx, "test_chain", string(genDocJSON))
+	for height := 1; height <= 5; height++ {
+		commitAndUpdate(t, ibcPlugin, store, eyesClient, privAccs, "test_chain", height)
+	}
+
+	// three packets out, the first two acknowledged, and two packets in
+	for seq := uint64(0); seq < 3; seq++ {
+		runTx(IBCPacketCreateTx{NewPacket("chain_a", "test_chain", seq, DataPayload("hello"))}, wrsp.CodeType_OK)
+	}
+	for seq := uint64(0); seq < 2; seq++ {
+		save(store, ReceiptKey("chain_a", "test_chain", seq), Acknowledgement{"chain_a", "test_chain", seq, true, "", nil})
+		save(store, toKey(_IBC, _INGRESS, "chain_a", "test_chain", cmn.Fmt("%v", seq)),
+			NewPacket("test_chain", "chain_a", seq, DataPayload("hello")))
+	}
+	setConnection(store, Connection{
+		ChainID:      "chain_a",
+		Counterparty: "test_chain",
+		State:        ConnectionOpen,
+		NextSequence: 2,
+	})
+
+	// nothing is pruned without a policy
+	ibcPlugin.EndBlock(store, 6)
+	assert.True(exists(store, HeaderKey("test_chain", 1)))
+	assert.True(exists(store, PacketKey("chain_a", "test_chain", 0)))
+
+	assert.NotEqual("Success", ibcPlugin.SetOption(store, "header_retention", "many"))
+	assert.Equal("Success", ibcPlugin.SetOption(store, "header_retention", "2"))
+	assert.Equal("Success", ibcPlugin.SetOption(store, "prune_packets", "true"))
+	ibcPlugin.EndBlock(store, 7)
+
+	// the headers more than 2 heights below the latest are gone
+	for height := uint64(1); height <= 5; height++ {
+		assert.Equal(height >= 3, exists(store, HeaderKey("test_chain", height)), "%d", height)
+	}
+	var chainState BlockchainState
+	_, err = load(store, ChainStateKey("test_chain"), &chainState)
+	require.Nil(err)
+	assert.EqualValues(3, chainState.PrunedHeight)
+
+	// and so are the acknowledged packets, and the copies of the incoming ones
+	for seq := uint64(0); seq < 3; seq++ {
+		assert.Equal(seq == 2, exists(store, PacketKey("chain_a", "test_chain", seq)), "%d", seq)
+		assert.False(exists(store, ReceiptKey("chain_a", "test_chain", seq)), "%d", seq)
+		assert.False(exists(store, toKey(_IBC, _INGRESS, "chain_a", "test_chain", cmn.Fmt("%v", seq))), "%d", seq)
+	}
+	pruned, err := GetPrunedPackets(store, "test_chain")
+	require.Nil(err)
+	assert.Equal(PrunedPackets{Egress: 2, Ingress: 2}, pruned)
+
+	// pruned heights and packets fail cleanly
+	header := newHeader("test_chain", 2, []byte("app_hash"), []byte("must_exist"))
+	runTx(IBCUpdateChainTx{Header: header, Commit: constructCommit(privAccs, header)}, IBCCodePruned)
+	runTx(IBCPacketPostTx{
+		FromChainID:     "test_chain",
+		FromChainHeight: 2,
+		Packet:          NewPacket("test_chain", "chain_a", 2, DataPayload("hello")),
+	}, IBCCodePruned)
+	runTx(IBCPacketAckTx{
+		FromChainID:     "test_chain",
+		FromChainHeight: 5,
+		Acknowledgement: Acknowledgement{"chain_a", "test_chain", 0, true, "", nil},
+	}, IBCCodePruned)
+
+	// the policy is part of the genesis
+	exported, err := ibcPlugin.ExportGenesis(store)
+	require.Nil(err)
+	var gen IBCGenesis
+	require.Nil(json.Unmarshal(exported, &gen))
+	require.NotNil(gen.Pruning)
+	assert.Equal(Pruning{HeaderRetention: 2, PrunePackets: true}, *gen.Pruning)
+}
+
+func TestIBCPruningFailure(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	eyesClient := eyes.NewLocalClient("", 0)
+	store := types.NewKVCache(types.NewEyesStore(eyesClient))
+	types.SetChainID(store, "chain_a")
+
+	ibcPlugin := New()
+	ctx := types.NewCallContext(nil, nil, types.Coins{})
+	genDoc, privAccs := genGenesisDoc("test_chain", 4)
+	genDocJSON, err := json.Marshal(genDoc)
+	require.Nil(err)
+	registerChain(t, ibcPlugin, store, ctx, "test_chain", string(genDocJSON))
+	for height := 1; height <= 5; height++ {
+		commitAndUpdate(t, ibcPlugin, store, eyesClient, privAccs, "test_chain", height)
+	}
+	assert.Equal("Success", ibcPlugin.SetOption(store, "header_retention", "2"))
+	assert.Equal("Success", ibcPlugin.SetOption(store, "prune_packets", "true"))
+
+	// the packets can't be pruned, so neither are the headers
+	store.Set(PrunedPacketsKey("test_chain"), []byte{0x01})
+	ibcPlugin.EndBlock(store, 6)
+	for height := uint64(1); height <= 5; height++ {
+		assert.True(exists(store, HeaderKey("test_chain", height)), "%d", height)
+	}
 }
 
 func TestIBCPluginBadCommit(t *testing.T) {
 	require := require.New(t)
 
 	eyesClient := eyes.NewLocalClient("", 0)
-	store := types.NewKVCache(eyesClient)
+	store := types.NewKVCache(types.NewEyesStore(eyesClient))
 	store.SetLogging() // Log all activity
 
 	ibcPlugin := New()
@@ -392,8 +1362,9 @@ func TestIBCPluginBadProof(t *testing.T) {
 	require := require.New(t)
 
 	eyesClient := eyes.NewLocalClient("", 0)
-	store := types.NewKVCache(eyesClient)
+	store := types.NewKVCache(types.NewEyesStore(eyesClient))
 	store.SetLogging() // Log all activity
+	types.SetChainID(store, "test_chain")
 
 	ibcPlugin := New()
 	ctx := types.NewCallContext(nil, nil, types.Coins{})
@@ -449,6 +1420,9 @@ func TestIBCPluginBadProof(t *testing.T) {
 	proof.InnerNodes[0].Height += 1
 
 	// Post a packet
+	// loop back, and receive the packet as the dst chain
+	types.SetChainID(store, "dst_chain")
+	openConnection(store, "dst_chain", "test_chain")
 	res = ibcPlugin.RunTx(store, ctx, wire.BinaryBytes(struct{ IBCTx }{IBCPacketPostTx{
 		FromChainID:     "test_chain",
 		FromChainHeight: 999,
@@ -498,15 +1472,90 @@ func constructCommit(privAccs []types.PrivAccount, header tm.Header) tm.Commit {
 		vote := &tm.Vote{
 			ValidatorAddress: privAcc.Account.PubKey.Address(),
 			ValidatorIndex:   i,
-			Height:           999,
+			Height:           header.Height,
 			Round:            0,
 			Type:             tm.VoteTypePrecommit,
 			BlockID:          tm.BlockID{Hash: blockHash},
 		}
 		vote.Signature = privAcc.PrivKey.Sign(
-			tm.SignBytes("test_chain", vote),
+			tm.SignBytes(header.ChainID, vote),
 		)
 		commit.Precommits[i] = vote
 	}
 	return commit
 }
+
+// validators returns the validator set of the accounts, with equal power
+func validators(privAccs []types.PrivAccount) []*tm.Validator {
+	vals := make([]*tm.Validator, len(privAccs))
+	for i, privAcc := range privAccs {
+		vals[i] = &tm.Validator{
+			Address:     privAcc.Account.PubKey.Address(),
+			PubKey:      privAcc.Account.PubKey,
+			VotingPower: 1,
+		}
+	}
+	return vals
+}
+
+// commitAndUpdate commits the store, and posts a header for the new
+// app hash as the given chain, so we can prove anything in the store
+func commitAndUpdate(t *testing.T, ibcPlugin *IBCPlugin, store *types.KVCache, eyesClient *eyes.Client,
+	privAccs []types.PrivAccount, chainID string, height int) {
+
+	commitAndRelay(t, ibcPlugin, store, eyesClient, store, privAccs, chainID, height)
+}
+
+// commitAndRelay commits the src store, and posts a header for the new
+// app hash as the given chain to the dst store
+func commitAndRelay(t *testing.T, ibcPlugin *IBCPlugin, src *types.KVCache, srcClient *eyes.Client,
+	dst *types.KVCache, privAccs []types.PrivAccount, chainID string, height int) {
+
+	src.Sync()
+	resCommit := srcClient.CommitSync()
+	header := newHeader(chainID, height, resCommit.Data, []byte("must_exist"))
+	commit := constructCommit(privAccs, header)
+	ctx := types.NewCallContext(nil, nil, types.Coins{})
+	res := ibcPlugin.RunTx(dst, ctx, wire.BinaryBytes(struct{ IBCTx }{IBCUpdateChainTx{
+		Header: header,
+		Commit: commit,
+	}}))
+	assertAndLog(t, dst, res, wrsp.CodeType_OK)
+}
+
+// openConnection skips the handshake, to accept packets from the counterparty
+func openConnection(store types.KVStore, chainID, counterparty string) {
+	setConnection(store, Connection{
+		ChainID:      chainID,
+		Counterparty: counterparty,
+		State:        ConnectionOpen,
+	})
+}
+
+// connectionProof proves the connection in the last committed state
+func connectionProof(t *testing.T, store types.KVStore, eyesClient *eyes.Client,
+	chainID, counterparty string, height uint64) ConnectionProof {
+
+	conn, exists, err := GetConnection(store, chainID, counterparty)
+	require.Nil(t, err)
+	require.True(t, exists)
+	return ConnectionProof{
+		FromChainHeight: height,
+		Connection:      conn,
+		Proof:           getProof(t, eyesClient, toKey(_IBC, _CONNECTION, chainID, counterparty)),
+	}
+}
+
+// getProof returns the proof for the key in the last committed state
+func getProof(t *testing.T, eyesClient *eyes.Client, key []byte) *iavl.IAVLProof {
+	resQuery, err := eyesClient.QuerySync(wrsp.RequestQuery{
+		Path:  "/store",
+		Data:  key,
+		Prove: true,
+	})
+	require.Nil(t, err)
+	var proof *iavl.IAVLProof
+	err = wire.ReadBinaryBytes(resQuery.Proof, &proof)
+	require.Nil(t, err)
+	return proof
+}
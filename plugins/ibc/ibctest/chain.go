@@ -0,0 +1,306 @@
+// Package ibctest runs basecoin chains with the ibc plugin in process,
+// on in-memory stores, so IBC flows between them can be tested
+// deterministically in go test.
+package ibctest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	wrsp "github.com/tepleton/wrsp/types"
+	"github.com/tepleton/go-wire"
+	eyes "github.com/tepleton/merkleeyes/client"
+	"github.com/tepleton/merkleeyes/iavl"
+	cmn "github.com/tepleton/tmlibs/common"
+	"github.com/tepleton/tmlibs/log"
+
+	"github.com/tepleton/basecoin/app"
+	"github.com/tepleton/basecoin/plugins/ibc"
+	"github.com/tepleton/basecoin/plugins/ibc/relay"
+	"github.com/tepleton/basecoin/types"
+	tm "github.com/tepleton/tepleton/types"
+)
+
+// Fee is paid by every tx sent with AppTx
+var Fee = types.Coin{"mycoin", 1}
+
+// Chain is a basecoin app with the ibc plugin, and a simulated block
+// producer. Every call to Block commits a new block, with a header
+// for the new app hash, signed by all test validators.
+type Chain struct {
+	App *app.Basecoin
+	// RelayerAcc signs the txs of the relayer, and pays their fees.
+	// It is the IBC admin, so it opens and closes connections.
+	RelayerAcc types.PrivAccount
+
+	chainID    string
+	validators []types.PrivAccount // sorted by address, like the validator set
+	height     uint64
+	headers    map[uint64]tm.Header
+	signers    map[uint64][]types.PrivAccount // the validators of every block
+	blocks     []chan<- struct{}
+}
+
+var _ relay.Chain = (*Chain)(nil)
+
+// NewChain boots a chain with numVals validators, and funds the accounts
+// with their balance. The relayer account gets 1000 mycoin for fees,
+// and is the IBC admin.
+// Block 1 is committed with the genesis state.
+func NewChain(chainID string, numVals int, logger log.Logger, accs ...types.PrivAccount) (*Chain, error) {
+	c := &Chain{
+		App:        app.NewBasecoin(eyes.NewLocalClient("", 0)),
+		RelayerAcc: types.PrivAccountFromSecret(chainID + "_relayer"),
+		chainID:    chainID,
+		headers:    make(map[uint64]tm.Header),
+		signers:    make(map[uint64][]types.PrivAccount),
+	}
+	for i := 0; i < numVals; i++ {
+		c.validators = append(c.validators, types.PrivAccountFromSecret(cmn.Fmt("%v_val_%v", chainID, i)))
+	}
+	sort.Sort(byAddress(c.validators))
+
+	c.App.SetLogger(logger.With("module", chainID))
+	ibcPlugin := ibc.New()
+	ibcPlugin.SetLogger(logger.With("module", chainID+"/ibc"))
+	c.App.RegisterPlugin(ibcPlugin)
+
+	res := c.App.SetOption("base/chain_id", chainID)
+	if res != "Success" {
+		return nil, errors.New(res)
+	}
+	res = c.App.SetOption("IBC/admin", hex.EncodeToString(c.RelayerAcc.Account.PubKey.Address()))
+	if res != "Success" {
+		return nil, errors.New(res)
+	}
+	c.RelayerAcc.Account.Balance = types.Coins{{"mycoin", 1000}}
+	for _, acc := range append(accs, c.RelayerAcc) {
+		accBytes, err := json.Marshal(acc.Account)
+		if err != nil {
+			return nil, err
+		}
+		res = c.App.SetOption("base/account", string(accBytes))
+		if res != "Success" {
+			return nil, errors.New(res)
+		}
+	}
+
+	return c, c.Block()
+}
+
+func (c *Chain) Height() uint64 {
+	return c.height
+}
+
+// ValidatorSet returns the validators, all with the same voting power
+func (c *Chain) ValidatorSet() *tm.ValidatorSet {
+	return tm.NewValidatorSet(toValidators(c.validators))
+}
+
+// AddValidators adds n new validators, who sign from the next block on.
+// The old ones still hold enough voting power to vouch for them.
+func (c *Chain) AddValidators(n int) {
+	vals := make([]types.PrivAccount, len(c.validators), len(c.validators)+n)
+	copy(vals, c.validators)
+	for i := 0; i < n; i++ {
+		vals = append(vals, types.PrivAccountFromSecret(cmn.Fmt("%v_val_%v", c.chainID, len(vals))))
+	}
+	sort.Sort(byAddress(vals))
+	c.validators = vals
+}
+
+// Genesis returns the genesis doc of the chain, to register it on
+// another chain with IBCRegisterChainTx
+func (c *Chain) Genesis() (string, error) {
+	genDoc := tm.GenesisDoc{ChainID: c.chainID}
+	for i, val := range c.validators {
+		genDoc.Validators = append(genDoc.Validators, tm.GenesisValidator{
+			PubKey: val.Account.PubKey,
+			Amount: 1,
+			Name:   cmn.Fmt("%v_val_%v", c.chainID, i),
+		})
+	}
+	genDocJSON, err := json.Marshal(genDoc)
+	return string(genDocJSON), err
+}
+
+// Block commits the txs in a new block, and returns the first error.
+// Like tendermint, txs failing CheckTx are left out of the block.
+func (c *Chain) Block(txs ...[]byte) (err error) {
+	height := c.height + 1
+	c.App.BeginBlock(nil, &wrsp.Header{Height: height})
+	for _, tx := range txs {
+		res := c.App.CheckTx(tx)
+		if res.IsOK() {
+			res = c.App.DeliverTx(tx)
+		}
+		if res.IsErr() && err == nil {
+			err = errors.New(res.Error())
+		}
+	}
+	c.App.EndBlock(height)
+	res := c.App.Commit()
+	if res.IsErr() {
+		return errors.New(res.Error())
+	}
+
+	c.height = height
+	c.signers[height] = c.validators
+	c.headers[height] = tm.Header{
+		ChainID:        c.chainID,
+		Height:         int(height),
+		AppHash:        res.Data,
+		ValidatorsHash: c.ValidatorSet().Hash(),
+	}
+	for _, blocks := range c.blocks {
+		select {
+		case blocks <- struct{}{}:
+		default:
+		}
+	}
+	return err
+}
+
+// AppTx sends the ibc tx from acc in a new block, with the coins as input
+func (c *Chain) AppTx(acc types.PrivAccount, coins types.Coins, ibcTx ibc.IBCTx) error {
+	var sequence int
+	if account := c.App.GetState().GetAccount(acc.Account.PubKey.Address()); account != nil {
+		sequence = account.Sequence
+	}
+	tx := &types.AppTx{
+		Fee:   Fee,
+		Name:  "IBC",
+		Input: types.NewTxInput(acc.Account.PubKey, coins.Plus(types.Coins{Fee}), sequence+1),
+		Data:  wire.BinaryBytes(struct{ ibc.IBCTx }{ibcTx}),
+	}
+	tx.Input.Signature = acc.PrivKey.Sign(tx.SignBytes(c.chainID))
+	return c.Block(wire.BinaryBytes(struct{ types.Tx }{tx}))
+}
+
+// SendPacket creates a packet from acc to dst with the next sequence,
+// and escrows the coins of a CoinsPayload
+func (c *Chain) SendPacket(acc types.PrivAccount, dst string, payload ibc.Payload, timeoutHeight uint64) (ibc.Packet, error) {
+	seq := ibc.GetSequenceNumber(c.App.GetState(), c.chainID, dst)
+	packet := ibc.NewPacket(c.chainID, dst, seq, payload)
+	packet.TimeoutHeight = timeoutHeight
+
+	var coins types.Coins
+	if coinsPayload, ok := payload.(ibc.CoinsPayload); ok {
+		coins = coinsPayload.Coins
+	}
+	return packet, c.AppTx(acc, coins, ibc.IBCPacketCreateTx{packet})
+}
+
+// Balance returns the coins of the account at addr
+func (c *Chain) Balance(addr []byte) types.Coins {
+	acc := c.App.GetState().GetAccount(addr)
+	if acc == nil {
+		return nil
+	}
+	return acc.Balance
+}
+
+//--------------------------------------------------------------------------------
+// relay.Chain
+
+func (c *Chain) ChainID() string {
+	return c.chainID
+}
+
+func (c *Chain) Query(key []byte) ([]byte, *iavl.IAVLProof, uint64, error) {
+	res := c.App.Query(wrsp.RequestQuery{
+		Path:  "/key",
+		Data:  key,
+		Prove: true,
+	})
+	if !res.Code.IsOK() {
+		return nil, nil, 0, errors.New(res.Log)
+	}
+	if len(res.Value) == 0 {
+		return nil, nil, c.height, nil
+	}
+	proof := new(iavl.IAVLProof)
+	err := wire.ReadBinaryBytes(res.Proof, &proof)
+	return res.Value, proof, c.height, err
+}
+
+// Commit signs the header at height with all validators of that block
+func (c *Chain) Commit(height uint64) (tm.Header, tm.Commit, error) {
+	header, ok := c.headers[height]
+	if !ok {
+		return header, tm.Commit{}, errors.Errorf("No block at %d", height)
+	}
+	signers := c.signers[height]
+	blockHash := header.Hash()
+	commit := tm.Commit{
+		BlockID:    tm.BlockID{Hash: blockHash},
+		Precommits: make([]*tm.Vote, len(signers)),
+	}
+	for i, val := range signers {
+		vote := &tm.Vote{
+			ValidatorAddress: val.Account.PubKey.Address(),
+			ValidatorIndex:   i,
+			Height:           header.Height,
+			Round:            0,
+			Type:             tm.VoteTypePrecommit,
+			BlockID:          tm.BlockID{Hash: blockHash},
+		}
+		vote.Signature = val.PrivKey.Sign(tm.SignBytes(c.chainID, vote))
+		commit.Precommits[i] = vote
+	}
+	return header, commit, nil
+}
+
+// Validators returns the validators of the block at height
+func (c *Chain) Validators(height uint64) ([]*tm.Validator, error) {
+	signers, ok := c.signers[height]
+	if !ok {
+		return nil, errors.Errorf("No block at %d", height)
+	}
+	return toValidators(signers), nil
+}
+
+// SendTx sends the tx from the relayer account in a new block
+func (c *Chain) SendTx(ibcTx ibc.IBCTx) error {
+	return c.AppTx(c.RelayerAcc, nil, ibcTx)
+}
+
+// Subscribe is notified after every block
+func (c *Chain) Subscribe(blocks chan<- struct{}) error {
+	c.blocks = append(c.blocks, blocks)
+	return nil
+}
+
+//--------------------------------------------------------------------------------
+
+// toValidators gives all accounts the same voting power
+func toValidators(accs []types.PrivAccount) []*tm.Validator {
+	vals := make([]*tm.Validator, len(accs))
+	for i, acc := range accs {
+		vals[i] = &tm.Validator{
+			Address:     acc.Account.PubKey.Address(),
+			PubKey:      acc.Account.PubKey,
+			VotingPower: 1,
+		}
+	}
+	return vals
+}
+
+// byAddress sorts accounts like tm.NewValidatorSet sorts validators
+type byAddress []types.PrivAccount
+
+func (accs byAddress) Len() int {
+	return len(accs)
+}
+
+func (accs byAddress) Less(i, j int) bool {
+	return bytes.Compare(accs[i].Account.PubKey.Address(), accs[j].Account.PubKey.Address()) == -1
+}
+
+func (accs byAddress) Swap(i, j int) {
+	accs[i], accs[j] = accs[j], accs[i]
+}
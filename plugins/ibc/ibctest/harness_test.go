@@ -0,0 +1,158 @@
+package ibctest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tepleton/go-wire"
+	"github.com/tepleton/tmlibs/log"
+
+	"github.com/tepleton/basecoin/plugins/ibc"
+	"github.com/tepleton/basecoin/plugins/ibc/relay"
+	"github.com/tepleton/basecoin/types"
+	tm "github.com/tepleton/tepleton/types"
+)
+
+func newTestHarness(t *testing.T, accsA, accsB []types.PrivAccount) *Harness {
+	chainA, err := NewChain("chain_a", 4, log.TestingLogger(), accsA...)
+	require.Nil(t, err)
+	chainB, err := NewChain("chain_b", 2, log.TestingLogger(), accsB...)
+	require.Nil(t, err)
+	h, err := NewHarness(chainA, chainB, log.TestingLogger())
+	require.Nil(t, err)
+	return h
+}
+
+func TestHarnessConnection(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	h := newTestHarness(t, nil, nil)
+	for _, pair := range [][2]*Chain{{h.A, h.B}, {h.B, h.A}} {
+		chain, other := pair[0], pair[1]
+		var state ibc.BlockchainState
+		readProven(t, other, ibc.ChainStateKey(chain.ChainID()), &state)
+		assert.Equal(chain.ChainID(), state.ChainID)
+		assert.Equal(chain.ValidatorSet().Hash(), tm.NewValidatorSet(state.Validators).Hash())
+		assert.True(state.LastBlockHeight > 1)
+	}
+	connA, _, _, err := relay.QueryConnection(h.A, "chain_b")
+	require.Nil(err)
+	assert.Equal(ibc.ConnectionOpen, connA.State)
+	connB, _, _, err := relay.QueryConnection(h.B, "chain_a")
+	require.Nil(err)
+	assert.Equal(ibc.ConnectionOpen, connB.State)
+
+	// nothing left to relay
+	heightA, heightB := h.A.Height(), h.B.Height()
+	require.Nil(h.RelayUntilIdle())
+	assert.Equal(heightA, h.A.Height())
+	assert.Equal(heightB, h.B.Height())
+}
+
+func TestHarnessTransfer(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	alice := types.PrivAccountFromSecret("alice")
+	alice.Account.Balance = types.Coins{{"mycoin", 100}}
+	bob := types.PrivAccountFromSecret("bob")
+	bob.Account.Balance = types.Coins{{"mycoin", 10}}
+	h := newTestHarness(t, []types.PrivAccount{alice}, []types.PrivAccount{bob})
+	aliceAddr, bobAddr := alice.Account.PubKey.Address(), bob.Account.PubKey.Address()
+	voucher := ibc.VoucherDenom("chain_a", "mycoin")
+
+	// alice sends coins to bob, who gets vouchers for them
+	coins := types.Coins{{"mycoin", 10}}
+	_, err := h.A.SendPacket(alice, "chain_b", ibc.CoinsPayload{bobAddr, coins}, 0)
+	require.Nil(err)
+	require.Nil(h.RelayUntilIdle())
+	assert.Equal(types.Coins{{"mycoin", 89}}, h.A.Balance(aliceAddr))
+	assert.Equal(types.Coins{{voucher, 10}, {"mycoin", 10}}, h.B.Balance(bobAddr))
+	assert.Equal(coins, ibc.GetLockedCoins(h.A.App.GetState(), "chain_b"))
+	assertReceipt(t, h.A, "chain_b", 0, true)
+
+	// bob sends some back, and alice gets her coins
+	_, err = h.B.SendPacket(bob, "chain_a", ibc.CoinsPayload{aliceAddr, types.Coins{{voucher, 4}}}, 0)
+	require.Nil(err)
+	require.Nil(h.RelayUntilIdle())
+	assert.Equal(types.Coins{{"mycoin", 93}}, h.A.Balance(aliceAddr))
+	assert.Equal(types.Coins{{voucher, 6}, {"mycoin", 9}}, h.B.Balance(bobAddr))
+	assert.Equal(types.Coins{{"mycoin", 6}}, ibc.GetLockedCoins(h.A.App.GetState(), "chain_b"))
+	assertReceipt(t, h.B, "chain_a", 0, true)
+
+	// and the relayer kept track of it all
+	assert.Equal(relay.PathProgress{NextPacket: 1, NextAck: 1}, *h.Progress.Path("chain_a", "chain_b"))
+	assert.Equal(relay.PathProgress{NextPacket: 1, NextAck: 1}, *h.Progress.Path("chain_b", "chain_a"))
+}
+
+func TestHarnessRefund(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	alice := types.PrivAccountFromSecret("alice")
+	alice.Account.Balance = types.Coins{{"mycoin", 100}}
+	h := newTestHarness(t, []types.PrivAccount{alice}, nil)
+	aliceAddr, bobAddr := alice.Account.PubKey.Address(), []byte("bob")
+	coins := types.Coins{{"mycoin", 10}}
+
+	// chain_b is past the timeout when the packet arrives
+	_, err := h.A.SendPacket(alice, "chain_b", ibc.CoinsPayload{bobAddr, coins}, h.B.Height())
+	require.Nil(err)
+	assert.Equal(types.Coins{{"mycoin", 89}}, h.A.Balance(aliceAddr))
+	require.Nil(h.RelayUntilIdle())
+
+	assert.Nil(h.B.Balance(bobAddr))
+	assertReceipt(t, h.A, "chain_b", 0, false)
+	// the fee is gone, the escrow is refunded
+	assert.Equal(types.Coins{{"mycoin", 99}}, h.A.Balance(aliceAddr))
+	assert.True(ibc.GetLockedCoins(h.A.App.GetState(), "chain_b").IsZero())
+}
+
+func TestHarnessValidatorChange(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	alice := types.PrivAccountFromSecret("alice")
+	alice.Account.Balance = types.Coins{{"mycoin", 100}}
+	h := newTestHarness(t, []types.PrivAccount{alice}, nil)
+	bobAddr := []byte("bob")
+	voucher := ibc.VoucherDenom("chain_a", "mycoin")
+
+	// the packet is proven by a header of the new validators,
+	// which the relayer posts along with it
+	h.A.AddValidators(1)
+	_, err := h.A.SendPacket(alice, "chain_b", ibc.CoinsPayload{bobAddr, types.Coins{{"mycoin", 10}}}, 0)
+	require.Nil(err)
+	require.Nil(h.RelayUntilIdle())
+	assert.Equal(types.Coins{{voucher, 10}}, h.B.Balance(bobAddr))
+	assertReceipt(t, h.A, "chain_b", 0, true)
+
+	var state ibc.BlockchainState
+	readProven(t, h.B, ibc.ChainStateKey("chain_a"), &state)
+	assert.Equal(5, len(state.Validators))
+	assert.Equal(h.A.ValidatorSet().Hash(), tm.NewValidatorSet(state.Validators).Hash())
+}
+
+//--------------------------------------------------------------------------------
+
+// readProven reads the value at key, and checks its proof against the
+// header of the block it was committed in
+func readProven(t *testing.T, chain *Chain, key []byte, ptr interface{}) {
+	value, proof, height, err := chain.Query(key)
+	require.Nil(t, err)
+	require.NotNil(t, proof, "%s", key)
+	header, _, err := chain.Commit(height)
+	require.Nil(t, err)
+	require.True(t, proof.Verify(key, value, header.AppHash), "%s", key)
+	require.Nil(t, wire.ReadBinaryBytes(value, ptr))
+}
+
+// assertReceipt checks the ack of a packet from src was relayed back to it
+func assertReceipt(t *testing.T, src *Chain, dst string, seq uint64, success bool) {
+	var ack ibc.Acknowledgement
+	readProven(t, src, ibc.ReceiptKey(src.ChainID(), dst, seq), &ack)
+	assert.Equal(t, success, ack.Success, ack.Log)
+}
@@ -0,0 +1,83 @@
+package ibc
+
+import (
+	cmn "github.com/tepleton/tmlibs/common"
+
+	"github.com/tepleton/basecoin/types"
+)
+
+// The keys of the IBC state, so clients can query and prove it.
+// See IBCPluginState for what is stored under each of them.
+
+// ChainListKey is where the ids of all registered chains are stored
+func ChainListKey() []byte {
+	return toKey(_IBC, _BLOCKCHAIN, _LIST)
+}
+
+// ChainGenesisKey is where the genesis of a registered chain is stored
+func ChainGenesisKey(chainID string) []byte {
+	return toKey(_IBC, _BLOCKCHAIN, _GENESIS, chainID)
+}
+
+// ChainStateKey is where the latest known state of a registered chain is stored
+func ChainStateKey(chainID string) []byte {
+	return toKey(_IBC, _BLOCKCHAIN, _STATE, chainID)
+}
+
+// HeaderKey is where a header of a registered chain is stored
+func HeaderKey(chainID string, height uint64) []byte {
+	return toKey(_IBC, _BLOCKCHAIN, _HEADER, chainID, cmn.Fmt("%v", height))
+}
+
+// SequenceKey is where the number of packets sent from src to dst is stored
+func SequenceKey(src, dst string) []byte {
+	return toKey(_IBC, _EGRESS, src, dst)
+}
+
+// PacketKey is where a packet from src to dst is stored on src
+func PacketKey(src, dst string, seq uint64) []byte {
+	return toKey(_IBC, _EGRESS, src, dst, cmn.Fmt("%v", seq))
+}
+
+// AckKey is where the ack of a packet from src to dst is stored on dst
+func AckKey(src, dst string, seq uint64) []byte {
+	return toKey(_IBC, _ACK, src, dst, cmn.Fmt("%v", seq))
+}
+
+// ReceiptKey is where the ack of a packet from src to dst is stored on src,
+// once it was relayed back
+func ReceiptKey(src, dst string, seq uint64) []byte {
+	return toKey(_IBC, _RECEIPT, src, dst, cmn.Fmt("%v", seq))
+}
+
+// ConnectionKey is where the connection from a chain to the counterparty is stored
+func ConnectionKey(chainID, counterparty string) []byte {
+	return toKey(_IBC, _CONNECTION, chainID, counterparty)
+}
+
+// EscrowKey is where the coins of a packet from src to dst are held on src,
+// until it is acknowledged
+func EscrowKey(src, dst string, seq uint64) []byte {
+	return toKey(_IBC, _ESCROW, src, dst, cmn.Fmt("%v", seq))
+}
+
+// AdminKey is where the address that opens and closes connections is stored
+func AdminKey() []byte {
+	return toKey(_IBC, _ADMIN)
+}
+
+// RouteKey is where we track that other chains may call the plugin
+func RouteKey(plugin string) []byte {
+	return toKey(_IBC, _ROUTE, plugin)
+}
+
+// PrunedPacketsKey is where we track what was pruned of the packets to and from the counterparty
+func PrunedPacketsKey(counterparty string) []byte {
+	return toKey(_IBC, _PRUNED, counterparty)
+}
+
+// GetChainIDs returns the ids of all registered chains, in the order they were registered
+func GetChainIDs(store types.KVStore) (chainIDs []string, err error) {
+	_, err = load(store, ChainListKey(), &chainIDs)
+	return
+}
@@ -0,0 +1,170 @@
+package ibc
+
+import (
+	"bytes"
+	"strconv"
+
+	cmn "github.com/tepleton/tmlibs/common"
+
+	"github.com/tepleton/basecoin/types"
+)
+
+// maxPrunePerBlock limits the packets pruned per chain in one EndBlock,
+// so catching up on a new policy doesn't stall the chain
+const maxPrunePerBlock = 100
+
+// Pruning is the retention policy of the IBC state, applied in EndBlock.
+// The zero value keeps everything.
+type Pruning struct {
+	// HeaderRetention keeps the headers of every chain down to this many
+	// heights below the latest one. 0 keeps all headers.
+	HeaderRetention uint64 `json:"header_retention"`
+	// PrunePackets deletes our packets once they are acknowledged,
+	// and the copies of incoming packets once they are acked
+	PrunePackets bool `json:"prune_packets"`
+}
+
+// PrunedPackets tracks what was pruned of the packets to and from a chain
+type PrunedPackets struct {
+	Egress  uint64 // our packets below this sequence were acknowledged and pruned
+	Ingress uint64 // the copies of incoming packets below this sequence were pruned
+}
+
+// GetPruning returns the retention policy
+func GetPruning(store types.KVStore) (pruning Pruning, err error) {
+	_, err = load(store, toKey(_IBC, _PRUNING), &pruning)
+	return
+}
+
+func setPruning(store types.KVStore, pruning Pruning) {
+	save(store, toKey(_IBC, _PRUNING), pruning)
+}
+
+// GetPrunedPackets returns what was pruned of the packets to and from the counterparty
+func GetPrunedPackets(store types.KVStore, counterparty string) (pruned PrunedPackets, err error) {
+	_, err = load(store, PrunedPacketsKey(counterparty), &pruned)
+	return
+}
+
+// setPruningOption sets one field of the retention policy from SetOption
+func setPruningOption(store types.KVStore, key, value string) (log string) {
+	pruning, err := GetPruning(store)
+	if err != nil {
+		return err.Error()
+	}
+	switch key {
+	case "header_retention":
+		pruning.HeaderRetention, err = strconv.ParseUint(value, 10, 64)
+	case "prune_packets":
+		pruning.PrunePackets, err = strconv.ParseBool(value)
+	default:
+		return "Unrecognized option key " + key
+	}
+	if err != nil {
+		return cmn.Fmt("Invalid %v: %v", key, err.Error())
+	}
+	setPruning(store, pruning)
+	return "Success"
+}
+
+// prune applies the retention policy to all registered chains
+func prune(store types.KVStore) error {
+	pruning, err := GetPruning(store)
+	if err != nil {
+		return err
+	}
+	if pruning.HeaderRetention == 0 && !pruning.PrunePackets {
+		return nil
+	}
+	chainIDs, err := GetChainIDs(store)
+	if err != nil {
+		return err
+	}
+	for _, chainID := range chainIDs {
+		if pruning.HeaderRetention > 0 {
+			err = pruneHeaders(store, chainID, pruning.HeaderRetention)
+			if err != nil {
+				return err
+			}
+		}
+		if pruning.PrunePackets {
+			err = prunePackets(store, chainID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pruneHeaders deletes all headers of the chain more than retention
+// heights below the latest one
+func pruneHeaders(store types.KVStore, chainID string, retention uint64) error {
+	var chainState BlockchainState
+	exists, err := load(store, ChainStateKey(chainID), &chainState)
+	if err != nil || !exists {
+		return err
+	}
+	if chainState.LastBlockHeight <= retention {
+		return nil
+	}
+	cutoff := chainState.LastBlockHeight - retention
+	if cutoff <= chainState.PrunedHeight {
+		return nil
+	}
+
+	// headers are stored at the heights the relayers chose,
+	// so we look at all of them. Only the retained ones are left.
+	prefix := append(toKey(_IBC, _BLOCKCHAIN, _HEADER, chainID), ',')
+	var pruned [][]byte
+	for it := types.PrefixIterator(store, prefix); it.Valid(); it.Next() {
+		key := it.Key()
+		height, err := strconv.ParseUint(string(bytes.TrimPrefix(key, prefix)), 10, 64)
+		if err == nil && height < cutoff {
+			pruned = append(pruned, key)
+		}
+	}
+	for _, key := range pruned {
+		store.Delete(key)
+	}
+
+	chainState.PrunedHeight = cutoff
+	save(store, ChainStateKey(chainID), chainState)
+	return nil
+}
+
+// prunePackets deletes our packets to the chain that were acknowledged,
+// with their receipts, and the copies of the packets we got from it.
+// Both go in order, so only the sequence up to which they were pruned is kept.
+func prunePackets(store types.KVStore, chainID string) error {
+	ours := types.GetChainID(store)
+	pruned, err := GetPrunedPackets(store, chainID)
+	if err != nil {
+		return err
+	}
+	before := pruned
+
+	for i := 0; i < maxPrunePerBlock; i++ {
+		receiptKey := ReceiptKey(ours, chainID, pruned.Egress)
+		if !exists(store, receiptKey) {
+			break
+		}
+		store.Delete(PacketKey(ours, chainID, pruned.Egress))
+		store.Delete(receiptKey)
+		pruned.Egress++
+	}
+
+	conn, _, err := GetConnection(store, ours, chainID)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < maxPrunePerBlock && pruned.Ingress < conn.NextSequence; i++ {
+		store.Delete(toKey(_IBC, _INGRESS, ours, chainID, cmn.Fmt("%v", pruned.Ingress)))
+		pruned.Ingress++
+	}
+
+	if pruned != before {
+		save(store, PrunedPacketsKey(chainID), pruned)
+	}
+	return nil
+}
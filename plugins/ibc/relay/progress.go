@@ -0,0 +1,70 @@
+package relay
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Progress is saved after every batch, so a restarted relayer
+// continues where it stopped. It is only kept in memory if file is empty.
+type Progress struct {
+	file  string
+	Paths map[string]*PathProgress `json:"paths"`
+}
+
+// PathProgress tracks the packets sent from one chain to another
+type PathProgress struct {
+	NextPacket uint64 `json:"next_packet"` // the next packet to post on the dst chain
+	NextAck    uint64 `json:"next_ack"`    // the next ack to relay back to the src chain
+}
+
+// LoadProgress reads the progress from file, or starts from scratch
+// if there is none yet
+func LoadProgress(file string) (*Progress, error) {
+	progress := &Progress{file: file}
+	if file != "" {
+		bz, err := ioutil.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			err = json.Unmarshal(bz, progress)
+			if err != nil {
+				return nil, errors.Wrap(err, "reading relay progress")
+			}
+		}
+	}
+	if progress.Paths == nil {
+		progress.Paths = make(map[string]*PathProgress)
+	}
+	return progress, nil
+}
+
+// Path returns the progress of the packets from src to dst
+func (p *Progress) Path(src, dst string) *PathProgress {
+	key := src + "/" + dst
+	if p.Paths[key] == nil {
+		p.Paths[key] = new(PathProgress)
+	}
+	return p.Paths[key]
+}
+
+// Save writes a new file and renames it, so a crash never leaves half of it
+func (p *Progress) Save() error {
+	if p.file == "" {
+		return nil
+	}
+	bz, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := p.file + ".tmp"
+	err = ioutil.WriteFile(tmp, bz, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.file)
+}
@@ -0,0 +1,326 @@
+// Package relay moves IBC packets, acks and connection handshakes
+// between chains running the ibc plugin.
+package relay
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/tepleton/go-wire"
+	"github.com/tepleton/merkleeyes/iavl"
+	"github.com/tepleton/tmlibs/log"
+
+	"github.com/tepleton/basecoin/plugins/ibc"
+	tmtypes "github.com/tepleton/tepleton/types"
+)
+
+// BatchSize is the most packets or acks sent in one tx,
+// so it stays below the max tx size of the app
+const BatchSize = 4
+
+// Chain is what the relayer needs from a chain
+type Chain interface {
+	ChainID() string
+	// Query returns the value and proof of key, with the height of
+	// the header that proves it
+	Query(key []byte) (value []byte, proof *iavl.IAVLProof, height uint64, err error)
+	// Commit returns the header and commit of the block at height
+	Commit(height uint64) (tmtypes.Header, tmtypes.Commit, error)
+	// Validators returns the validators that signed the block at height
+	Validators(height uint64) ([]*tmtypes.Validator, error)
+	// SendTx signs an AppTx for the ibc plugin, and waits until it is committed
+	SendTx(tx ibc.IBCTx) error
+	// Subscribe gets notified on blocks, it must never block on sending
+	Subscribe(blocks chan<- struct{}) error
+}
+
+// Relayer relays all packets, acks and connection handshakes
+// between all pairs of chains
+type Relayer struct {
+	chains   []Chain
+	progress *Progress
+	logger   log.Logger
+}
+
+func NewRelayer(chains []Chain, progress *Progress, logger log.Logger) *Relayer {
+	return &Relayer{
+		chains:   chains,
+		progress: progress,
+		logger:   logger,
+	}
+}
+
+// Run relays on every new block of any chain, until quit is closed
+func (r *Relayer) Run(quit <-chan struct{}) error {
+	blocks := make(chan struct{}, 1)
+	for _, chain := range r.chains {
+		err := chain.Subscribe(blocks)
+		if err != nil {
+			return errors.Wrapf(err, "subscribing to %s", chain.ChainID())
+		}
+	}
+
+	for {
+		r.RelayAll()
+		select {
+		case <-quit:
+			return nil
+		case <-blocks:
+		}
+	}
+}
+
+// RelayAll relays everything that is ready in both directions of all paths.
+// Errors are logged, and the path is retried on the next call.
+// It returns the last error, if any.
+func (r *Relayer) RelayAll() (err error) {
+	for _, src := range r.chains {
+		for _, dst := range r.chains {
+			if src == dst {
+				continue
+			}
+			logger := r.logger.With("src-chain", src.ChainID(), "dst-chain", dst.ChainID())
+			if e := r.relayConnection(src, dst); e != nil {
+				logger.Error("Error relaying connection", "error", e.Error())
+				err = e
+				continue
+			}
+			if e := r.relayPackets(src, dst); e != nil {
+				logger.Error("Error relaying packets", "error", e.Error())
+				err = e
+			}
+			if e := r.relayAcks(src, dst); e != nil {
+				logger.Error("Error relaying acks", "error", e.Error())
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// relayConnection moves the handshake forward on dst, if the connection
+// on src is one step ahead
+func (r *Relayer) relayConnection(src, dst Chain) error {
+	srcConn, proof, height, err := QueryConnection(src, dst.ChainID())
+	if err != nil || proof == nil {
+		return err
+	}
+	dstConn, _, _, err := QueryConnection(dst, src.ChainID())
+	if err != nil {
+		return err
+	}
+
+	cp := ibc.ConnectionProof{
+		FromChainHeight: height,
+		Connection:      srcConn,
+		Proof:           proof,
+	}
+	var tx ibc.IBCTx
+	switch {
+	case srcConn.State == ibc.ConnectionInit && dstConn.State == "":
+		tx = ibc.IBCConnectionTryTx{cp}
+	case srcConn.State == ibc.ConnectionTryOpen && dstConn.State == ibc.ConnectionInit:
+		tx = ibc.IBCConnectionAckTx{cp}
+	case srcConn.State == ibc.ConnectionOpen && dstConn.State == ibc.ConnectionTryOpen:
+		tx = ibc.IBCConnectionConfirmTx{cp}
+	case srcConn.State == ibc.ConnectionClosed && dstConn.State != "" && !dstConn.Refunded:
+		tx = ibc.IBCConnectionCloseConfirmTx{cp}
+	default:
+		return nil
+	}
+
+	updateTx, err := UpdateChainTx(src, dst, height)
+	if err != nil {
+		return err
+	}
+	r.logger.Info("Relaying connection", "src-chain", src.ChainID(), "dst-chain", dst.ChainID(), "state", srcConn.State)
+	return dst.SendTx(ibc.IBCMultiTx{[]ibc.IBCTx{updateTx, tx}})
+}
+
+// relayPackets posts the next packets from src on dst, with the headers
+// to prove them, in one tx
+func (r *Relayer) relayPackets(src, dst Chain) error {
+	progress := r.progress.Path(src.ChainID(), dst.ChainID())
+
+	// the number of packets sent
+	value, _, _, err := src.Query(ibc.SequenceKey(src.ChainID(), dst.ChainID()))
+	if err != nil || len(value) == 0 {
+		return err
+	}
+	count, err := strconv.ParseUint(string(value), 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "parsing sequence number")
+	}
+
+	// dst only takes packets on an open connection, and tells us
+	// which one is next, in case our progress was lost
+	dstConn, _, _, err := QueryConnection(dst, src.ChainID())
+	if err != nil || dstConn.State != ibc.ConnectionOpen {
+		return err
+	}
+	if dstConn.NextSequence > progress.NextPacket {
+		progress.NextPacket = dstConn.NextSequence
+	}
+
+	var txs []ibc.IBCTx
+	updated := make(map[uint64]bool)
+	next := progress.NextPacket
+	for ; next < count && next < progress.NextPacket+BatchSize; next++ {
+		value, proof, height, err := src.Query(ibc.PacketKey(src.ChainID(), dst.ChainID(), next))
+		if err != nil {
+			return err
+		}
+		if proof == nil {
+			return errors.Errorf("Packet %d not found", next)
+		}
+		var packet ibc.Packet
+		err = wire.ReadBinaryBytes(value, &packet)
+		if err != nil {
+			return errors.Wrap(err, "unmarshalling packet")
+		}
+
+		if !updated[height] {
+			updateTx, err := UpdateChainTx(src, dst, height)
+			if err != nil {
+				return err
+			}
+			txs = append(txs, updateTx)
+			updated[height] = true
+		}
+		txs = append(txs, ibc.IBCPacketPostTx{
+			FromChainID:     src.ChainID(),
+			FromChainHeight: height,
+			Packet:          packet,
+			Proof:           proof,
+		})
+	}
+	if len(txs) == 0 {
+		return nil
+	}
+
+	r.logger.Info("Relaying packets", "src-chain", src.ChainID(), "dst-chain", dst.ChainID(),
+		"from", progress.NextPacket, "to", next-1)
+	err = dst.SendTx(ibc.IBCMultiTx{txs})
+	if err != nil {
+		return err
+	}
+	progress.NextPacket = next
+	return r.progress.Save()
+}
+
+// relayAcks proves the acks of the packets we posted on dst, back on src
+func (r *Relayer) relayAcks(src, dst Chain) error {
+	progress := r.progress.Path(src.ChainID(), dst.ChainID())
+
+	var txs []ibc.IBCTx
+	updated := make(map[uint64]bool)
+	next := progress.NextAck
+	for ; next < progress.NextPacket && len(txs) < 2*BatchSize; next++ {
+		// skip the acks src already got
+		value, _, _, err := src.Query(ibc.ReceiptKey(src.ChainID(), dst.ChainID(), next))
+		if err != nil {
+			return err
+		}
+		if len(value) > 0 {
+			continue
+		}
+
+		value, proof, height, err := dst.Query(ibc.AckKey(src.ChainID(), dst.ChainID(), next))
+		if err != nil {
+			return err
+		}
+		if proof == nil {
+			break // not committed yet
+		}
+		var ack ibc.Acknowledgement
+		err = wire.ReadBinaryBytes(value, &ack)
+		if err != nil {
+			return errors.Wrap(err, "unmarshalling ack")
+		}
+
+		if !updated[height] {
+			updateTx, err := UpdateChainTx(dst, src, height)
+			if err != nil {
+				return err
+			}
+			txs = append(txs, updateTx)
+			updated[height] = true
+		}
+		txs = append(txs, ibc.IBCPacketAckTx{
+			FromChainID:     dst.ChainID(),
+			FromChainHeight: height,
+			Acknowledgement: ack,
+			Proof:           proof,
+		})
+	}
+
+	if len(txs) > 0 {
+		r.logger.Info("Relaying acks", "src-chain", src.ChainID(), "dst-chain", dst.ChainID(),
+			"from", progress.NextAck, "to", next-1)
+		err := src.SendTx(ibc.IBCMultiTx{txs})
+		if err != nil {
+			return err
+		}
+	}
+	if next == progress.NextAck {
+		return nil
+	}
+	progress.NextAck = next
+	return r.progress.Save()
+}
+
+// QueryConnection returns the connection from the chain to the counterparty,
+// with an empty State if there is none
+func QueryConnection(chain Chain, counterparty string) (conn ibc.Connection, proof *iavl.IAVLProof, height uint64, err error) {
+	value, proof, height, err := chain.Query(ibc.ConnectionKey(chain.ChainID(), counterparty))
+	if err != nil || len(value) == 0 {
+		return conn, nil, height, err
+	}
+	err = wire.ReadBinaryBytes(value, &conn)
+	if err != nil {
+		return conn, nil, height, errors.Wrap(err, "unmarshalling connection")
+	}
+	return conn, proof, height, nil
+}
+
+// UpdateChainTx posts the header of src at height on dst, to prove
+// anything queried at that height. If the validators of src changed
+// since the last header dst got, the new ones are posted with it.
+func UpdateChainTx(src, dst Chain, height uint64) (ibc.IBCTx, error) {
+	header, commit, err := src.Commit(height)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching header and commit at %d", height)
+	}
+	tx := ibc.IBCUpdateChainTx{
+		Header: header,
+		Commit: commit,
+	}
+
+	value, _, _, err := dst.Query(ibc.ChainStateKey(src.ChainID()))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, errors.Errorf("%s is not registered on %s", src.ChainID(), dst.ChainID())
+	}
+	var state ibc.BlockchainState
+	err = wire.ReadBinaryBytes(value, &state)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshalling chain state")
+	}
+	if bytes.Equal(tmtypes.NewValidatorSet(state.Validators).Hash(), header.ValidatorsHash) {
+		return tx, nil
+	}
+
+	vals, err := src.Validators(height)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching validators at %d", height)
+	}
+	if !bytes.Equal(tmtypes.NewValidatorSet(vals).Hash(), header.ValidatorsHash) {
+		return nil, errors.Errorf("Validators don't match the header at %d", height)
+	}
+	tx.NextValidators = vals
+	return tx, nil
+}
@@ -0,0 +1,118 @@
+package relay_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tepleton/tmlibs/log"
+
+	"github.com/tepleton/basecoin/plugins/ibc"
+	"github.com/tepleton/basecoin/plugins/ibc/ibctest"
+	"github.com/tepleton/basecoin/plugins/ibc/relay"
+	"github.com/tepleton/basecoin/types"
+)
+
+func TestRelay(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	sender := types.PrivAccountFromSecret("sender")
+	sender.Account.Balance = types.Coins{{"mycoin", 100}}
+	receiver := []byte("receiver")
+	chainA, err := ibctest.NewChain("chain_a", 1, log.TestingLogger(), sender)
+	require.Nil(err)
+	chainB, err := ibctest.NewChain("chain_b", 1, log.TestingLogger())
+	require.Nil(err)
+
+	// what relay init does
+	genesisA, err := chainA.Genesis()
+	require.Nil(err)
+	genesisB, err := chainB.Genesis()
+	require.Nil(err)
+	require.Nil(chainA.SendTx(ibc.IBCRegisterChainTx{ibc.BlockchainGenesis{"chain_b", genesisB}}))
+	require.Nil(chainB.SendTx(ibc.IBCRegisterChainTx{ibc.BlockchainGenesis{"chain_a", genesisA}}))
+	require.Nil(chainA.SendTx(ibc.IBCConnectionInitTx{Counterparty: "chain_b"}))
+
+	send := func() {
+		coins := types.Coins{{"mycoin", 10}}
+		_, err := chainA.SendPacket(sender, "chain_b", ibc.CoinsPayload{receiver, coins}, 0)
+		require.Nil(err)
+	}
+	relayAll := func(r *relay.Relayer) {
+		// the handshake takes a round per step
+		for i := 0; i < 4; i++ {
+			require.Nil(r.RelayAll())
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "relay")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	progressFile := path.Join(dir, "progress.json")
+
+	progress, err := relay.LoadProgress(progressFile)
+	require.Nil(err)
+	r := relay.NewRelayer([]relay.Chain{chainA, chainB}, progress, log.TestingLogger())
+
+	// the packet is sent before the connection is open,
+	// and relayed after the handshake
+	send()
+	relayAll(r)
+	connA, _, _, err := relay.QueryConnection(chainA, "chain_b")
+	require.Nil(err)
+	assert.Equal(ibc.ConnectionOpen, connA.State)
+	connB, _, _, err := relay.QueryConnection(chainB, "chain_a")
+	require.Nil(err)
+	assert.Equal(ibc.ConnectionOpen, connB.State)
+	assert.Equal(types.Coins{{"ibc/chain_a/mycoin", 10}}, chainB.Balance(receiver))
+	receipt, _, _, err := chainA.Query(ibc.ReceiptKey("chain_a", "chain_b", 0))
+	require.Nil(err)
+	assert.NotEmpty(receipt)
+
+	// nothing is relayed twice
+	heightA, heightB := chainA.Height(), chainB.Height()
+	require.Nil(r.RelayAll())
+	assert.Equal(heightA, chainA.Height())
+	assert.Equal(heightB, chainB.Height())
+
+	// a new relayer continues where the last one stopped
+	progress, err = relay.LoadProgress(progressFile)
+	require.Nil(err)
+	assert.Equal(relay.PathProgress{NextPacket: 1, NextAck: 1}, *progress.Path("chain_a", "chain_b"))
+	r = relay.NewRelayer([]relay.Chain{chainA, chainB}, progress, log.TestingLogger())
+
+	send()
+	send()
+	relayAll(r)
+	assert.Equal(types.Coins{{"ibc/chain_a/mycoin", 30}}, chainB.Balance(receiver))
+	assert.Equal(relay.PathProgress{NextPacket: 3, NextAck: 3}, *progress.Path("chain_a", "chain_b"))
+	for _, seq := range []uint64{1, 2} {
+		receipt, _, _, err := chainA.Query(ibc.ReceiptKey("chain_a", "chain_b", seq))
+		require.Nil(err)
+		assert.NotEmpty(receipt, seq)
+	}
+}
+
+func TestRelayRun(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	chainA, err := ibctest.NewChain("chain_a", 1, log.TestingLogger())
+	require.Nil(err)
+	chainB, err := ibctest.NewChain("chain_b", 1, log.TestingLogger())
+	require.Nil(err)
+	h, err := ibctest.NewHarness(chainA, chainB, log.TestingLogger())
+	require.Nil(err)
+
+	// Run relays once before waiting for blocks, and stops on quit
+	quit := make(chan struct{})
+	close(quit)
+	heightB := chainB.Height()
+	require.Nil(h.Relayer.Run(quit))
+	assert.Equal(heightB, chainB.Height())
+}
@@ -5,6 +5,7 @@ import (
 	cmn "github.com/tepleton/tmlibs/common"
 	"github.com/tepleton/tmlibs/events"
 
+	"github.com/tepleton/basecoin/errors"
 	"github.com/tepleton/basecoin/plugins/ibc"
 	"github.com/tepleton/basecoin/types"
 )
@@ -25,6 +26,11 @@ func ExecTx(state *State, pgz *types.Plugins, tx types.Tx, isCheckTx bool, evc e
 		if res.IsErr() {
 			return res.PrependLog("in validateOutputsBasic()")
 		}
+		// the escrow of an IBC output is refunded to the sender,
+		// so there must be only one
+		if len(tx.Inputs) > 1 && hasIBCOutputs(tx.Outputs) {
+			return wrsp.ErrBaseInvalidInput.AppendLog("IBC outputs need a single input, that gets the refund")
+		}
 
 		// Get inputs
 		accounts, res := getInputs(state, tx.Inputs)
@@ -58,7 +64,7 @@ func ExecTx(state *State, pgz *types.Plugins, tx types.Tx, isCheckTx bool, evc e
 
 		// Good! Adjust accounts
 		adjustByInputs(state, accounts, tx.Inputs)
-		adjustByOutputs(state, accounts, tx.Outputs, isCheckTx)
+		adjustByOutputs(state, accounts, tx.Outputs, tx.Inputs[0].Address, isCheckTx)
 
 		/*
 			// Fire events
@@ -77,8 +83,8 @@ func ExecTx(state *State, pgz *types.Plugins, tx types.Tx, isCheckTx bool, evc e
 		return wrsp.NewResultOK(types.TxID(chainID, tx), "")
 
 	case *types.AppTx:
-		// Validate input, basic
-		res := tx.Input.ValidateBasic()
+		// Validate input and fee, basic
+		res := tx.ValidateBasic()
 		if res.IsErr() {
 			return res
 		}
@@ -99,9 +105,10 @@ func ExecTx(state *State, pgz *types.Plugins, tx types.Tx, isCheckTx bool, evc e
 			state.logger.Info(cmn.Fmt("validateInputAdvanced failed on %X: %v", tx.Input.Address, res))
 			return res.PrependLog("in validateInputAdvanced()")
 		}
-		if !tx.Input.Coins.IsGTE(types.Coins{tx.Fee}) {
+		maxFee := tx.MaxFee()
+		if !tx.Input.Coins.IsGTE(maxFee) {
 			state.logger.Info(cmn.Fmt("Sender did not send enough to cover the fee %X", tx.Input.Address))
-			return wrsp.ErrBaseInsufficientFunds.AppendLog(cmn.Fmt("input coins is %v, but fee is %v", tx.Input.Coins, types.Coins{tx.Fee}))
+			return wrsp.ErrBaseInsufficientFunds.AppendLog(cmn.Fmt("input coins is %v, but fee is %v", tx.Input.Coins, maxFee))
 		}
 
 		// Validate call address
@@ -112,7 +119,7 @@ func ExecTx(state *State, pgz *types.Plugins, tx types.Tx, isCheckTx bool, evc e
 		}
 
 		// Good!
-		coins := tx.Input.Coins.Minus(types.Coins{tx.Fee})
+		coins := tx.Input.Coins.Minus(maxFee)
 		inAcc.Sequence += 1
 		inAcc.Balance = inAcc.Balance.Minus(tx.Input.Coins)
 
@@ -122,16 +129,22 @@ func ExecTx(state *State, pgz *types.Plugins, tx types.Tx, isCheckTx bool, evc e
 			return wrsp.OK
 		}
 
-		// Create inAcc checkpoint
-		inAccCopy := inAcc.Copy()
+		// The fee and sequence are always taken, even if the plugin fails
+		feeAcc := inAcc.Copy()
+		feeAcc.Balance = feeAcc.Balance.Plus(coins)
+		state.SetAccount(tx.Input.Address, feeAcc)
 
-		// Run the tx.
+		// Run the tx in a cache, so a failure rolls back everything the
+		// plugin did, including taking the coins
 		cache := state.CacheWrap()
 		cache.SetAccount(tx.Input.Address, inAcc)
 		ctx := types.NewCallContext(tx.Input.Address, inAcc, coins)
-		res = plugin.RunTx(cache, ctx, tx.Data)
+		if tx.Gas > 0 {
+			ctx.GasMeter = types.NewGasMeter(tx.Gas)
+		}
+		res = runPlugin(plugin, cache, ctx, tx.Data)
 		if res.IsOK() {
-			cache.CacheSync()
+			cache.Write()
 			state.logger.Info("Successful execution")
 			// Fire events
 			/*
@@ -146,11 +159,14 @@ func ExecTx(state *State, pgz *types.Plugins, tx types.Tx, isCheckTx bool, evc e
 			*/
 		} else {
 			state.logger.Info("AppTx failed", "error", res)
-			// Just return the coins and return.
-			inAccCopy.Balance = inAccCopy.Balance.Plus(coins)
-			// But take the gas
-			// TODO
-			state.SetAccount(tx.Input.Address, inAccCopy)
+			cache.Discard()
+		}
+
+		// Take the gas used, and refund the rest
+		if refund := ctx.GasMeter.GasRemaining() * tx.Fee.Amount; refund > 0 {
+			acc := state.GetAccount(tx.Input.Address)
+			acc.Balance = acc.Balance.Plus(types.Coins{{tx.Fee.Denom, refund}})
+			state.SetAccount(tx.Input.Address, acc)
 		}
 		return res
 
@@ -161,6 +177,28 @@ func ExecTx(state *State, pgz *types.Plugins, tx types.Tx, isCheckTx bool, evc e
 
 //--------------------------------------------------------------------------------
 
+// runPlugin charges the signature and all store access to the gas meter,
+// and turns running out of gas into an error result
+func runPlugin(plugin types.Plugin, store types.KVStore, ctx types.CallContext, txBytes []byte) (res wrsp.Result) {
+	defer func() {
+		if r := recover(); r != nil {
+			oog, ok := r.(types.OutOfGas)
+			if !ok {
+				panic(r)
+			}
+			res = errors.Result(errors.OutOfGas()).AppendLog(oog.Error())
+		}
+	}()
+
+	if ctx.GasMeter != nil {
+		ctx.GasMeter.ConsumeGas(types.GasCostSignature, "signature")
+		store = types.NewGasKVStore(ctx.GasMeter, store)
+	}
+	return plugin.RunTx(store, ctx, txBytes)
+}
+
+//--------------------------------------------------------------------------------
+
 // The accounts from the TxInputs must either already have
 // crypto.PubKey.(type) != nil, (it must be known),
 // or it must be specified in the TxInput.
@@ -289,12 +327,26 @@ func adjustByInputs(state types.AccountSetter, accounts map[string]*types.Accoun
 	}
 }
 
-func adjustByOutputs(state *State, accounts map[string]*types.Account, outs []types.TxOutput, isCheckTx bool) {
+// hasIBCOutputs is true if any output goes to another chain
+func hasIBCOutputs(outs []types.TxOutput) bool {
+	for _, out := range outs {
+		destChain, _, _ := out.ChainAndAddress()
+		if destChain != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// adjustByOutputs credits the outputs, and escrows the IBC outputs
+// for the single input, that gets them back if the packet is rejected
+func adjustByOutputs(state *State, accounts map[string]*types.Account, outs []types.TxOutput, refund []byte, isCheckTx bool) {
 	for _, out := range outs {
 		destChain, outAddress, _ := out.ChainAndAddress() // already validated
 		if destChain != nil {
 			payload := ibc.CoinsPayload{outAddress, out.Coins}
-			ibc.SaveNewIBCPacket(state, state.GetChainID(), string(destChain), payload)
+			packet := ibc.SaveNewIBCPacket(state, state.GetChainID(), string(destChain), payload)
+			ibc.SetEscrow(state, packet, refund)
 			continue
 		}
 
@@ -254,7 +254,7 @@ func TestAdjustBy(t *testing.T) {
 	accMap, _ = getOrMakeOutputs(et.state, accMap, txOut)
 
 	adjustByInputs(et.state, accMap, txIn)
-	adjustByOutputs(et.state, accMap, txOut, false)
+	adjustByOutputs(et.state, accMap, txOut, txIn[0].Address, false)
 
 	endBalIn := accMap[string(et.accIn.Account.PubKey.Address())].Balance
 	endBalOut := accMap[string(et.accOut.Account.PubKey.Address())].Balance
@@ -351,3 +351,19 @@ func TestSendTxIBC(t *testing.T) {
 	assert.Equal(coins.Coins, tx.Outputs[0].Coins)
 	assert.EqualValues(coins.Address, dstAddress)
 }
+
+func TestSendTxIBCMultiInput(t *testing.T) {
+	assert := assert.New(t)
+	et := newExecTest()
+
+	// the refund of the escrow can't go to both inputs
+	accIn2 := types.MakeAcc("baz")
+	tx := types.MakeSendTx(1, et.accOut, et.accIn, accIn2)
+	tx.Outputs[0].Address = []byte("otherchain/" + string(tx.Outputs[0].Address))
+	et.acc2State(et.accIn, accIn2)
+	et.signTx(tx, et.accIn, accIn2)
+
+	res := ExecTx(et.state, nil, tx, false, nil)
+	assert.Equal(wrsp.CodeType_BaseInvalidInput, res.Code, res.Log)
+	assert.Nil(et.state.Get(ibc.PacketKey(et.chainID, "otherchain", 0)))
+}
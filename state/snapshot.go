@@ -0,0 +1,246 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/tepleton/basecoin/types"
+)
+
+// A snapshot holds every key/value pair of the committed state.
+//
+// The format is a header, followed by a stream of chunks:
+//
+//	header: magic (8 bytes) | height (uint64) | app hash (uint32 length + bytes)
+//	chunk:  count (uint32) | length (uint32) | payload | sha256(payload)
+//	payload: count times key and value (each uint32 length + bytes)
+//
+// The last chunk has a count of zero, and is followed by the total
+// number of pairs (uint64), so a truncated file is always detected.
+// All integers are big endian.
+const (
+	snapshotMagic = "BCSNAP01"
+
+	// SnapshotChunkSize is the payload size at which a chunk is closed
+	SnapshotChunkSize = 1 << 20
+
+	maxSnapshotField = 1 << 26 // sanity limit when reading a length
+)
+
+// SnapshotHeader describes the state a snapshot was taken from
+type SnapshotHeader struct {
+	Height  uint64
+	AppHash []byte
+}
+
+// WriteSnapshot streams all key/value pairs in the store to w.
+// The store must not change while writing, so only use this on
+// committed state. It returns the number of pairs written.
+func WriteSnapshot(w io.Writer, store types.KVStore, header SnapshotHeader) (int, error) {
+	bw := bufio.NewWriter(w)
+	sw := &snapshotWriter{w: bw}
+	sw.write([]byte(snapshotMagic))
+	sw.writeUint64(header.Height)
+	sw.writeBytes(header.AppHash)
+
+	var payload bytes.Buffer
+	count, total := 0, 0
+	for it := store.Iterator(nil, nil); it.Valid() && sw.err == nil; it.Next() {
+		pw := &snapshotWriter{w: &payload}
+		pw.writeBytes(it.Key())
+		pw.writeBytes(it.Value())
+		count++
+		total++
+		if payload.Len() >= SnapshotChunkSize {
+			sw.writeChunk(count, payload.Bytes())
+			payload.Reset()
+			count = 0
+		}
+	}
+	if count > 0 {
+		sw.writeChunk(count, payload.Bytes())
+	}
+	sw.writeChunk(0, nil)
+	sw.writeUint64(uint64(total))
+	if sw.err != nil {
+		return 0, errors.Wrap(sw.err, "writing snapshot")
+	}
+	return total, errors.Wrap(bw.Flush(), "writing snapshot")
+}
+
+// ReadSnapshot verifies every chunk, and sets all pairs in the store.
+// On error, the store may hold part of the snapshot, so restore into
+// a cache or a fresh store. It is up to the caller to commit the store
+// and compare the root hash to the AppHash in the returned header.
+func ReadSnapshot(r io.Reader, store types.KVStore) (SnapshotHeader, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+	var header SnapshotHeader
+
+	magic := sr.read(len(snapshotMagic))
+	if sr.err == nil && string(magic) != snapshotMagic {
+		return header, errors.New("not a basecoin snapshot")
+	}
+	header.Height = sr.readUint64()
+	header.AppHash = sr.readBytes()
+
+	total := uint64(0)
+	for chunk := 0; sr.err == nil; chunk++ {
+		count := sr.readUint32()
+		payload := sr.readBytes()
+		checksum := sr.read(sha256.Size)
+		if sr.err != nil {
+			break
+		}
+		sum := sha256.Sum256(payload)
+		if !bytes.Equal(sum[:], checksum) {
+			return header, errors.Errorf("snapshot chunk %d: checksum mismatch", chunk)
+		}
+		if count == 0 {
+			break
+		}
+
+		pr := &snapshotReader{r: bytes.NewReader(payload)}
+		for i := uint32(0); i < count; i++ {
+			key, value := pr.readBytes(), pr.readBytes()
+			if pr.err != nil {
+				return header, errors.Wrapf(pr.err, "snapshot chunk %d", chunk)
+			}
+			store.Set(key, value)
+		}
+		total += uint64(count)
+	}
+
+	expected := sr.readUint64()
+	if sr.err != nil {
+		return header, errors.Wrap(sr.err, "reading snapshot")
+	}
+	if expected != total {
+		return header, errors.Errorf("snapshot has %d pairs, expected %d", total, expected)
+	}
+	return header, nil
+}
+
+// RestoreSnapshot rebuilds the state from a snapshot in an empty
+// MerkleEyes, commits it, and checks the root hash against the header.
+// MerkleEyes counts its height in commits, so the restored tree is
+// committed again until Info reports the height of the snapshot.
+func RestoreSnapshot(r io.Reader, store *types.EyesStore) (SnapshotHeader, error) {
+	if store.Iterator(nil, nil).Valid() {
+		return SnapshotHeader{}, errors.New("MerkleEyes is not empty, reset it before restoring")
+	}
+	header, err := ReadSnapshot(r, store)
+	if err != nil {
+		return header, err
+	}
+	res := store.Commit()
+	if res.IsErr() {
+		return header, errors.Errorf("committing restored state: %s", res.Log)
+	}
+	if !bytes.Equal(res.Data, header.AppHash) {
+		return header, errors.Errorf("restored app hash %X doesn't match snapshot %X", res.Data, header.AppHash)
+	}
+
+	var last uint64
+	for {
+		info, err := store.Client().InfoSync()
+		if err != nil {
+			return header, errors.Wrap(err, "querying MerkleEyes")
+		}
+		if info.LastBlockHeight >= header.Height {
+			return header, nil
+		}
+		if last > 0 && info.LastBlockHeight <= last {
+			return header, errors.Errorf("MerkleEyes is stuck at height %d", info.LastBlockHeight)
+		}
+		last = info.LastBlockHeight
+		res = store.Commit()
+		if res.IsErr() {
+			return header, errors.Errorf("committing restored state: %s", res.Log)
+		}
+	}
+}
+
+//----------------------------------------
+
+// snapshotWriter remembers the first error, so we can check it once
+type snapshotWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (sw *snapshotWriter) write(bz []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(bz)
+	}
+}
+
+func (sw *snapshotWriter) writeUint32(i uint32) {
+	var bz [4]byte
+	binary.BigEndian.PutUint32(bz[:], i)
+	sw.write(bz[:])
+}
+
+func (sw *snapshotWriter) writeUint64(i uint64) {
+	var bz [8]byte
+	binary.BigEndian.PutUint64(bz[:], i)
+	sw.write(bz[:])
+}
+
+func (sw *snapshotWriter) writeBytes(bz []byte) {
+	sw.writeUint32(uint32(len(bz)))
+	sw.write(bz)
+}
+
+func (sw *snapshotWriter) writeChunk(count int, payload []byte) {
+	sum := sha256.Sum256(payload)
+	sw.writeUint32(uint32(count))
+	sw.writeBytes(payload)
+	sw.write(sum[:])
+}
+
+// snapshotReader remembers the first error, so we can check it once
+type snapshotReader struct {
+	r   io.Reader
+	err error
+}
+
+func (sr *snapshotReader) read(n int) []byte {
+	if sr.err != nil {
+		return nil
+	}
+	bz := make([]byte, n)
+	_, sr.err = io.ReadFull(sr.r, bz)
+	if sr.err == io.EOF {
+		sr.err = io.ErrUnexpectedEOF
+	}
+	return bz
+}
+
+func (sr *snapshotReader) readUint32() uint32 {
+	bz := sr.read(4)
+	if sr.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(bz)
+}
+
+func (sr *snapshotReader) readUint64() uint64 {
+	bz := sr.read(8)
+	if sr.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(bz)
+}
+
+func (sr *snapshotReader) readBytes() []byte {
+	n := sr.readUint32()
+	if sr.err == nil && n > maxSnapshotField {
+		sr.err = errors.Errorf("field of %d bytes is too large", n)
+	}
+	return sr.read(int(n))
+}
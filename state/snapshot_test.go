@@ -0,0 +1,124 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tepleton/basecoin/types"
+	eyes "github.com/tepleton/merkleeyes/client"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+
+	store := types.NewMemKVStore()
+	for i := 0; i < 100; i++ {
+		store.Set([]byte(fmt.Sprintf("key-%03d", i)), []byte(strconv.Itoa(i)))
+	}
+	// big values to fill a few chunks
+	big := bytes.Repeat([]byte{0x42}, SnapshotChunkSize/2+1)
+	for i := 0; i < 5; i++ {
+		store.Set([]byte(fmt.Sprintf("big-%d", i)), big)
+	}
+	header := SnapshotHeader{Height: 17, AppHash: []byte{0xde, 0xad}}
+
+	var buf bytes.Buffer
+	n, err := WriteSnapshot(&buf, store, header)
+	require.Nil(err, "%+v", err)
+	assert.Equal(105, n)
+	snap := buf.Bytes()
+
+	restored := types.NewMemKVStore()
+	h, err := ReadSnapshot(bytes.NewReader(snap), restored)
+	require.Nil(err, "%+v", err)
+	assert.Equal(header, h)
+	for it := store.Iterator(nil, nil); it.Valid(); it.Next() {
+		assert.Equal(it.Value(), restored.Get(it.Key()), "%s", it.Key())
+	}
+
+	// any corruption is detected
+	cases := [][]byte{
+		snap[:len(snap)-1],                // truncated total
+		snap[:len(snap)/2],                // truncated chunk
+		append([]byte("XX"), snap[2:]...), // bad magic
+	}
+	for idx, bz := range cases {
+		_, err := ReadSnapshot(bytes.NewReader(bz), types.NewMemKVStore())
+		assert.NotNil(err, "%d", idx)
+	}
+
+	// flip a byte in the middle of the payload
+	bad := append([]byte(nil), snap...)
+	bad[len(bad)/2] ^= 0x01
+	_, err = ReadSnapshot(bytes.NewReader(bad), types.NewMemKVStore())
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "checksum")
+	}
+}
+
+func TestSnapshotEyes(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+
+	store := types.NewEyesStore(eyes.NewLocalClient("", 0))
+	for i := 0; i < 50; i++ {
+		store.Set([]byte(fmt.Sprintf("key-%03d", i)), []byte(strconv.Itoa(i)))
+	}
+	res := store.Commit()
+	require.True(res.IsOK(), res.Log)
+	hash := res.Data
+
+	var buf bytes.Buffer
+	_, err := WriteSnapshot(&buf, store, SnapshotHeader{Height: 1, AppHash: hash})
+	require.Nil(err, "%+v", err)
+
+	// rebuild the tree in a new merkleeyes, and check the hash
+	restored := types.NewEyesStore(eyes.NewLocalClient("", 0))
+	header, err := ReadSnapshot(&buf, restored)
+	require.Nil(err, "%+v", err)
+	res = restored.Commit()
+	require.True(res.IsOK(), res.Log)
+	assert.EqualValues(header.AppHash, res.Data)
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+
+	store := types.NewEyesStore(eyes.NewLocalClient("", 0))
+	for i := 0; i < 50; i++ {
+		store.Set([]byte(fmt.Sprintf("key-%03d", i)), []byte(strconv.Itoa(i)))
+	}
+	res := store.Commit()
+	require.True(res.IsOK(), res.Log)
+	hash := res.Data
+
+	var buf bytes.Buffer
+	_, err := WriteSnapshot(&buf, store, SnapshotHeader{Height: 7, AppHash: hash})
+	require.Nil(err, "%+v", err)
+	snap := buf.Bytes()
+
+	// the restored store reports the height of the snapshot
+	restored := types.NewEyesStore(eyes.NewLocalClient("", 0))
+	header, err := RestoreSnapshot(bytes.NewReader(snap), restored)
+	require.Nil(err, "%+v", err)
+	assert.EqualValues(7, header.Height)
+	info, err := restored.Client().InfoSync()
+	require.Nil(err)
+	assert.EqualValues(7, info.LastBlockHeight)
+	assert.EqualValues(hash, info.LastBlockAppHash)
+
+	// only into an empty store
+	_, err = RestoreSnapshot(bytes.NewReader(snap), restored)
+	assert.NotNil(err)
+
+	// and only with the right hash
+	buf.Reset()
+	_, err = WriteSnapshot(&buf, store, SnapshotHeader{Height: 7, AppHash: []byte{0xde, 0xad}})
+	require.Nil(err, "%+v", err)
+	_, err = RestoreSnapshot(&buf, types.NewEyesStore(eyes.NewLocalClient("", 0)))
+	assert.NotNil(err)
+}
@@ -3,27 +3,32 @@ package state
 import (
 	wrsp "github.com/tepleton/wrsp/types"
 	"github.com/tepleton/basecoin/types"
-	eyes "github.com/tepleton/merkleeyes/client"
 	"github.com/tepleton/tmlibs/log"
 )
 
 // CONTRACT: State should be quick to copy.
 // See CacheWrap().
+//
+// A State may have a stack of cache layers on top of its store.
+// CacheWrap() returns a State whose first layer buffers all writes
+// to the parent until Write(), and every Savepoint() pushes another
+// layer that RollbackTo() can throw away without touching the rest,
+// or Release() can fold into the layer below.
 type State struct {
-	chainID    string
-	store      types.KVStore
-	readCache  map[string][]byte // optional, for caching writes to store
-	writeCache *types.KVCache    // optional, for caching writes w/o writing to store
-	logger     log.Logger
+	chainID string
+	store   types.KVStore    // the underlying store (or parent State)
+	caches  []*types.KVCache // optional, cache layers, last one is on top
+	wrapped bool             // true if caches[0] came from CacheWrap()
+	logger  log.Logger
 }
 
 func NewState(store types.KVStore) *State {
 	return &State{
-		chainID:    "",
-		store:      store,
-		readCache:  make(map[string][]byte),
-		writeCache: nil,
-		logger:     log.NewNopLogger(),
+		chainID: "",
+		store:   store,
+		caches:  nil,
+		wrapped: false,
+		logger:  log.NewNopLogger(),
 	}
 }
 
@@ -33,32 +38,39 @@ func (s *State) SetLogger(l log.Logger) {
 
 func (s *State) SetChainID(chainID string) {
 	s.chainID = chainID
-	s.store.Set([]byte("base/chain_id"), []byte(chainID))
+	types.SetChainID(s, chainID)
 }
 
 func (s *State) GetChainID() string {
 	if s.chainID != "" {
 		return s.chainID
 	}
-	s.chainID = string(s.store.Get([]byte("base/chain_id")))
+	s.chainID = types.GetChainID(s)
 	return s.chainID
 }
 
-func (s *State) Get(key []byte) (value []byte) {
-	if s.readCache != nil { //if not a cachewrap
-		value, ok := s.readCache[string(key)]
-		if ok {
-			return value
-		}
+// top returns the store all reads and writes should go to
+func (s *State) top() types.KVStore {
+	if n := len(s.caches); n > 0 {
+		return s.caches[n-1]
 	}
-	return s.store.Get(key)
+	return s.store
+}
+
+func (s *State) Get(key []byte) (value []byte) {
+	return s.top().Get(key)
 }
 
 func (s *State) Set(key []byte, value []byte) {
-	if s.readCache != nil { //if not a cachewrap
-		s.readCache[string(key)] = value
-	}
-	s.store.Set(key, value)
+	s.top().Set(key, value)
+}
+
+func (s *State) Delete(key []byte) {
+	s.top().Delete(key)
+}
+
+func (s *State) Iterator(start, end []byte) types.Iterator {
+	return s.top().Iterator(start, end)
 }
 
 func (s *State) GetAccount(addr []byte) *types.Account {
@@ -69,27 +81,90 @@ func (s *State) SetAccount(addr []byte, acc *types.Account) {
 	types.SetAccount(s, addr, acc)
 }
 
+// CacheWrap returns a new State that buffers all writes until Write().
+// It may be wrapped again, to any depth.
 func (s *State) CacheWrap() *State {
 	cache := types.NewKVCache(s)
 	return &State{
-		chainID:    s.chainID,
-		store:      cache,
-		readCache:  nil,
-		writeCache: cache,
-		logger:     s.logger,
+		chainID: s.chainID,
+		store:   s,
+		caches:  []*types.KVCache{cache},
+		wrapped: true,
+		logger:  s.logger,
+	}
+}
+
+// Write flushes all pending writes, including any savepoints,
+// to the parent State (or the underlying store).
+// The savepoints are released, the State remains usable.
+func (s *State) Write() {
+	for i := len(s.caches) - 1; i >= 0; i-- {
+		s.caches[i].Sync()
+	}
+	s.caches = s.caches[:s.base()]
+}
+
+// Discard throws away all pending writes, including any savepoints.
+// The State remains usable.
+func (s *State) Discard() {
+	s.caches = s.caches[:s.base()]
+	if s.wrapped {
+		s.caches[0].Reset()
 	}
 }
 
-// NOTE: errors if s is not from CacheWrap()
+// CacheSync is the same as Write, kept for backwards compatibility
 func (s *State) CacheSync() {
-	s.writeCache.Sync()
+	s.Write()
+}
+
+// Savepoint pushes a new cache layer, so that all writes from
+// now on can be undone by RollbackTo with the returned id.
+func (s *State) Savepoint() int {
+	s.caches = append(s.caches, types.NewKVCache(s.top()))
+	return len(s.caches) - 1
+}
+
+// RollbackTo discards all writes since the given savepoint was taken.
+// The savepoint itself remains valid, later ones are released.
+// It panics if the id is not from Savepoint on this State.
+func (s *State) RollbackTo(savepoint int) {
+	s.checkSavepoint(savepoint, "RollbackTo")
+	s.caches = s.caches[:savepoint+1]
+	s.caches[savepoint].Reset()
+}
+
+// Release keeps all writes since the given savepoint, by folding its
+// layer and all later ones into the layer below, and releases them.
+// It panics if the id is not from Savepoint on this State.
+func (s *State) Release(savepoint int) {
+	s.checkSavepoint(savepoint, "Release")
+	for i := len(s.caches) - 1; i >= savepoint; i-- {
+		s.caches[i].Sync()
+	}
+	s.caches = s.caches[:savepoint]
+}
+
+func (s *State) checkSavepoint(savepoint int, caller string) {
+	if savepoint < s.base() || savepoint >= len(s.caches) {
+		panic(caller + " called with unknown savepoint")
+	}
+}
+
+// base is the number of layers that are not savepoints
+func (s *State) base() int {
+	if s.wrapped {
+		return 1
+	}
+	return 0
 }
 
+// Commit writes any savepoints to the store, and commits it
 func (s *State) Commit() wrsp.Result {
-	switch s.store.(type) {
-	case *eyes.Client:
-		s.readCache = make(map[string][]byte)
-		return s.store.(*eyes.Client).CommitSync()
+	switch store := s.store.(type) {
+	case *types.EyesStore:
+		s.Write()
+		return store.Commit()
 	default:
 		return wrsp.NewError(wrsp.CodeType_InternalError, "can only use Commit if store is merkleeyes")
 	}
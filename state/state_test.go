@@ -2,6 +2,7 @@ package state
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/tepleton/basecoin/types"
@@ -19,7 +20,7 @@ func TestState(t *testing.T) {
 	state := NewState(store)
 	state.SetLogger(log.TestingLogger())
 	cache := state.CacheWrap()
-	eyesCli := eyes.NewLocalClient("", 0)
+	eyesCli := types.NewEyesStore(eyes.NewLocalClient("", 0))
 
 	//Account and address for tests
 	dumAddr := []byte("dummyAddress")
@@ -97,4 +98,92 @@ func TestState(t *testing.T) {
 	cache.CacheSync()
 	assert.True(state.Commit().IsOK(), "Bad Commit")
 	assert.True(storeHasAll(eyesCli), "eyesCli doesn't retrieve after Commit")
+
+	//Test Delete and iteration over committed and pending data
+	state.Delete([]byte("foo"))
+	state.Set([]byte("baz"), []byte("fish"))
+	var keys []string
+	for it := state.Iterator(nil, nil); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	assert.Equal([]string{"bar", "baz"}, keys, "Iterator doesn't merge pending writes")
+	assert.Nil(state.Get([]byte("foo")), "state retrieving after Delete")
+}
+
+func TestStateCacheLayers(t *testing.T) {
+	assert := assert.New(t)
+
+	store := types.NewMemKVStore()
+	state := NewState(store)
+	get := func(kv types.KVStore, key string) string {
+		return string(kv.Get([]byte(key)))
+	}
+
+	// nested caches only reach the store when every layer is written
+	outer := state.CacheWrap()
+	outer.Set([]byte("a"), []byte("1"))
+	inner := outer.CacheWrap()
+	inner.Set([]byte("b"), []byte("2"))
+	assert.Equal("1", get(inner, "a"), "inner cache must read through")
+	assert.Equal("", get(outer, "b"), "outer cache sees inner writes before Write")
+	inner.Write()
+	assert.Equal("2", get(outer, "b"), "outer cache doesn't see inner writes after Write")
+	assert.Equal("", get(store, "b"), "store sees writes before outer Write")
+
+	// discard drops the pending writes, but leaves the cache usable
+	inner.Set([]byte("c"), []byte("3"))
+	inner.Discard()
+	assert.Equal("", get(inner, "c"), "Discard didn't drop writes")
+	inner.Set([]byte("c"), []byte("4"))
+	inner.Write()
+	assert.Equal("4", get(outer, "c"), "cache unusable after Discard")
+
+	// roll back to savepoints, keeping earlier writes
+	sp1 := outer.Savepoint()
+	outer.Set([]byte("d"), []byte("5"))
+	sp2 := outer.Savepoint()
+	outer.Set([]byte("e"), []byte("6"))
+	outer.Delete([]byte("a"))
+	outer.RollbackTo(sp2)
+	assert.Equal("1", get(outer, "a"), "RollbackTo didn't undo the delete")
+	assert.Equal("", get(outer, "e"), "RollbackTo didn't undo the write")
+	assert.Equal("5", get(outer, "d"), "RollbackTo undid writes before the savepoint")
+	outer.RollbackTo(sp1)
+	assert.Equal("", get(outer, "d"), "RollbackTo didn't undo the write")
+	assert.Panics(func() { outer.RollbackTo(sp2) }, "released savepoint still valid")
+
+	// release folds the writes into the layer below
+	sp1 = outer.Savepoint()
+	outer.Set([]byte("d"), []byte("5"))
+	outer.Release(sp1)
+	assert.Equal("5", get(outer, "d"), "Release dropped the writes")
+	assert.Panics(func() { outer.Release(sp1) }, "released savepoint still valid")
+	outer.Delete([]byte("d"))
+
+	// Atomic rolls a failed sub-operation back to a savepoint,
+	// and releases it either way
+	depth := len(outer.caches)
+	err := types.Atomic(outer, func(kv types.KVStore) error {
+		kv.Set([]byte("g"), []byte("8"))
+		return errors.New("fail")
+	})
+	assert.NotNil(err)
+	assert.Equal("", get(outer, "g"), "Atomic didn't roll back")
+	assert.Equal(depth, len(outer.caches), "Atomic left a layer")
+	err = types.Atomic(outer, func(kv types.KVStore) error {
+		kv.Set([]byte("f"), []byte("7"))
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal("7", get(outer, "f"))
+	assert.Equal(depth, len(outer.caches), "Atomic left a layer")
+
+	outer.Write()
+	for _, k := range []string{"a", "b", "c", "f"} {
+		assert.NotEqual("", get(store, k), k)
+	}
+	assert.Equal("", get(store, "d"))
+
+	// CacheSync on a state not from CacheWrap is a no-op
+	assert.NotPanics(state.CacheSync)
 }
@@ -57,6 +57,7 @@ prepareClient() {
 # XXX Ex Usage2: initServer $ROOTDIR $CHAINID $PORTPREFIX
 # Desc: Grabs the Rich account and gives it all genesis money
 #       port-prefix default is 4665{6,7,8}
+#       INIT_FLAGS are passed on to init
 initServer() {
     echo "Setting up genesis..."
     SERVE_DIR=$1/server
@@ -65,7 +66,7 @@ initServer() {
     SERVER_LOG=$1/${SERVER_EXE}.log
 
     GENKEY=$(${CLIENT_EXE} keys get ${RICH} | awk '{print $2}')
-    ${SERVER_EXE} init --chain-id $CHAIN $GENKEY --home=$SERVE_DIR >>$SERVER_LOG
+    ${SERVER_EXE} init --chain-id $CHAIN $GENKEY $INIT_FLAGS --home=$SERVE_DIR >>$SERVER_LOG
 
     # optionally set the port
     if [ -n "$3" ]; then
@@ -35,13 +35,14 @@ oneTimeSetUp() {
     BC_HOME=${CLIENT_1} prepareClient
     BC_HOME=${CLIENT_2} prepareClient
 
-    # Start basecoin server, giving money to the key in the first client
-    BC_HOME=${CLIENT_1} initServer $BASE_DIR_1 $CHAIN_ID_1 $PREFIX_1
+    # Start basecoin server, giving money to the key in the first client,
+    # with the relay key as IBC admin
+    INIT_FLAGS=--relay-admin BC_HOME=${CLIENT_1} initServer $BASE_DIR_1 $CHAIN_ID_1 $PREFIX_1
     if [ $? != 0 ]; then return 1; fi
     PID_SERVER_1=$PID_SERVER
 
     # Start second basecoin server, giving money to the key in the second client
-    BC_HOME=${CLIENT_2} initServer $BASE_DIR_2 $CHAIN_ID_2 $PREFIX_2
+    INIT_FLAGS=--relay-admin BC_HOME=${CLIENT_2} initServer $BASE_DIR_2 $CHAIN_ID_2 $PREFIX_2
     if [ $? != 0 ]; then return 1; fi
     PID_SERVER_2=$PID_SERVER
 
@@ -112,11 +113,14 @@ test01SendIBCTx() {
     startRelay 2 1
     if [ $? != 0 ]; then echo "can't start relay"; cat ${BASE_DIR_1}/../relay.log; return 1; fi
 
-    # Give it a little time, then make sure the money arrived
+    # Give it time to open the connection and relay the packet,
+    # every step needs a new block on the other chain
     echo "waiting for relay..."
     sleep 1
-    waitForBlock ${PORT_1}
-    waitForBlock ${PORT_2}
+    for i in 1 2 3 4 5 6; do
+        waitForBlock ${PORT_1}
+        waitForBlock ${PORT_2}
+    done
 
     # Check the new account
     echo "checking ibc recipient..."
@@ -152,16 +156,17 @@ startRelay() {
     txSucceeded $? "$RES" "$RELAY_ADDR"
     if [ $? != 0 ]; then echo "can't pay chain2!"; return 1; fi
 
-    # Initialize the relay (register both chains)
+    # Initialize the relay (register both chains and start the connection)
     ${SERVER_EXE} relay init --chain1-id=$CHAIN_ID_1 --chain2-id=$CHAIN_ID_2 \
         --chain1-addr=tcp://localhost:${PORT_1} --chain2-addr=tcp://localhost:${PORT_2} \
         --genesis1=${BASE_DIR_1}/server/genesis.json --genesis2=${BASE_DIR_2}/server/genesis.json \
         --from=$RELAY_KEY > ${BASE_DIR_1}/../relay.log
     if [ $? != 0 ]; then echo "can't initialize relays"; cat ${BASE_DIR_1}/../relay.log; return 1; fi
 
-    # Now start the relay (constantly send packets)
+    # Now start the relay (relays on every new block)
     ${SERVER_EXE} relay start --chain1-id=$CHAIN_ID_1 --chain2-id=$CHAIN_ID_2 \
         --chain1-addr=tcp://localhost:${PORT_1} --chain2-addr=tcp://localhost:${PORT_2} \
+        --progress=${BASE_DIR_1}/../relay-progress.json \
         --from=$RELAY_KEY >> ${BASE_DIR_1}/../relay.log &
     sleep 2
     PID_RELAY=$!
@@ -1,5 +1,11 @@
 package basecoin
 
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+)
+
 // TxInner is the interface all concrete transactions should implement.
 //
 // It adds bindings for clean un/marhsaling of the various implementations
@@ -34,3 +40,42 @@ func (t Tx) GetLayer() TxLayer {
 	l, _ := t.Unwrap().(TxLayer)
 	return l
 }
+
+var (
+	typeNamesMtx sync.RWMutex
+	typeNames    = map[reflect.Type]string{}
+)
+
+// TypeName returns the name the concrete tx type was registered with
+// in TxMapper, or "" if it was never registered.
+//
+// The mapper only exposes the name in the json encoding,
+// so we look it up once per type and remember it.
+func (t Tx) TypeName() string {
+	inner := t.Unwrap()
+	if inner == nil {
+		return ""
+	}
+	rt := reflect.TypeOf(inner)
+	typeNamesMtx.RLock()
+	name, ok := typeNames[rt]
+	typeNamesMtx.RUnlock()
+	if ok {
+		return name
+	}
+
+	js, err := TxMapper.ToJSON(inner)
+	if err != nil {
+		return ""
+	}
+	var wrapped struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(js, &wrapped) != nil {
+		return ""
+	}
+	typeNamesMtx.Lock()
+	typeNames[rt] = wrapped.Type
+	typeNamesMtx.Unlock()
+	return wrapped.Type
+}
@@ -1,6 +1,8 @@
 package txs
 
 import (
+	"math"
+
 	"github.com/tepleton/basecoin"
 	"github.com/tepleton/basecoin/errors"
 	"github.com/tepleton/basecoin/types"
@@ -13,6 +15,7 @@ const (
 	ByteFees  = 0x2
 	ByteMulti = 0x3
 	ByteChain = 0x4
+	ByteNonce = 0x5
 
 	// for signatures
 	ByteSig      = 0x16
@@ -25,6 +28,7 @@ const (
 	TypeFees  = "fee"
 	TypeMulti = "multi"
 	TypeChain = "chain"
+	TypeNonce = "nonce"
 
 	// for signatures
 	TypeSig      = "sig"
@@ -40,7 +44,8 @@ func init() {
 		RegisterImplementation(Raw{}, TypeRaw, ByteRaw).
 		RegisterImplementation(&Fee{}, TypeFees, ByteFees).
 		RegisterImplementation(&MultiTx{}, TypeMulti, ByteMulti).
-		RegisterImplementation(&Chain{}, TypeChain, ByteChain)
+		RegisterImplementation(&Chain{}, TypeChain, ByteChain).
+		RegisterImplementation(&Nonce{}, TypeNonce, ByteNonce)
 }
 
 // Raw just contains bytes that can be hex-ified
@@ -65,19 +70,46 @@ func NewRaw(d []byte) Raw {
 
 /**** Fee ****/
 
-// Fee attaches a fee payment to the embedded tx
+// Fee attaches a fee payment to the embedded tx.
+//
+// If Gas is zero, Fee is a flat fee and execution is not metered.
+// Otherwise Gas is the limit, Fee the price per unit of gas,
+// and all unused gas is refunded to the Payer.
 type Fee struct {
 	Tx    basecoin.Tx `json:"tx"`
 	Fee   types.Coin  `json:"fee"`
 	Payer data.Bytes  `json:"payer"` // the address who pays the fee
-	// Gas types.Coin `json:"gas"`  // ?????
+	Gas   int64       `json:"gas"`   // the gas limit, 0 for a flat fee
 }
 
 func NewFee(tx basecoin.Tx, fee types.Coin, addr []byte) *Fee {
 	return &Fee{Tx: tx, Fee: fee, Payer: addr}
 }
 
+// NewGasFee pays gasPrice per unit of gas used, up to the limit
+func NewGasFee(tx basecoin.Tx, gasPrice types.Coin, gas int64, addr []byte) *Fee {
+	return &Fee{Tx: tx, Fee: gasPrice, Payer: addr, Gas: gas}
+}
+
+// MaxFee is the most this tx can cost
+func (f *Fee) MaxFee() types.Coins {
+	if f.Gas == 0 {
+		return types.Coins{f.Fee}
+	}
+	return types.Coins{{f.Fee.Denom, f.Fee.Amount * f.Gas}}
+}
+
 func (f *Fee) ValidateBasic() error {
+	if f.Gas < 0 {
+		return errors.InvalidFormat()
+	}
+	if f.Fee.Amount < 0 {
+		return errors.InvalidCoins()
+	}
+	// the max fee must not overflow
+	if f.Fee.Amount > 0 && f.Gas > math.MaxInt64/f.Fee.Amount {
+		return errors.InvalidCoins()
+	}
 	// TODO: more checks
 	return f.Tx.ValidateBasic()
 }
@@ -115,10 +147,12 @@ func (mt *MultiTx) ValidateBasic() error {
 
 /*** Chain ****/
 
-// Chain locks this tx to one chain, wrap with this before signing
+// Chain locks this tx to one chain, wrap with this before signing.
+// If ExpiresAt is set, the tx is only valid up to that block height.
 type Chain struct {
-	Tx      basecoin.Tx `json:"tx"`
-	ChainID string      `json:"chain_id"`
+	Tx        basecoin.Tx `json:"tx"`
+	ChainID   string      `json:"chain_id"`
+	ExpiresAt uint64      `json:"expires_at"`
 }
 
 func NewChain(tx basecoin.Tx, chainID string) *Chain {
@@ -130,6 +164,62 @@ func (c *Chain) Wrap() basecoin.Tx {
 }
 
 func (c *Chain) ValidateBasic() error {
-	// TODO: more checks? chainID?
+	if c.ChainID == "" {
+		return errors.NoChain()
+	}
 	return c.Tx.ValidateBasic()
 }
+
+func (c *Chain) Next() basecoin.Tx {
+	return c.Tx
+}
+
+/*** Nonce ****/
+
+// NonceInput is the sequence of an actor for this tx, by the address
+// of its account. It must be one more than the last sequence of the actor.
+type NonceInput struct {
+	Address  data.Bytes `json:"address"`
+	Sequence uint64     `json:"sequence"`
+}
+
+func NewNonceInput(addr []byte, seq uint64) NonceInput {
+	return NonceInput{Address: addr, Sequence: seq}
+}
+
+// Nonce protects against replay, it has one sequence for every actor
+// who authorizes the tx, like the inputs of a SendTx.
+// Wrap with this (and Chain) before signing
+type Nonce struct {
+	Tx     basecoin.Tx  `json:"tx"`
+	Inputs []NonceInput `json:"inputs"`
+}
+
+func NewNonce(tx basecoin.Tx, inputs ...NonceInput) *Nonce {
+	return &Nonce{Tx: tx, Inputs: inputs}
+}
+
+func (n *Nonce) Wrap() basecoin.Tx {
+	return basecoin.Tx{n}
+}
+
+func (n *Nonce) ValidateBasic() error {
+	if len(n.Inputs) == 0 {
+		return errors.InvalidSequence()
+	}
+	seen := make(map[string]bool, len(n.Inputs))
+	for _, in := range n.Inputs {
+		if len(in.Address) == 0 || seen[string(in.Address)] {
+			return errors.InvalidAddress()
+		}
+		seen[string(in.Address)] = true
+		if in.Sequence == 0 {
+			return errors.InvalidSequence()
+		}
+	}
+	return n.Tx.ValidateBasic()
+}
+
+func (n *Nonce) Next() basecoin.Tx {
+	return n.Tx
+}
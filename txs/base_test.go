@@ -1,6 +1,7 @@
 package txs
 
 import (
+	"math"
 	"strconv"
 	"testing"
 
@@ -27,7 +28,10 @@ func TestEncoding(t *testing.T) {
 	}{
 		{raw},
 		{NewFee(raw, coin, addr).Wrap()},
+		{NewGasFee(raw, coin, 5000, addr).Wrap()},
 		{NewMultiTx(raw, raw2).Wrap()},
+		{NewChain(raw, "foobar").Wrap()},
+		{NewNonce(raw, NewNonceInput(addr, 42), NewNonceInput([]byte("other"), 7)).Wrap()},
 	}
 
 	for idx, tc := range cases {
@@ -51,3 +55,65 @@ func TestEncoding(t *testing.T) {
 		assert.Equal(tx, wtx, i)
 	}
 }
+
+func TestFeeValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	raw := NewRaw([]byte{0x34, 0xa7}).Wrap()
+	addr := []byte{0x12, 0x34, 0x56, 0x78, 0x90, 0xab, 0xcd, 0xef}
+	price := types.Coin{Denom: "atom", Amount: 10}
+
+	cases := []struct {
+		tx    *Fee
+		valid bool
+	}{
+		{NewFee(raw, price, addr), true},
+		{NewGasFee(raw, price, 5000, addr), true},
+		{NewGasFee(raw, price, math.MaxInt64/10, addr), true},
+		{NewGasFee(raw, price, -1, addr), false},
+		{NewFee(raw, types.Coin{Denom: "atom", Amount: -10}, addr), false},
+		// the max fee would overflow
+		{NewGasFee(raw, price, math.MaxInt64/10+1, addr), false},
+		{NewGasFee(raw, types.Coin{Denom: "atom", Amount: 2}, math.MaxInt64, addr), false},
+	}
+
+	for idx, tc := range cases {
+		i := strconv.Itoa(idx)
+		err := tc.tx.ValidateBasic()
+		if tc.valid {
+			assert.Nil(err, i)
+		} else {
+			assert.NotNil(err, i)
+		}
+	}
+}
+
+func TestNonceValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	raw := NewRaw([]byte{0x34, 0xa7}).Wrap()
+	a, b := []byte("alice"), []byte("bob")
+
+	cases := []struct {
+		tx    *Nonce
+		valid bool
+	}{
+		{NewNonce(raw, NewNonceInput(a, 1)), true},
+		{NewNonce(raw, NewNonceInput(a, 3), NewNonceInput(b, 1)), true},
+		{NewNonce(raw), false},
+		{NewNonce(raw, NewNonceInput(a, 0)), false},
+		{NewNonce(raw, NewNonceInput(nil, 1)), false},
+		// one sequence per address
+		{NewNonce(raw, NewNonceInput(a, 1), NewNonceInput(a, 2)), false},
+	}
+
+	for idx, tc := range cases {
+		i := strconv.Itoa(idx)
+		err := tc.tx.ValidateBasic()
+		if tc.valid {
+			assert.Nil(err, i)
+		} else {
+			assert.NotNil(err, i)
+		}
+	}
+}
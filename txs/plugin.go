@@ -0,0 +1,45 @@
+package txs
+
+import (
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/go-wire/data"
+
+	"github.com/tepleton/basecoin/errors"
+)
+
+const (
+	BytePlugin = 0x7
+	TypePlugin = "plugin"
+)
+
+func init() {
+	basecoin.TxMapper.RegisterImplementation(PluginTx{}, TypePlugin, BytePlugin)
+}
+
+// PluginTx runs Data as a tx of the plugin Name, like the Data of a
+// types.AppTx. The fee, signature and nonce are left to the middleware.
+type PluginTx struct {
+	Name string     `json:"name"`
+	Data data.Bytes `json:"data"`
+}
+
+var _ basecoin.Tx = PluginTx{}.Wrap()
+
+func (tx PluginTx) ValidateBasic() error {
+	if tx.Name == "" {
+		return errors.InvalidFormat()
+	}
+	if len(tx.Data) > rawMaxSize {
+		return errors.TooLarge()
+	}
+	return nil
+}
+
+// NewPluginTx calls the plugin name with the tx data
+func NewPluginTx(name string, d []byte) PluginTx {
+	return PluginTx{Name: name, Data: d}
+}
+
+func (tx PluginTx) Wrap() basecoin.Tx {
+	return basecoin.Tx{tx}
+}
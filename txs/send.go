@@ -10,6 +10,15 @@ import (
 	"github.com/tepleton/basecoin/types"
 )
 
+const (
+	ByteSend = 0x6
+	TypeSend = "send"
+)
+
+func init() {
+	basecoin.TxMapper.RegisterImplementation(SendTx{}, TypeSend, ByteSend)
+}
+
 //-----------------------------------------------------------------------------
 
 type TxInput struct {
@@ -81,6 +90,8 @@ func NewTxOutput(addr []byte, coins types.Coins) TxOutput {
 
 //-----------------------------------------------------------------------------
 
+// SendTx moves coins from the inputs to the outputs.
+// Every input must sign, and the coins of the inputs and outputs must match.
 type SendTx struct {
 	Inputs  []TxInput  `json:"inputs"`
 	Outputs []TxOutput `json:"outputs"`
@@ -110,6 +121,11 @@ func (tx SendTx) ValidateBasic() error {
 	return nil
 }
 
+// NewSendTx moves coins from one address to another
+func NewSendTx(in TxInput, out TxOutput) SendTx {
+	return SendTx{Inputs: []TxInput{in}, Outputs: []TxOutput{out}}
+}
+
 func (tx SendTx) String() string {
 	return fmt.Sprintf("SendTx{%v->%v}", tx.Inputs, tx.Outputs)
 }
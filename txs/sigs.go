@@ -4,12 +4,13 @@ by your application or tests to handle authentication needs.
 
 It currently supports transaction data as opaque bytes and either single
 or multiple private key signatures using straightforward algorithms.
-It currently does not support N-of-M key share signing of other more
-complex algorithms (although it would be great to add them).
+For k-of-n multisig, a ThresholdKey is a public key of its own, with an
+address derived from the keys and threshold, which verifies a compact
+ThresholdSignature.
 
-You can create them with NewSig() and NewMultiSig(), and they fulfill
-the keys.Signable interface. You can then .Wrap() them to create
-a basecoin.Tx.
+You can create them with NewSig(), NewMulti() and NewThresholdSig(),
+and they fulfill the keys.Signable interface. You can then .Wrap() them
+to create a basecoin.Tx.
 */
 package txs
 
@@ -58,6 +59,10 @@ func (s *OneSig) Wrap() basecoin.Tx {
 	return basecoin.Tx{s}
 }
 
+func (s *OneSig) Next() basecoin.Tx {
+	return s.Tx
+}
+
 func (s *OneSig) ValidateBasic() error {
 	// TODO: VerifyBytes here, we do it in Signers?
 	if s.Empty() || !s.Pubkey.VerifyBytes(s.SignBytes(), s.Sig) {
@@ -128,6 +133,10 @@ func (s *MultiSig) Wrap() basecoin.Tx {
 	return basecoin.Tx{s}
 }
 
+func (s *MultiSig) Next() basecoin.Tx {
+	return s.Tx
+}
+
 func (s *MultiSig) ValidateBasic() error {
 	// TODO: more efficient
 	_, err := s.Signers()
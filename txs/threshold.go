@@ -0,0 +1,339 @@
+package txs
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"golang.org/x/crypto/ripemd160"
+
+	crypto "github.com/tepleton/go-crypto"
+	"github.com/tepleton/go-crypto/keys"
+	"github.com/tepleton/go-wire"
+	"github.com/tepleton/go-wire/data"
+
+	"github.com/tepleton/basecoin"
+	"github.com/tepleton/basecoin/errors"
+)
+
+const (
+	// ByteThreshold marks a ThresholdKey or ThresholdSignature inside
+	// crypto.PubKey and crypto.Signature
+	ByteThreshold = 0x20
+	TypeThreshold = "threshold"
+
+	ByteThresholdSig = 0x18
+	TypeThresholdSig = "thresholdsig"
+
+	// MaxThresholdKeys is the most keys in one ThresholdKey
+	MaxThresholdKeys = 32
+)
+
+func init() {
+	crypto.PubKeyMapper.RegisterImplementation(ThresholdKey{}, TypeThreshold, ByteThreshold)
+	crypto.SignatureMapper.RegisterImplementation(ThresholdSignature{}, TypeThreshold, ByteThreshold)
+	basecoin.TxMapper.RegisterImplementation(&ThresholdSig{}, TypeThresholdSig, ByteThresholdSig)
+}
+
+/**** ThresholdKey ****/
+
+// ThresholdKey is a k-of-n multisig public key. It is a crypto.PubKey
+// like any other, so an account, fee payer or tx input can be owned by
+// its address, and it verifies a ThresholdSignature with at least
+// Threshold valid signatures of its Keys.
+//
+// The address is derived from the threshold and the keys, so the keys
+// are kept sorted by address, and the same set always gives the same address.
+type ThresholdKey struct {
+	Threshold int             `json:"threshold"`
+	Keys      []crypto.PubKey `json:"keys"`
+}
+
+var _ crypto.PubKeyInner = ThresholdKey{}
+
+// NewThresholdKey requires threshold of the keys to sign
+func NewThresholdKey(threshold int, pubKeys ...crypto.PubKey) ThresholdKey {
+	sorted := make([]crypto.PubKey, len(pubKeys))
+	copy(sorted, pubKeys)
+	sort.Sort(pubKeysByAddress(sorted))
+	return ThresholdKey{Threshold: threshold, Keys: sorted}
+}
+
+// ValidateBasic makes sure the key can ever be satisfied,
+// and has one canonical form
+func (k ThresholdKey) ValidateBasic() error {
+	if len(k.Keys) == 0 || len(k.Keys) > MaxThresholdKeys {
+		return errors.InvalidFormat()
+	}
+	if k.Threshold <= 0 || k.Threshold > len(k.Keys) {
+		return errors.InvalidFormat()
+	}
+	for i, pk := range k.Keys {
+		if pk.Empty() {
+			return errors.InvalidFormat()
+		}
+		// no nesting, so verification cost is bounded by the key size
+		if _, ok := pk.Unwrap().(ThresholdKey); ok {
+			return errors.InvalidFormat()
+		}
+		// sorted without duplicates
+		if i > 0 && bytes.Compare(k.Keys[i-1].Address(), pk.Address()) >= 0 {
+			return errors.InvalidFormat()
+		}
+	}
+	return nil
+}
+
+func (k ThresholdKey) AssertIsPubKeyInner() {}
+
+// Address is the RIPEMD160 of the key bytes, like for ed25519 keys
+func (k ThresholdKey) Address() []byte {
+	hasher := ripemd160.New()
+	hasher.Write(k.Bytes()) // does not error
+	return hasher.Sum(nil)
+}
+
+func (k ThresholdKey) Bytes() []byte {
+	return wire.BinaryBytes(struct{ crypto.PubKey }{k.Wrap()})
+}
+
+func (k ThresholdKey) KeyString() string {
+	return fmt.Sprintf("%X", k.Bytes())
+}
+
+func (k ThresholdKey) String() string {
+	return fmt.Sprintf("ThresholdKey{%d of %v}", k.Threshold, k.Keys)
+}
+
+// VerifyBytes checks the sig is a ThresholdSignature by at least
+// Threshold of the keys, and all its signatures are valid
+func (k ThresholdKey) VerifyBytes(msg []byte, sig crypto.Signature) bool {
+	tsig, ok := sig.Unwrap().(ThresholdSignature)
+	if !ok || k.ValidateBasic() != nil {
+		return false
+	}
+	signers, err := tsig.signers(len(k.Keys))
+	if err != nil || len(signers) < k.Threshold {
+		return false
+	}
+	for i, idx := range signers {
+		if !k.Keys[idx].VerifyBytes(msg, tsig.Sigs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (k ThresholdKey) Equals(other crypto.PubKey) bool {
+	if o, ok := other.Unwrap().(ThresholdKey); ok {
+		return bytes.Equal(k.Bytes(), o.Bytes())
+	}
+	return false
+}
+
+func (k ThresholdKey) Wrap() crypto.PubKey {
+	return crypto.PubKey{k}
+}
+
+// index returns the position of pk in the keys, -1 if it is not one of them
+func (k ThresholdKey) index(pk crypto.PubKey) int {
+	for i, key := range k.Keys {
+		if key.Equals(pk) {
+			return i
+		}
+	}
+	return -1
+}
+
+/**** ThresholdSignature ****/
+
+// ThresholdSignature bundles the signatures for a ThresholdKey.
+// Bit i of the Bitmap (little endian in every byte) is set if
+// Keys[i] signed, and Sigs holds those signatures in the order of the keys.
+type ThresholdSignature struct {
+	Bitmap data.Bytes         `json:"bitmap"`
+	Sigs   []crypto.Signature `json:"sigs"`
+}
+
+var _ crypto.SignatureInner = ThresholdSignature{}
+
+func (s ThresholdSignature) AssertIsSignatureInner() {}
+
+func (s ThresholdSignature) Bytes() []byte {
+	return wire.BinaryBytes(struct{ crypto.Signature }{s.Wrap()})
+}
+
+func (s ThresholdSignature) IsZero() bool {
+	return len(s.Sigs) == 0
+}
+
+func (s ThresholdSignature) String() string {
+	return fmt.Sprintf("ThresholdSignature{%X %v}", s.Bitmap, s.Sigs)
+}
+
+func (s ThresholdSignature) Equals(other crypto.Signature) bool {
+	if o, ok := other.Unwrap().(ThresholdSignature); ok {
+		return bytes.Equal(s.Bytes(), o.Bytes())
+	}
+	return false
+}
+
+func (s ThresholdSignature) Wrap() crypto.Signature {
+	return crypto.Signature{s}
+}
+
+// signers returns the indexes of the keys that signed, and checks
+// the bitmap fits n keys and matches the signatures
+func (s ThresholdSignature) signers(n int) ([]int, error) {
+	if len(s.Bitmap) != (n+7)/8 {
+		return nil, errors.InvalidFormat()
+	}
+	var signers []int
+	for i := 0; i < 8*len(s.Bitmap); i++ {
+		if s.Bitmap[i/8]&(1<<uint(i%8)) == 0 {
+			continue
+		}
+		if i >= n {
+			return nil, errors.InvalidFormat()
+		}
+		signers = append(signers, i)
+	}
+	if len(signers) != len(s.Sigs) {
+		return nil, errors.InvalidFormat()
+	}
+	for _, sig := range s.Sigs {
+		if sig.Empty() {
+			return nil, errors.MissingSignature()
+		}
+	}
+	return signers, nil
+}
+
+/**** ThresholdSig ****/
+
+// ThresholdSig collects the signatures of the keys of a ThresholdKey,
+// one at a time, like MultiSig. Signers returns only the ThresholdKey,
+// once enough of them signed.
+type ThresholdSig struct {
+	Tx  basecoin.Tx        `json:"tx"`
+	Key crypto.PubKey      `json:"pub_key"`
+	Sig ThresholdSignature `json:"signature"`
+}
+
+var _ keys.Signable = &ThresholdSig{}
+
+func NewThresholdSig(tx basecoin.Tx, key ThresholdKey) *ThresholdSig {
+	return &ThresholdSig{
+		Tx:  tx,
+		Key: key.Wrap(),
+		Sig: ThresholdSignature{Bitmap: make(data.Bytes, (len(key.Keys)+7)/8)},
+	}
+}
+
+func (s *ThresholdSig) Wrap() basecoin.Tx {
+	return basecoin.Tx{s}
+}
+
+func (s *ThresholdSig) Next() basecoin.Tx {
+	return s.Tx
+}
+
+func (s *ThresholdSig) ValidateBasic() error {
+	_, err := s.Signers()
+	if err != nil {
+		return err
+	}
+	return s.Tx.ValidateBasic()
+}
+
+// TxBytes returns the full data with signatures
+func (s *ThresholdSig) TxBytes() ([]byte, error) {
+	return data.ToWire(s)
+}
+
+// SignBytes returns the original data passed into `NewThresholdSig`
+func (s *ThresholdSig) SignBytes() []byte {
+	res, err := data.ToWire(s.Tx)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// Sign adds the signature of one of the keys.
+//
+// It can be called once for every key, in any order.
+// Returns error if pubkey is not one of the keys, or already signed
+func (s *ThresholdSig) Sign(pubkey crypto.PubKey, sig crypto.Signature) error {
+	signed := Signed{sig, pubkey}
+	if signed.Empty() {
+		return errors.MissingSignature()
+	}
+	key, ok := s.Key.Unwrap().(ThresholdKey)
+	if !ok {
+		return errors.InvalidFormat()
+	}
+	idx := key.index(pubkey)
+	if idx < 0 {
+		return errors.Unauthorized()
+	}
+	signers, err := s.Sig.signers(len(key.Keys))
+	if err != nil {
+		return err
+	}
+
+	// insert the sig in the order of the keys
+	pos := 0
+	for ; pos < len(signers) && signers[pos] <= idx; pos++ {
+		if signers[pos] == idx {
+			return errors.TooManySignatures()
+		}
+	}
+	sigs := make([]crypto.Signature, 0, len(s.Sig.Sigs)+1)
+	sigs = append(sigs, s.Sig.Sigs[:pos]...)
+	sigs = append(sigs, sig)
+	s.Sig.Sigs = append(sigs, s.Sig.Sigs[pos:]...)
+	s.Sig.Bitmap[idx/8] |= 1 << uint(idx%8)
+	return nil
+}
+
+// Signers returns the ThresholdKey if enough of its keys signed,
+// or an error if there is any issue with the signatures
+func (s *ThresholdSig) Signers() ([]crypto.PubKey, error) {
+	key, ok := s.Key.Unwrap().(ThresholdKey)
+	if !ok {
+		return nil, errors.InvalidFormat()
+	}
+	err := key.ValidateBasic()
+	if err != nil {
+		return nil, err
+	}
+	signers, err := s.Sig.signers(len(key.Keys))
+	if err != nil {
+		return nil, err
+	}
+	if len(signers) < key.Threshold {
+		return nil, errors.MissingSignature()
+	}
+	if !key.VerifyBytes(s.SignBytes(), s.Sig.Wrap()) {
+		return nil, errors.InvalidSignature()
+	}
+	return []crypto.PubKey{s.Key}, nil
+}
+
+//-------------------------------------
+
+// pubKeysByAddress sorts the keys of a ThresholdKey
+type pubKeysByAddress []crypto.PubKey
+
+func (pks pubKeysByAddress) Len() int {
+	return len(pks)
+}
+
+func (pks pubKeysByAddress) Less(i, j int) bool {
+	return bytes.Compare(pks[i].Address(), pks[j].Address()) == -1
+}
+
+func (pks pubKeysByAddress) Swap(i, j int) {
+	pks[i], pks[j] = pks[j], pks[i]
+}
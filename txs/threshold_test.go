@@ -0,0 +1,145 @@
+package txs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	crypto "github.com/tepleton/go-crypto"
+	keys "github.com/tepleton/go-crypto/keys"
+	"github.com/tepleton/go-crypto/keys/cryptostore"
+	"github.com/tepleton/go-crypto/keys/storage/memstorage"
+	wire "github.com/tepleton/go-wire"
+	"github.com/tepleton/go-wire/data"
+
+	"github.com/tepleton/basecoin"
+)
+
+func TestThresholdKey(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+
+	pk1 := crypto.GenPrivKeyEd25519().Wrap().PubKey()
+	pk2 := crypto.GenPrivKeyEd25519().Wrap().PubKey()
+	pk3 := crypto.GenPrivKeyEd25519().Wrap().PubKey()
+
+	// the address only depends on the set of keys and the threshold
+	key := NewThresholdKey(2, pk1, pk2, pk3)
+	require.Nil(key.ValidateBasic())
+	assert.Equal(key.Address(), NewThresholdKey(2, pk3, pk1, pk2).Address())
+	assert.NotEqual(key.Address(), NewThresholdKey(1, pk1, pk2, pk3).Address())
+	assert.NotEqual(key.Address(), NewThresholdKey(2, pk1, pk2).Address())
+	assert.Equal(20, len(key.Address()))
+
+	// it is a crypto.PubKey like any other
+	var pk crypto.PubKey
+	err := wire.ReadBinaryBytes(key.Bytes(), &pk)
+	require.Nil(err, "%+v", err)
+	assert.True(key.Wrap().Equals(pk))
+	assert.Equal(key.Address(), pk.Address())
+	js, err := data.ToJSON(key.Wrap())
+	require.Nil(err, "%+v", err)
+	pk = crypto.PubKey{}
+	require.Nil(data.FromJSON(js, &pk))
+	assert.True(key.Wrap().Equals(pk))
+
+	cases := []struct {
+		key   ThresholdKey
+		valid bool
+	}{
+		{NewThresholdKey(1, pk1), true},
+		{NewThresholdKey(3, pk1, pk2, pk3), true},
+		{NewThresholdKey(0, pk1, pk2), false},
+		{NewThresholdKey(3, pk1, pk2), false},
+		{NewThresholdKey(1), false},
+		// no duplicates
+		{NewThresholdKey(2, pk1, pk1), false},
+		// must be sorted
+		{ThresholdKey{1, []crypto.PubKey{key.Keys[1], key.Keys[0]}}, false},
+		// no nesting
+		{NewThresholdKey(1, pk1, key.Wrap()), false},
+	}
+	for i, tc := range cases {
+		err := tc.key.ValidateBasic()
+		assert.Equal(tc.valid, err == nil, "%d: %+v", i, err)
+	}
+}
+
+func TestThresholdSig(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+
+	algo := crypto.NameEd25519
+	cstore := cryptostore.New(
+		cryptostore.SecretBox,
+		memstorage.New(),
+		keys.MustLoadCodec("english"),
+	)
+	type signer struct {
+		key        keys.Info
+		name, pass string
+	}
+	var signers []signer
+	for _, n := range []string{"one", "two", "three", "other"} {
+		info, _, err := cstore.Create(n, "1234567890", algo)
+		require.Nil(err, "%+v", err)
+		signers = append(signers, signer{info, n, "1234567890"})
+	}
+	key := NewThresholdKey(2, signers[0].key.PubKey, signers[1].key.PubKey, signers[2].key.PubKey)
+
+	cases := []struct {
+		data    string
+		signers []signer
+		valid   bool
+	}{
+		{"none", nil, false},
+		{"one", []signer{signers[0]}, false},
+		{"two", []signer{signers[2], signers[0]}, true},
+		{"all", []signer{signers[1], signers[0], signers[2]}, true},
+	}
+
+	for _, tc := range cases {
+		inner := NewRaw([]byte(tc.data)).Wrap()
+		tx := NewThresholdSig(inner, key)
+
+		for _, s := range tc.signers {
+			err := cstore.Sign(s.name, s.pass, tx)
+			require.Nil(err, "%+v", err)
+		}
+		// only once, and only by the keys
+		if len(tc.signers) > 0 {
+			s := tc.signers[0]
+			assert.NotNil(cstore.Sign(s.name, s.pass, tx))
+		}
+		assert.NotNil(cstore.Sign(signers[3].name, signers[3].pass, tx))
+
+		sigs, err := tx.Signers()
+		if !tc.valid {
+			assert.NotNil(err, tc.data)
+			continue
+		}
+		require.Nil(err, "%+v", err)
+		if assert.Equal(1, len(sigs)) {
+			assert.True(key.Wrap().Equals(sigs[0]))
+		}
+		checkSignBytes(t, tx.SignBytes(), tc.data)
+
+		// it survives a round trip
+		txBytes, err := data.ToWire(tx.Wrap())
+		require.Nil(err, "%+v", err)
+		var loaded basecoin.Tx
+		require.Nil(data.FromWire(txBytes, &loaded))
+		ltx, ok := loaded.Unwrap().(*ThresholdSig)
+		require.True(ok)
+		_, err = ltx.Signers()
+		assert.Nil(err, "%+v", err)
+
+		// and the bundle verifies as a plain signature of the key
+		assert.True(key.VerifyBytes(tx.SignBytes(), tx.Sig.Wrap()))
+		assert.False(key.VerifyBytes([]byte("other"), tx.Sig.Wrap()))
+
+		// a bitmap that doesn't match the signatures is rejected
+		ltx.Sig.Bitmap[0] ^= 0x1
+		_, err = ltx.Signers()
+		assert.NotNil(err)
+	}
+}
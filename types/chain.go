@@ -0,0 +1,16 @@
+package types
+
+var chainIDKey = []byte("base/chain_id")
+
+// ChainIDKey is where the chain id is stored
+func ChainIDKey() []byte {
+	return chainIDKey
+}
+
+func GetChainID(store KVStore) string {
+	return string(store.Get(chainIDKey))
+}
+
+func SetChainID(store KVStore, chainID string) {
+	store.Set(chainIDKey, []byte(chainID))
+}
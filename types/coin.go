@@ -23,7 +23,8 @@ func (coin Coin) String() string {
 var reDenom = regexp.MustCompile("")
 var reAmt = regexp.MustCompile("(\\d+)")
 
-var reCoin = regexp.MustCompile("^([[:digit:]]+)[[:space:]]*([[:alpha:]]+)$")
+// a denom is only letters, or an ibc voucher like ibc/<chain_id>/<denom>
+var reCoin = regexp.MustCompile("^([[:digit:]]+)[[:space:]]*([[:alpha:]]+|ibc/[^[:space:],/]+/[^[:space:],]+)$")
 
 func ParseCoin(str string) (Coin, error) {
 	var coin Coin
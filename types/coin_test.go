@@ -74,6 +74,9 @@ func TestParse(t *testing.T) {
 		{"11me coin, 12you coin", false, nil}, // no spaces in coin names
 		{"1.2btc", false, nil},                // amount must be integer
 		{"5foo-bar", false, nil},              // once more, only letters in coin name
+		{"3 ibc/chain-1/foo", true, Coins{{"ibc/chain-1/foo", 3}}},
+		{"3ibc/chain-1/ibc/a/foo,1foo", true, Coins{{"foo", 1}, {"ibc/chain-1/ibc/a/foo", 3}}},
+		{"3 ibc/foo", false, nil}, // vouchers need a chain id
 	}
 
 	for _, tc := range cases {
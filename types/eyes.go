@@ -0,0 +1,160 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/tepleton/go-wire"
+	eyes "github.com/tepleton/merkleeyes/client"
+	wrsp "github.com/tepleton/wrsp/types"
+)
+
+// EyesStore adapts a merkleeyes client to a KVStore.
+//
+// merkleeyes can only iterate over the last committed tree (by index),
+// so we remember all writes since the last Commit and merge them in.
+type EyesStore struct {
+	client  *eyes.Client
+	pending map[string][]byte // writes since last commit, nil for deletes
+}
+
+func NewEyesStore(client *eyes.Client) *EyesStore {
+	return &EyesStore{
+		client:  client,
+		pending: make(map[string][]byte),
+	}
+}
+
+// Client returns the underlying merkleeyes client
+func (es *EyesStore) Client() *eyes.Client {
+	return es.client
+}
+
+func (es *EyesStore) Set(key []byte, value []byte) {
+	es.pending[string(key)] = value
+	es.client.Set(key, value)
+}
+
+func (es *EyesStore) Get(key []byte) (value []byte) {
+	if value, ok := es.pending[string(key)]; ok {
+		return value
+	}
+	return es.client.Get(key)
+}
+
+func (es *EyesStore) Delete(key []byte) {
+	es.pending[string(key)] = nil
+	es.client.Remove(key)
+}
+
+func (es *EyesStore) Iterator(start, end []byte) Iterator {
+	var pairs []kvPair
+	for k, v := range es.pending {
+		key := []byte(k)
+		if InRange(key, start, end) {
+			pairs = append(pairs, kvPair{key, v})
+		}
+	}
+	return newMergeIterator(newEyesIterator(es.client, start, end), newMemIterator(pairs))
+}
+
+// Commit saves the working tree and clears the pending writes
+func (es *EyesStore) Commit() wrsp.Result {
+	es.pending = make(map[string][]byte)
+	return es.client.CommitSync()
+}
+
+//----------------------------------------
+
+// eyesIterator walks the committed merkleeyes tree by index
+type eyesIterator struct {
+	client *eyes.Client
+	end    []byte
+	idx    int
+	size   int
+	key    []byte
+	value  []byte
+}
+
+func newEyesIterator(client *eyes.Client, start, end []byte) *eyesIterator {
+	ei := &eyesIterator{client: client, end: end}
+	ei.size = ei.querySize()
+	ei.idx = ei.search(start)
+	ei.load()
+	return ei
+}
+
+func (ei *eyesIterator) Valid() bool {
+	return ei.key != nil
+}
+
+func (ei *eyesIterator) Next() {
+	ei.idx++
+	ei.load()
+}
+
+func (ei *eyesIterator) Key() []byte {
+	return ei.key
+}
+
+func (ei *eyesIterator) Value() []byte {
+	return ei.value
+}
+
+// load reads the key-value pair at idx, skipping empty values,
+// and invalidates the iterator once we pass the end
+func (ei *eyesIterator) load() {
+	for ; ei.idx < ei.size; ei.idx++ {
+		key, value := ei.queryIndex(ei.idx)
+		if !InRange(key, nil, ei.end) {
+			break
+		}
+		if len(value) > 0 {
+			ei.key, ei.value = key, value
+			return
+		}
+	}
+	ei.key, ei.value = nil, nil
+}
+
+// search returns the first index with key >= start
+func (ei *eyesIterator) search(start []byte) int {
+	if start == nil {
+		return 0
+	}
+	lo, hi := 0, ei.size
+	for lo < hi {
+		mid := (lo + hi) / 2
+		key, _ := ei.queryIndex(mid)
+		if bytes.Compare(key, start) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+func (ei *eyesIterator) querySize() int {
+	res, err := ei.client.QuerySync(wrsp.RequestQuery{Path: "/size"})
+	if err != nil {
+		panic(fmt.Sprintf("Error querying merkleeyes size: %v", err))
+	}
+	var size int
+	err = wire.ReadBinaryBytes(res.Value, &size)
+	if err != nil {
+		panic(fmt.Sprintf("Error reading merkleeyes size %X: %v", res.Value, err))
+	}
+	return size
+}
+
+func (ei *eyesIterator) queryIndex(idx int) (key, value []byte) {
+	res, err := ei.client.QuerySync(wrsp.RequestQuery{
+		Path: "/index",
+		Data: wire.BinaryBytes(int64(idx)),
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Error querying merkleeyes index %d: %v", idx, err))
+	}
+	return res.Key, res.Value
+}
@@ -0,0 +1,110 @@
+package types
+
+import (
+	"fmt"
+)
+
+// Gas costs for the basic operations
+const (
+	GasCostRead         int64 = 10
+	GasCostReadPerByte  int64 = 1
+	GasCostWrite        int64 = 20
+	GasCostWritePerByte int64 = 5
+	GasCostDelete       int64 = 20
+	GasCostSignature    int64 = 100
+)
+
+// OutOfGas is the value GasMeter panics with when the limit is hit.
+// Recover it where the tx is executed to abort with an error.
+type OutOfGas struct {
+	Descriptor string
+}
+
+func (oog OutOfGas) Error() string {
+	return fmt.Sprintf("Out of gas in %s", oog.Descriptor)
+}
+
+// GasMeter tracks the gas used by one tx against its limit.
+// A nil *GasMeter is valid, and never runs out of gas.
+type GasMeter struct {
+	limit int64
+	used  int64
+}
+
+func NewGasMeter(limit int64) *GasMeter {
+	return &GasMeter{limit: limit}
+}
+
+// ConsumeGas uses up the amount of gas, and panics with OutOfGas
+// if that takes us over the limit
+func (g *GasMeter) ConsumeGas(amount int64, descriptor string) {
+	if g == nil {
+		return
+	}
+	g.used += amount
+	if g.used > g.limit {
+		panic(OutOfGas{descriptor})
+	}
+}
+
+func (g *GasMeter) GasUsed() int64 {
+	if g == nil {
+		return 0
+	}
+	return g.used
+}
+
+// GasRemaining is the unused part of the limit
+func (g *GasMeter) GasRemaining() int64 {
+	if g == nil || g.used > g.limit {
+		return 0
+	}
+	return g.limit - g.used
+}
+
+//----------------------------------------
+
+// GasKVStore charges all reads and writes to the gas meter
+type GasKVStore struct {
+	meter *GasMeter
+	store KVStore
+}
+
+var _ KVStore = GasKVStore{}
+
+func NewGasKVStore(meter *GasMeter, store KVStore) GasKVStore {
+	return GasKVStore{meter: meter, store: store}
+}
+
+func (gs GasKVStore) Get(key []byte) (value []byte) {
+	gs.meter.ConsumeGas(GasCostRead, "read")
+	value = gs.store.Get(key)
+	gs.meter.ConsumeGas(GasCostReadPerByte*int64(len(value)), "read")
+	return value
+}
+
+func (gs GasKVStore) Set(key []byte, value []byte) {
+	gs.meter.ConsumeGas(GasCostWrite+GasCostWritePerByte*int64(len(value)), "write")
+	gs.store.Set(key, value)
+}
+
+func (gs GasKVStore) Delete(key []byte) {
+	gs.meter.ConsumeGas(GasCostDelete, "delete")
+	gs.store.Delete(key)
+}
+
+func (gs GasKVStore) Iterator(start, end []byte) Iterator {
+	return gasIterator{gs.meter, gs.store.Iterator(start, end)}
+}
+
+// gasIterator charges a read for every value we look at
+type gasIterator struct {
+	meter *GasMeter
+	Iterator
+}
+
+func (gi gasIterator) Value() []byte {
+	value := gi.Iterator.Value()
+	gi.meter.ConsumeGas(GasCostRead+GasCostReadPerByte*int64(len(value)), "iterator")
+	return value
+}
@@ -0,0 +1,54 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGasMeter(t *testing.T) {
+	assert := assert.New(t)
+
+	meter := NewGasMeter(100)
+	meter.ConsumeGas(60, "first")
+	assert.EqualValues(60, meter.GasUsed())
+	assert.EqualValues(40, meter.GasRemaining())
+	assert.Panics(func() { meter.ConsumeGas(41, "second") })
+	assert.EqualValues(0, meter.GasRemaining())
+
+	// a nil meter never runs out
+	var unlimited *GasMeter
+	assert.NotPanics(func() { unlimited.ConsumeGas(1000000, "free") })
+	assert.EqualValues(0, unlimited.GasUsed())
+}
+
+func TestGasKVStore(t *testing.T) {
+	assert := assert.New(t)
+
+	meter := NewGasMeter(1000)
+	store := NewGasKVStore(meter, NewMemKVStore())
+
+	store.Set([]byte("foo"), []byte("bar"))
+	used := GasCostWrite + 3*GasCostWritePerByte
+	assert.Equal(used, meter.GasUsed())
+
+	store.Get([]byte("foo"))
+	used += GasCostRead + 3*GasCostReadPerByte
+	assert.Equal(used, meter.GasUsed())
+
+	for it := store.Iterator(nil, nil); it.Valid(); it.Next() {
+		it.Value()
+	}
+	used += GasCostRead + 3*GasCostReadPerByte
+	assert.Equal(used, meter.GasUsed())
+
+	// hitting the limit aborts the write
+	big := make([]byte, 1000)
+	defer func() {
+		r := recover()
+		_, ok := r.(OutOfGas)
+		assert.True(ok, "%#v", r)
+		assert.Nil(store.store.Get([]byte("big")))
+	}()
+	store.Set([]byte("big"), big)
+}
@@ -1,8 +1,10 @@
 package types
 
 import (
+	"bytes"
 	"container/list"
 	"fmt"
+	"sort"
 
 	. "github.com/tepleton/tmlibs/common"
 )
@@ -10,6 +12,81 @@ import (
 type KVStore interface {
 	Set(key, value []byte)
 	Get(key []byte) (value []byte)
+	Delete(key []byte)
+
+	// Iterator returns all non-empty values with start <= key < end,
+	// in ascending key order. A nil start or end leaves that side open.
+	// The store must not be modified while the iterator is in use.
+	Iterator(start, end []byte) Iterator
+}
+
+// Savepointer is implemented by stores that can undo just the writes
+// since some point, without dropping everything pending (eg. state.State).
+// Use Atomic to run a sub-operation that is rolled back if it fails.
+// A savepoint must be released once done with, to not pile up layers.
+type Savepointer interface {
+	Savepoint() int
+	RollbackTo(savepoint int)
+	Release(savepoint int)
+}
+
+// Atomic runs fn on store, and undoes all of its writes if it fails.
+// A Savepointer is written to directly and rolled back on error,
+// any other store gets a cache that is only synced on success.
+func Atomic(store KVStore, fn func(KVStore) error) error {
+	if sp, ok := store.(Savepointer); ok {
+		savepoint := sp.Savepoint()
+		err := fn(store)
+		if err != nil {
+			sp.RollbackTo(savepoint)
+		}
+		sp.Release(savepoint)
+		return err
+	}
+	cache := NewKVCache(store)
+	err := fn(cache)
+	if err == nil {
+		cache.Sync()
+	}
+	return err
+}
+
+// Iterator walks over a range of a KVStore
+type Iterator interface {
+	Valid() bool
+	Next()
+	Key() []byte
+	Value() []byte
+}
+
+// PrefixIterator returns an Iterator over all keys beginning with prefix
+func PrefixIterator(store KVStore, prefix []byte) Iterator {
+	return store.Iterator(prefix, PrefixEnd(prefix))
+}
+
+// PrefixEnd returns the first key after all keys with the given prefix,
+// or nil if there is no such key (prefix is empty or all 0xFF)
+func PrefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// InRange returns true iff start <= key < end, treating nil bounds as open
+func InRange(key, start, end []byte) bool {
+	if start != nil && bytes.Compare(key, start) < 0 {
+		return false
+	}
+	if end != nil && bytes.Compare(key, end) >= 0 {
+		return false
+	}
+	return true
 }
 
 //----------------------------------------
@@ -32,6 +109,21 @@ func (mkv *MemKVStore) Get(key []byte) (value []byte) {
 	return mkv.m[string(key)]
 }
 
+func (mkv *MemKVStore) Delete(key []byte) {
+	delete(mkv.m, string(key))
+}
+
+func (mkv *MemKVStore) Iterator(start, end []byte) Iterator {
+	var pairs []kvPair
+	for k, v := range mkv.m {
+		key := []byte(k)
+		if len(v) > 0 && InRange(key, start, end) {
+			pairs = append(pairs, kvPair{key, v})
+		}
+	}
+	return newMemIterator(pairs)
+}
+
 //----------------------------------------
 
 // A Cache that enforces deterministic sync order.
@@ -46,6 +138,7 @@ type KVCache struct {
 type kvCacheValue struct {
 	v []byte        // The value of some key
 	e *list.Element // The KVCache.keys element
+	d bool          // Whether the key was deleted
 }
 
 // NOTE: If store is nil, creates a new MemKVStore
@@ -88,9 +181,41 @@ func (kvc *KVCache) Set(key []byte, value []byte) {
 		cacheValue.e = kvc.keys.PushBack(key)
 	}
 	cacheValue.v = value
+	cacheValue.d = false
 	kvc.cache[string(key)] = cacheValue
 }
 
+func (kvc *KVCache) Delete(key []byte) {
+	if kvc.logging {
+		line := fmt.Sprintf("Delete %v", LegibleBytes(key))
+		kvc.logLines = append(kvc.logLines, line)
+	}
+	cacheValue, ok := kvc.cache[string(key)]
+	if ok {
+		kvc.keys.MoveToBack(cacheValue.e)
+	} else {
+		cacheValue.e = kvc.keys.PushBack(key)
+	}
+	cacheValue.v = nil
+	cacheValue.d = true
+	kvc.cache[string(key)] = cacheValue
+}
+
+// Iterator merges the pending writes and deletions in the cache
+// with the contents of the underlying store
+func (kvc *KVCache) Iterator(start, end []byte) Iterator {
+	// cached reads hold the same value as the store, so we can
+	// just overlay everything in the cache over the store
+	var pairs []kvPair
+	for k, cv := range kvc.cache {
+		key := []byte(k)
+		if InRange(key, start, end) {
+			pairs = append(pairs, kvPair{key, cv.v})
+		}
+	}
+	return newMergeIterator(kvc.store.Iterator(start, end), newMemIterator(pairs))
+}
+
 func (kvc *KVCache) Get(key []byte) (value []byte) {
 	cacheValue, ok := kvc.cache[string(key)]
 	if ok {
@@ -118,13 +243,120 @@ func (kvc *KVCache) Sync() {
 	for e := kvc.keys.Front(); e != nil; e = e.Next() {
 		key := e.Value.([]byte)
 		value := kvc.cache[string(key)]
-		kvc.store.Set(key, value.v)
+		if value.d {
+			kvc.store.Delete(key)
+		} else {
+			kvc.store.Set(key, value.v)
+		}
 	}
 	kvc.Reset()
 }
 
 //----------------------------------------
 
+type kvPair struct {
+	key   []byte
+	value []byte
+}
+
+// memIterator iterates over a sorted snapshot of key-value pairs.
+// It may return empty values, which the mergeIterator treats as deleted.
+type memIterator struct {
+	pairs []kvPair
+	idx   int
+}
+
+func newMemIterator(pairs []kvPair) *memIterator {
+	sort.Slice(pairs, func(i, j int) bool {
+		return bytes.Compare(pairs[i].key, pairs[j].key) < 0
+	})
+	return &memIterator{pairs: pairs}
+}
+
+func (mi *memIterator) Valid() bool {
+	return mi.idx < len(mi.pairs)
+}
+
+func (mi *memIterator) Next() {
+	mi.idx++
+}
+
+func (mi *memIterator) Key() []byte {
+	return mi.pairs[mi.idx].key
+}
+
+func (mi *memIterator) Value() []byte {
+	return mi.pairs[mi.idx].value
+}
+
+// mergeIterator overlays the cache over the parent in key order.
+// Cached entries shadow the parent, and empty cached values hide
+// the key entirely (deleted or never existed).
+type mergeIterator struct {
+	parent Iterator
+	cache  Iterator
+}
+
+func newMergeIterator(parent, cache Iterator) *mergeIterator {
+	mi := &mergeIterator{parent: parent, cache: cache}
+	mi.skipDeleted()
+	return mi
+}
+
+func (mi *mergeIterator) Valid() bool {
+	return mi.parent.Valid() || mi.cache.Valid()
+}
+
+func (mi *mergeIterator) Next() {
+	mi.advance()
+	mi.skipDeleted()
+}
+
+func (mi *mergeIterator) Key() []byte {
+	if mi.fromCache() {
+		return mi.cache.Key()
+	}
+	return mi.parent.Key()
+}
+
+func (mi *mergeIterator) Value() []byte {
+	if mi.fromCache() {
+		return mi.cache.Value()
+	}
+	return mi.parent.Value()
+}
+
+// fromCache is true if the current entry comes from the cache
+func (mi *mergeIterator) fromCache() bool {
+	if !mi.cache.Valid() {
+		return false
+	}
+	if !mi.parent.Valid() {
+		return true
+	}
+	return bytes.Compare(mi.cache.Key(), mi.parent.Key()) <= 0
+}
+
+func (mi *mergeIterator) advance() {
+	if !mi.fromCache() {
+		mi.parent.Next()
+		return
+	}
+	// the cache shadows the parent for the same key
+	if mi.parent.Valid() && bytes.Equal(mi.cache.Key(), mi.parent.Key()) {
+		mi.parent.Next()
+	}
+	mi.cache.Next()
+}
+
+func (mi *mergeIterator) skipDeleted() {
+	for mi.fromCache() && len(mi.cache.Value()) == 0 {
+		mi.advance()
+	}
+}
+
+//----------------------------------------
+
 func LegibleBytes(data []byte) string {
 	s := ""
 	for _, b := range data {
@@ -2,6 +2,7 @@ package types
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -68,3 +69,86 @@ func TestKVStore(t *testing.T) {
 	assert.Zero(len(kvc.GetLogLines()), "logging events still exists after ClearLogLines")
 
 }
+
+func TestKVStoreIterator(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMemKVStore()
+	store.Set([]byte("a/1"), []byte("one"))
+	store.Set([]byte("a/2"), []byte("two"))
+	store.Set([]byte("a/3"), []byte("three"))
+	store.Set([]byte("b/1"), []byte("other"))
+
+	// pending writes and deletions in the cache must be merged in
+	kvc := NewKVCache(store)
+	kvc.Delete([]byte("a/2"))
+	kvc.Set([]byte("a/4"), []byte("four"))
+	kvc.Set([]byte("a/1"), []byte("uno"))
+	kvc.Get([]byte("a/5")) // a miss must not show up
+
+	collect := func(it Iterator) (keys, values []string) {
+		for ; it.Valid(); it.Next() {
+			keys = append(keys, string(it.Key()))
+			values = append(values, string(it.Value()))
+		}
+		return
+	}
+
+	cases := []struct {
+		store  KVStore
+		start  []byte
+		end    []byte
+		keys   []string
+		values []string
+	}{
+		{store, nil, nil, []string{"a/1", "a/2", "a/3", "b/1"}, []string{"one", "two", "three", "other"}},
+		{store, []byte("a/2"), []byte("b"), []string{"a/2", "a/3"}, []string{"two", "three"}},
+		{kvc, nil, nil, []string{"a/1", "a/3", "a/4", "b/1"}, []string{"uno", "three", "four", "other"}},
+		{kvc, []byte("a/2"), []byte("a/4"), []string{"a/3"}, []string{"three"}},
+		{kvc, []byte("c"), nil, nil, nil},
+	}
+
+	for i, tc := range cases {
+		keys, values := collect(tc.store.Iterator(tc.start, tc.end))
+		assert.Equal(tc.keys, keys, "%d", i)
+		assert.Equal(tc.values, values, "%d", i)
+	}
+
+	// prefix iteration
+	keys, _ := collect(PrefixIterator(kvc, []byte("a/")))
+	assert.Equal([]string{"a/1", "a/3", "a/4"}, keys)
+	assert.Equal([]byte("a0"), PrefixEnd([]byte("a/")))
+	assert.Equal([]byte{0x2}, PrefixEnd([]byte{0x1, 0xFF}))
+	assert.Nil(PrefixEnd([]byte{0xFF}))
+
+	// deletes are applied to the store on sync
+	kvc.Sync()
+	assert.Nil(store.Get([]byte("a/2")))
+	keys, _ = collect(PrefixIterator(store, []byte("a/")))
+	assert.Equal([]string{"a/1", "a/3", "a/4"}, keys)
+}
+
+func TestAtomic(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMemKVStore()
+	store.Set([]byte("a"), []byte("1"))
+
+	// a failure leaves the store untouched
+	err := Atomic(store, func(kv KVStore) error {
+		kv.Set([]byte("b"), []byte("2"))
+		kv.Delete([]byte("a"))
+		return errors.New("fail")
+	})
+	assert.NotNil(err)
+	assert.Equal([]byte("1"), store.Get([]byte("a")))
+	assert.Nil(store.Get([]byte("b")))
+
+	// a success writes everything
+	err = Atomic(store, func(kv KVStore) error {
+		kv.Set([]byte("b"), []byte("2"))
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal([]byte("2"), store.Get([]byte("b")))
+}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"encoding/json"
 	"fmt"
 
 	wrsp "github.com/tepleton/wrsp/types"
@@ -21,12 +22,35 @@ type Plugin interface {
 	EndBlock(store KVStore, height uint64) wrsp.ResponseEndBlock
 }
 
+// GenesisPlugin is optionally implemented by plugins with structured
+// genesis state. Each gets the raw json of its own section of the
+// app_options, and can export its state in the same format.
+type GenesisPlugin interface {
+	InitGenesis(store KVStore, genesis json.RawMessage) error
+	ExportGenesis(store KVStore) (json.RawMessage, error)
+}
+
+// OptionExporter is optionally implemented by plugins that can dump
+// their state as the SetOption calls that would rebuild it.
+// It is used to export the state into a new genesis file.
+type OptionExporter interface {
+	ExportOptions(store KVStore) []PluginOption
+}
+
+// PluginOption is one key/value pair for Plugin.SetOption
+type PluginOption struct {
+	Key   string
+	Value string
+}
+
 //----------------------------------------
 
 type CallContext struct {
-	CallerAddress []byte   // Caller's Address (hash of PubKey)
-	CallerAccount *Account // Caller's Account, w/ fee & TxInputs deducted
-	Coins         Coins    // The coins that the caller wishes to spend, excluding fees
+	CallerAddress []byte    // Caller's Address (hash of PubKey)
+	CallerAccount *Account  // Caller's Account, w/ fee & TxInputs deducted
+	CallerChainID string    // Chain of the caller, if it called over IBC. Empty for local callers.
+	Coins         Coins     // The coins that the caller wishes to spend, excluding fees
+	GasMeter      *GasMeter // Gas left for this tx, nil if unmetered. The store is already charged to it.
 }
 
 func NewCallContext(callerAddress []byte, callerAccount *Account, coins Coins) CallContext {
@@ -3,6 +3,7 @@ package types
 import (
 	"bytes"
 	"encoding/json"
+	"math"
 
 	wrsp "github.com/tepleton/wrsp/types"
 	"github.com/tepleton/go-crypto"
@@ -195,9 +196,12 @@ func (tx *SendTx) String() string {
 
 //-----------------------------------------------------------------------------
 
+// If Gas is zero, Fee is a flat fee and execution is not metered.
+// Otherwise Gas is the limit, Fee the price per unit of gas,
+// and all unused gas is refunded.
 type AppTx struct {
-	Gas   int64           `json:"gas"`   // Gas
-	Fee   Coin            `json:"fee"`   // Fee
+	Gas   int64           `json:"gas"`   // Gas limit
+	Fee   Coin            `json:"fee"`   // Fee, or gas price if Gas > 0
 	Name  string          `json:"type"`  // Which plugin
 	Input TxInput         `json:"input"` // Hmmm do we want coins?
 	Data  json.RawMessage `json:"data"`
@@ -212,6 +216,28 @@ func (tx *AppTx) SignBytes(chainID string) []byte {
 	return signBytes
 }
 
+func (tx *AppTx) ValidateBasic() wrsp.Result {
+	if tx.Gas < 0 {
+		return wrsp.ErrBaseInvalidInput.AppendLog("Gas cannot be negative")
+	}
+	if tx.Fee.Amount < 0 {
+		return wrsp.ErrBaseInvalidInput.AppendLog("Fee cannot be negative")
+	}
+	// the max fee must not overflow
+	if tx.Fee.Amount > 0 && tx.Gas > math.MaxInt64/tx.Fee.Amount {
+		return wrsp.ErrBaseInvalidInput.AppendLog(Fmt("Fee %v for %v gas is too large", tx.Fee, tx.Gas))
+	}
+	return tx.Input.ValidateBasic()
+}
+
+// MaxFee is the most this tx can cost
+func (tx *AppTx) MaxFee() Coins {
+	if tx.Gas == 0 {
+		return Coins{tx.Fee}
+	}
+	return Coins{{tx.Fee.Denom, tx.Fee.Amount * tx.Gas}}
+}
+
 func (tx *AppTx) SetSignature(sig crypto.Signature) bool {
 	tx.Input.Signature = sig
 	return true
@@ -3,6 +3,7 @@ package types
 import (
 	"encoding/hex"
 	"fmt"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -67,6 +68,35 @@ func TestAppTxSignable(t *testing.T) {
 		"Got unexpected sign string for SendTx. Expected:\n%v\nGot:\n%v", expected, signBytesHex)
 }
 
+func TestAppTxValidateBasic(t *testing.T) {
+	input := TxInput{
+		Address:  make([]byte, 20),
+		Coins:    Coins{{"atom", 12345}},
+		Sequence: 2,
+	}
+
+	cases := []struct {
+		gas   int64
+		fee   Coin
+		valid bool
+	}{
+		{0, Coin{"atom", 10}, true},
+		{1000, Coin{"atom", 10}, true},
+		{math.MaxInt64 / 10, Coin{"atom", 10}, true},
+		{-1, Coin{"atom", 10}, false},
+		{1000, Coin{"atom", -10}, false},
+		// the max fee would overflow
+		{math.MaxInt64/10 + 1, Coin{"atom", 10}, false},
+		{math.MaxInt64, Coin{"atom", 2}, false},
+	}
+
+	for i, tc := range cases {
+		tx := &AppTx{Gas: tc.gas, Fee: tc.fee, Name: "X", Input: input}
+		res := tx.ValidateBasic()
+		assert.Equal(t, tc.valid, res.IsOK(), "%d: %s", i, res.Log)
+	}
+}
+
 func TestSendTxJSON(t *testing.T) {
 	assert, require := assert.New(t), require.New(t)
 